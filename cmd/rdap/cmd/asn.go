@@ -133,11 +133,14 @@ func buildASNTableRows(data interface{}) [][]string {
 	rows = appendBasicInfo(rows, mapData)
 
 	// Add entity information
-	rows = appendEntityInfo(rows, mapData)
+	rows = appendEntityInfo(rows, mapData, parseEmptiedFields(mapData))
 
 	// Add events information
 	rows = appendEventsInfo(rows, mapData)
 
+	// Add redaction information
+	rows = appendRedactedInfo(rows, mapData)
+
 	return rows
 }
 
@@ -157,12 +160,12 @@ func appendBasicInfo(rows [][]string, mapData map[string]interface{}) [][]string
 	return rows
 }
 
-func appendEntityInfo(rows [][]string, mapData map[string]interface{}) [][]string {
+func appendEntityInfo(rows [][]string, mapData map[string]interface{}, emptied emptiedFields) [][]string {
 	if entities, ok := mapData["entities"].([]interface{}); ok {
 		for _, entity := range entities {
 			if entityMap, ok := entity.(map[string]interface{}); ok {
 				rows = appendEntityRoles(rows, entityMap)
-				rows = appendEntityContact(rows, entityMap)
+				rows = appendEntityContact(rows, entityMap, emptied)
 			}
 		}
 	}
@@ -179,22 +182,23 @@ func appendEntityRoles(rows [][]string, entityMap map[string]interface{}) [][]st
 	return rows
 }
 
-func appendEntityContact(rows [][]string, entityMap map[string]interface{}) [][]string {
+func appendEntityContact(rows [][]string, entityMap map[string]interface{}, emptied emptiedFields) [][]string {
 	if vcardArray, ok := entityMap["vcardArray"].([]interface{}); ok && len(vcardArray) > 1 {
 		if vcardFields, ok := vcardArray[1].([]interface{}); ok {
 			for _, field := range vcardFields {
-				if fieldData, ok := field.([]interface{}); ok && len(fieldData) >= 3 {
+				if fieldData, ok := field.([]interface{}); ok && len(fieldData) >= 4 {
+					redacted := emptied.has(entityMap, fieldData[0])
 					switch fieldData[0] {
 					case "fn":
-						if name, ok := fieldData[3].(string); ok {
+						if name, ok := vcardText(fieldData[3], redacted); ok {
 							rows = append(rows, []string{"Contact Name", name})
 						}
 					case "email":
-						if email, ok := fieldData[3].(string); ok {
+						if email, ok := vcardText(fieldData[3], redacted); ok {
 							rows = append(rows, []string{"Email", email})
 						}
 					case "tel":
-						if phone, ok := fieldData[3].(string); ok {
+						if phone, ok := vcardText(fieldData[3], redacted); ok {
 							rows = append(rows, []string{"Phone", phone})
 						}
 					}
@@ -0,0 +1,301 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+
+	rdapcache "github.com/ohelal/rdap/internal/cache"
+	"github.com/spf13/cobra"
+)
+
+var (
+	cacheServer      string
+	cacheToken       string
+	cacheExportFile  string
+	cachePrefix      string
+	cacheImportBatch int
+)
+
+// cacheAdmin calls the admin cache endpoints of an RDAP server
+type cacheAdmin struct {
+	server string
+	token  string
+	client *http.Client
+}
+
+// newCacheAdmin returns the admin client for --server, or an error when it is not set
+func newCacheAdmin(streaming bool) (*cacheAdmin, error) {
+	if cacheServer == "" {
+		return nil, fmt.Errorf("this command requires --server or RDAP_SERVER")
+	}
+	client := &http.Client{Timeout: timeout}
+	if streaming {
+		client.Timeout = 0
+	}
+	return &cacheAdmin{server: strings.TrimSuffix(cacheServer, "/"), token: cacheToken, client: client}, nil
+}
+
+// do sends a request to an admin endpoint and returns the response, which the caller closes,
+// or an error for any non-2xx status
+func (a *cacheAdmin) do(method, path string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, a.server+"/admin"+path, body)
+	if err != nil {
+		return nil, err
+	}
+	if a.token != "" {
+		req.Header.Set("Authorization", "Bearer "+a.token)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		if json.NewDecoder(resp.Body).Decode(&apiErr) == nil && apiErr.Error != "" {
+			return nil, fmt.Errorf("%s: %s", resp.Status, apiErr.Error)
+		}
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	return resp, nil
+}
+
+// call sends a request and decodes the JSON response into out
+func (a *cacheAdmin) call(method, path string, body io.Reader, out interface{}) error {
+	resp, err := a.do(method, path, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// printServerStats prints the stats of every tier of a server cache
+func printServerStats(stats rdapcache.Stats) {
+	switch outputStyle {
+	case "json":
+		fmt.Println(formatJSON(stats, true))
+	case "table":
+		headers := []string{"Tier", "Type", "Entries"}
+		var rows [][]string
+		for _, tier := range stats.Tiers {
+			rows = append(rows, []string{tier.Name, "total", fmt.Sprintf("%d", tier.Entries)})
+			for _, objectType := range sortedTypes(tier.Types) {
+				rows = append(rows, []string{tier.Name, objectType, fmt.Sprintf("%d", tier.Types[objectType])})
+			}
+		}
+		renderTable(headers, rows)
+	default:
+		fmt.Printf("Cache Statistics:\n")
+		for _, tier := range stats.Tiers {
+			fmt.Printf("%s: %d entries, %d bytes\n", tier.Name, tier.Entries, tier.Bytes)
+			for _, objectType := range sortedTypes(tier.Types) {
+				fmt.Printf("  %s: %d\n", objectType, tier.Types[objectType])
+			}
+		}
+	}
+}
+
+func sortedTypes(types map[string]int64) []string {
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+var cacheGetCmd = &cobra.Command{
+	Use:          "get KEY",
+	Short:        "Show a server cache entry, such as domain:example.com",
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		admin, err := newCacheAdmin(false)
+		if err != nil {
+			return err
+		}
+		var entry map[string]interface{}
+		if err := admin.call(http.MethodGet, "/cache/keys/"+url.PathEscape(args[0]), nil, &entry); err != nil {
+			return err
+		}
+		fmt.Println(formatJSON(entry, true))
+		return nil
+	},
+}
+
+var cacheDeleteCmd = &cobra.Command{
+	Use:          "delete KEY...",
+	Short:        "Remove keys from the server cache on every replica",
+	Args:         cobra.MinimumNArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		admin, err := newCacheAdmin(false)
+		if err != nil {
+			return err
+		}
+		for _, key := range args {
+			var result struct {
+				Removed int `json:"removed"`
+			}
+			if err := admin.call(http.MethodDelete, "/cache/keys/"+url.PathEscape(key), nil, &result); err != nil {
+				return fmt.Errorf("%s: %v", key, err)
+			}
+			fmt.Printf("%s\n", successStyle(fmt.Sprintf("Removed %s (%d entries)", key, result.Removed)))
+		}
+		return nil
+	},
+}
+
+var cachePurgeCmd = &cobra.Command{
+	Use:          "purge PREFIX",
+	Short:        "Remove every server cache key starting with a prefix, such as neg: or domain:",
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		admin, err := newCacheAdmin(false)
+		if err != nil {
+			return err
+		}
+		var result struct {
+			Removed int `json:"removed"`
+		}
+		if err := admin.call(http.MethodDelete, "/cache?prefix="+url.QueryEscape(args[0]), nil, &result); err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", successStyle(fmt.Sprintf("Removed %d entries", result.Removed)))
+		return nil
+	},
+}
+
+var cacheExportCmd = &cobra.Command{
+	Use:          "export",
+	Short:        "Export server cache entries as JSON lines",
+	Args:         cobra.NoArgs,
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		admin, err := newCacheAdmin(true)
+		if err != nil {
+			return err
+		}
+		resp, err := admin.do(http.MethodGet, "/cache/export?prefix="+url.QueryEscape(cachePrefix), nil)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		out := os.Stdout
+		if cacheExportFile != "" && cacheExportFile != "-" {
+			out, err = os.Create(cacheExportFile)
+			if err != nil {
+				return err
+			}
+			defer out.Close()
+		}
+		if _, err := io.Copy(out, resp.Body); err != nil {
+			return err
+		}
+		if out != os.Stdout {
+			fmt.Fprintf(os.Stderr, "%s\n", successStyle("Exported cache to "+cacheExportFile))
+		}
+		return nil
+	},
+}
+
+var cacheImportCmd = &cobra.Command{
+	Use:   "import FILE",
+	Short: "Import cache entries exported with \"rdap cache export\"",
+	Long: `Import cache entries exported with "rdap cache export" into the server cache. Entries
+are sent in batches of at most --batch-size bytes to stay under the server's request body limit.
+Entries that have expired are skipped.`,
+	Args:         cobra.ExactArgs(1),
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		admin, err := newCacheAdmin(true)
+		if err != nil {
+			return err
+		}
+		in := os.Stdin
+		if args[0] != "-" {
+			in, err = os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer in.Close()
+		}
+
+		var imported, skipped int
+		send := func(batch []byte) error {
+			var result struct {
+				Imported int `json:"imported"`
+				Skipped  int `json:"skipped"`
+			}
+			if err := admin.call(http.MethodPost, "/cache/import", bytes.NewReader(batch), &result); err != nil {
+				return err
+			}
+			imported += result.Imported
+			skipped += result.Skipped
+			return nil
+		}
+
+		var batch bytes.Buffer
+		reader := bufio.NewReader(in)
+		for {
+			line, err := reader.ReadBytes('\n')
+			if len(line) > 0 {
+				if batch.Len() > 0 && batch.Len()+len(line) > cacheImportBatch {
+					if err := send(batch.Bytes()); err != nil {
+						return err
+					}
+					batch.Reset()
+				}
+				batch.Write(line)
+				if line[len(line)-1] != '\n' {
+					batch.WriteByte('\n')
+				}
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+		}
+		if batch.Len() > 0 {
+			if err := send(batch.Bytes()); err != nil {
+				return err
+			}
+		}
+		fmt.Printf("%s\n", successStyle(fmt.Sprintf("Imported %d entries, skipped %d expired", imported, skipped)))
+		return nil
+	},
+}
+
+func init() {
+	cacheCmd.PersistentFlags().StringVar(&cacheServer, "server", os.Getenv("RDAP_SERVER"), "RDAP server whose cache to manage, such as http://localhost:8080")
+	cacheCmd.PersistentFlags().StringVar(&cacheToken, "token", os.Getenv("RDAP_ADMIN_TOKEN"), "Admin token of the server")
+
+	cacheExportCmd.Flags().StringVarP(&cacheExportFile, "output", "o", "-", "File to write the export to")
+	cacheExportCmd.Flags().StringVar(&cachePrefix, "prefix", "", "Export only keys starting with this prefix")
+	cacheImportCmd.Flags().IntVar(&cacheImportBatch, "batch-size", 2<<20, "Maximum bytes sent per request")
+
+	cacheCmd.AddCommand(cacheGetCmd)
+	cacheCmd.AddCommand(cacheDeleteCmd)
+	cacheCmd.AddCommand(cachePurgeCmd)
+	cacheCmd.AddCommand(cacheExportCmd)
+	cacheCmd.AddCommand(cacheImportCmd)
+}
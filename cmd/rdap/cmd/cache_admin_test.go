@@ -0,0 +1,84 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	rdapcache "github.com/ohelal/rdap/internal/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newAdminServer serves the export and import endpoints of a cache behind a bearer token. It
+// returns the server URL and the number of import requests received.
+func newAdminServer(t *testing.T, cm *rdapcache.CacheManager, token string) (string, *int) {
+	imports := new(int)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/admin/cache/export", func(w http.ResponseWriter, r *http.Request) {
+		cm.Export(r.Context(), w, r.URL.Query().Get("prefix"))
+	})
+	mux.HandleFunc("/admin/cache/import", func(w http.ResponseWriter, r *http.Request) {
+		*imports++
+		imported, skipped, err := cm.Import(r.Context(), r.Body)
+		if err != nil {
+			http.Error(w, `{"error":"invalid export"}`, http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]int{"imported": imported, "skipped": skipped})
+	})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			http.Error(w, `{"error":"invalid admin token"}`, http.StatusUnauthorized)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL, imports
+}
+
+func TestCacheExportImport(t *testing.T) {
+	newCache := func() *rdapcache.CacheManager {
+		cm, err := rdapcache.NewCacheManager(&rdapcache.CacheConfig{MaxLocalSize: rdapcache.MinLocalSize, LocalTTL: time.Hour})
+		require.NoError(t, err)
+		t.Cleanup(func() { cm.Close() })
+		return cm
+	}
+	src, dst := newCache(), newCache()
+	keys := []string{"domain:example.com", "domain:example.net", "domain:example.org"}
+	for _, key := range keys {
+		require.NoError(t, src.SetValue(key, `{"ldhName":"`+key+`"}`))
+	}
+	require.NoError(t, src.SetValue("autnum:64496", `{"handle":"AS64496"}`))
+
+	cacheToken = "admin-token"
+	t.Cleanup(func() {
+		cacheServer, cacheToken, cacheExportFile, cachePrefix, cacheImportBatch = "", "", "-", "", 2<<20
+	})
+
+	cacheServer, _ = newAdminServer(t, src, cacheToken)
+	cacheExportFile = filepath.Join(t.TempDir(), "cache.jsonl")
+	cachePrefix = "domain:"
+	require.NoError(t, cacheExportCmd.RunE(cacheExportCmd, nil))
+
+	// A batch holds a single entry, so each is sent on its own
+	var imports *int
+	cacheServer, imports = newAdminServer(t, dst, cacheToken)
+	cacheImportBatch = 1
+	require.NoError(t, cacheImportCmd.RunE(cacheImportCmd, []string{cacheExportFile}))
+	assert.Equal(t, len(keys), *imports)
+	for _, key := range keys {
+		val, found := dst.GetValue(key)
+		require.True(t, found, key)
+		assert.Equal(t, `{"ldhName":"`+key+`"}`, val)
+	}
+	_, found := dst.GetValue("autnum:64496")
+	assert.False(t, found)
+
+	cacheToken = "wrong"
+	assert.ErrorContains(t, cacheImportCmd.RunE(cacheImportCmd, []string{cacheExportFile}), "invalid admin token")
+}
@@ -2,21 +2,46 @@ package cmd
 
 import (
 	"fmt"
+	"net/http"
+	"strings"
+
+	rdapcache "github.com/ohelal/rdap/internal/cache"
 	"github.com/spf13/cobra"
 )
 
 var cacheCmd = &cobra.Command{
 	Use:   "cache",
 	Short: "Manage RDAP query cache",
-	Long: `Manage the RDAP query cache. Available subcommands:
+	Long: `Manage the RDAP query cache. With --server, stats and clear act on the cache of an
+RDAP server through its admin API instead of this process. Available subcommands:
   - stats: Show cache statistics
-  - clear: Clear the cache`,
+  - clear: Clear the cache
+  - get: Show a server cache entry
+  - delete: Remove keys from the server cache
+  - purge: Remove server cache keys by prefix
+  - export: Export server cache entries
+  - import: Import exported entries into the server cache
+  - train-dict: Train a zstd dictionary for server cache compression`,
 }
 
 var cacheStatsCmd = &cobra.Command{
-	Use:   "stats",
-	Short: "Show cache statistics",
-	Run: func(cmd *cobra.Command, args []string) {
+	Use:          "stats",
+	Short:        "Show cache statistics",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if cacheServer != "" {
+			admin, err := newCacheAdmin(false)
+			if err != nil {
+				return err
+			}
+			var stats rdapcache.Stats
+			if err := admin.call(http.MethodGet, "/cache/stats", nil, &stats); err != nil {
+				return err
+			}
+			printServerStats(stats)
+			return nil
+		}
+
 		stats := getCacheStats()
 		switch outputStyle {
 		case "json":
@@ -37,14 +62,33 @@ var cacheStatsCmd = &cobra.Command{
 				fmt.Printf("%s: %v\n", key, value)
 			}
 		}
+		return nil
 	},
 }
 
 var cacheClearCmd = &cobra.Command{
-	Use:   "clear",
-	Short: "Clear the cache",
-	Run: func(cmd *cobra.Command, args []string) {
-		clearCache()
+	Use:          "clear",
+	Short:        "Clear the cache",
+	SilenceUsage: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if cacheServer == "" {
+			clearCache()
+			return nil
+		}
+
+		admin, err := newCacheAdmin(false)
+		if err != nil {
+			return err
+		}
+		var result struct {
+			Removed int `json:"removed"`
+		}
+		body := strings.NewReader(`{"pattern":"*"}`)
+		if err := admin.call(http.MethodPost, "/cache/invalidate", body, &result); err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", successStyle(fmt.Sprintf("Cache cleared (%d entries)", result.Removed)))
+		return nil
 	},
 }
 
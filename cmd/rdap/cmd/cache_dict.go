@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	rdapcache "github.com/ohelal/rdap/internal/cache"
+	"github.com/spf13/cobra"
+)
+
+var (
+	dictOutput string
+	dictSize   int
+)
+
+var cacheTrainDictCmd = &cobra.Command{
+	Use:   "train-dict [files or directories...]",
+	Short: "Train a zstd dictionary for server cache compression",
+	Long: `Train a zstd dictionary from sample RDAP responses, such as JSON files saved with
+"rdap domain example.com -f json". Directories are searched for .json files. Point the
+server's cache.compression.dictionary setting at the result.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var samples [][]byte
+		for _, arg := range args {
+			err := filepath.WalkDir(arg, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					return err
+				}
+				if d.IsDir() || (path != arg && filepath.Ext(path) != ".json") {
+					return nil
+				}
+				data, err := os.ReadFile(path)
+				if err != nil {
+					return err
+				}
+				samples = append(samples, data)
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if len(samples) == 0 {
+			return fmt.Errorf("no samples found")
+		}
+
+		dictionary, err := rdapcache.TrainDictionary(samples, dictSize)
+		if err != nil {
+			return fmt.Errorf("failed to train dictionary: %v", err)
+		}
+		if err := os.WriteFile(dictOutput, dictionary, 0o644); err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", successStyle(fmt.Sprintf("Wrote %d byte dictionary from %d samples to %s", len(dictionary), len(samples), dictOutput)))
+		return nil
+	},
+}
+
+func init() {
+	cacheCmd.AddCommand(cacheTrainDictCmd)
+	cacheTrainDictCmd.Flags().StringVarP(&dictOutput, "output", "o", "rdap.dict", "Dictionary file to write")
+	cacheTrainDictCmd.Flags().IntVar(&dictSize, "size", 64<<10, "Maximum dictionary size in bytes")
+}
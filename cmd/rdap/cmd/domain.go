@@ -75,6 +75,7 @@ func renderDomainResult(typ, query string, data interface{}) {
 					rows = append(rows, []string{"Status", strings.Join(statusStr, ", ")})
 				}
 			}
+			rows = appendRedactedInfo(rows, mapData)
 		}
 
 		renderTable(headers, rows)
@@ -11,9 +11,10 @@ func formatRDAPResult(data map[string]interface{}) string {
 
 	formatBasicInfo(&sb, data)
 	formatStatus(&sb, data)
-	formatEntities(&sb, data)
+	formatEntities(&sb, data, parseEmptiedFields(data))
 	formatEvents(&sb, data)
 	formatRemarks(&sb, data)
+	formatRedacted(&sb, data)
 
 	return sb.String()
 }
@@ -36,13 +37,13 @@ func formatStatus(sb *strings.Builder, data map[string]interface{}) {
 	}
 }
 
-func formatEntities(sb *strings.Builder, data map[string]interface{}) {
+func formatEntities(sb *strings.Builder, data map[string]interface{}, emptied emptiedFields) {
 	if entities, ok := data["entities"].([]interface{}); ok {
 		for _, e := range entities {
 			if entity, ok := e.(map[string]interface{}); ok {
 				sb.WriteString("\nEntity:\n")
 				formatEntityRoles(sb, entity)
-				formatVCardInfo(sb, entity)
+				formatVCardInfo(sb, entity, emptied)
 			}
 		}
 	}
@@ -57,29 +58,30 @@ func formatEntityRoles(sb *strings.Builder, entity map[string]interface{}) {
 	}
 }
 
-func formatVCardInfo(sb *strings.Builder, entity map[string]interface{}) {
+func formatVCardInfo(sb *strings.Builder, entity map[string]interface{}, emptied emptiedFields) {
 	if vcardArray, ok := entity["vcardArray"].([]interface{}); ok && len(vcardArray) > 1 {
 		if vcardData, ok := vcardArray[1].([]interface{}); ok {
 			for _, field := range vcardData {
-				formatVCardField(sb, field)
+				formatVCardField(sb, field, entity, emptied)
 			}
 		}
 	}
 }
 
-func formatVCardField(sb *strings.Builder, field interface{}) {
-	if fieldData, ok := field.([]interface{}); ok && len(fieldData) >= 3 {
+func formatVCardField(sb *strings.Builder, field interface{}, entity map[string]interface{}, emptied emptiedFields) {
+	if fieldData, ok := field.([]interface{}); ok && len(fieldData) >= 4 {
+		redacted := emptied.has(entity, fieldData[0])
 		switch fieldData[0] {
 		case "fn":
-			if name, ok := fieldData[3].(string); ok {
+			if name, ok := vcardText(fieldData[3], redacted); ok {
 				sb.WriteString(fmt.Sprintf("  Name: %s\n", name))
 			}
 		case "email":
-			if email, ok := fieldData[3].(string); ok {
+			if email, ok := vcardText(fieldData[3], redacted); ok {
 				sb.WriteString(fmt.Sprintf("  Email: %s\n", email))
 			}
 		case "tel":
-			if phone, ok := fieldData[3].(string); ok {
+			if phone, ok := vcardText(fieldData[3], redacted); ok {
 				sb.WriteString(fmt.Sprintf("  Phone: %s\n", phone))
 			}
 		}
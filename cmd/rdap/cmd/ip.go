@@ -85,6 +85,7 @@ func renderIPResult(typ, query string, data interface{}) {
 					rows = append(rows, []string{"Status", strings.Join(statusStr, ", ")})
 				}
 			}
+			rows = appendRedactedInfo(rows, mapData)
 		}
 
 		renderTable(headers, rows)
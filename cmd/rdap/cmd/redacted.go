@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ohelal/rdap/internal/models"
+)
+
+// redactedPlaceholder is shown in place of vCard values emptied by the registry
+const redactedPlaceholder = "[redacted]"
+
+func formatRedacted(sb *strings.Builder, data map[string]interface{}) {
+	redacted, err := models.ParseRedacted(data)
+	if err != nil || len(redacted) == 0 {
+		return
+	}
+
+	sb.WriteString("\nRedacted by registry:\n")
+	for _, r := range redacted {
+		sb.WriteString(fmt.Sprintf("  %s\n", describeRedacted(r)))
+		if path := r.Path(); path != "" {
+			sb.WriteString(fmt.Sprintf("    Path: %s\n", path))
+		}
+	}
+}
+
+func appendRedactedInfo(rows [][]string, mapData map[string]interface{}) [][]string {
+	redacted, err := models.ParseRedacted(mapData)
+	if err != nil {
+		return rows
+	}
+	for _, r := range redacted {
+		rows = append(rows, []string{"Redacted", describeRedacted(r)})
+	}
+	return rows
+}
+
+// describeRedacted renders a redaction as "<name> (<method>): <reason>"
+func describeRedacted(r *models.Redacted) string {
+	name := r.Name.String()
+	if name == "" {
+		name = "unnamed field"
+	}
+	desc := fmt.Sprintf("%s (%s)", name, r.RedactionMethod())
+	if reason := r.Reason.String(); reason != "" {
+		desc += ": " + reason
+	}
+	return desc
+}
+
+// emptiedFields holds the JSONPaths of the top-level entity vCard values the registry emptied
+type emptiedFields map[string]bool
+
+// parseEmptiedFields collects the emptyValue redactions of a response
+func parseEmptiedFields(data map[string]interface{}) emptiedFields {
+	redacted, err := models.ParseRedacted(data)
+	if err != nil {
+		return nil
+	}
+	fields := make(emptiedFields)
+	for _, r := range redacted {
+		if r.RedactionMethod() == models.RedactionMethodEmptyValue {
+			fields[r.Path()] = true
+		}
+	}
+	return fields
+}
+
+// has reports whether the registry emptied a vCard property of an entity, which RFC 9537
+// identifies by the entity's first role
+func (f emptiedFields) has(entity map[string]interface{}, property interface{}) bool {
+	roles, _ := entity["roles"].([]interface{})
+	if len(f) == 0 || len(roles) == 0 {
+		return false
+	}
+	path := fmt.Sprintf("$.entities[?(@.roles[0]=='%v')].vcardArray[1][?(@[0]=='%v')][3]", roles[0], property)
+	return f[path]
+}
+
+// vcardText returns a printable vCard text value, marking values emptied by the registry as
+// redacted
+func vcardText(v interface{}, emptied bool) (string, bool) {
+	text, ok := v.(string)
+	if !ok {
+		return "", false
+	}
+	if text == "" && emptied {
+		return redactedPlaceholder, true
+	}
+	return text, true
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// redactedAnswer has a registrant whose email the registry emptied and whose phone number
+// was already empty upstream
+const redactedAnswer = `{
+	"handle": "EXAMPLE-1",
+	"entities": [
+		{
+			"roles": ["registrant"],
+			"vcardArray": ["vcard", [
+				["fn", {}, "text", "Jane Doe"],
+				["email", {}, "text", ""],
+				["tel", {}, "uri", ""]
+			]]
+		}
+	],
+	"redacted": [
+		{
+			"name": {"type": "Registrant Email"},
+			"postPath": "$.entities[?(@.roles[0]=='registrant')].vcardArray[1][?(@[0]=='email')][3]",
+			"method": "emptyValue",
+			"reason": {"description": "Server policy"}
+		},
+		{
+			"name": {"description": "Registry Domain ID"},
+			"prePath": "$.handle"
+		}
+	]
+}`
+
+func TestRedactedOutput(t *testing.T) {
+	var data map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(redactedAnswer), &data))
+
+	t.Run("Text", func(t *testing.T) {
+		out := formatRDAPResult(data)
+		assert.Contains(t, out, "  Name: Jane Doe\n")
+		assert.Contains(t, out, "  Email: [redacted]\n")
+		assert.Contains(t, out, "  Phone: \n", "values empty upstream are not marked redacted")
+		assert.Contains(t, out, "\nRedacted by registry:\n"+
+			"  Registrant Email (emptyValue): Server policy\n"+
+			"    Path: $.entities[?(@.roles[0]=='registrant')].vcardArray[1][?(@[0]=='email')][3]\n"+
+			"  Registry Domain ID (removal)\n"+
+			"    Path: $.handle\n")
+	})
+
+	t.Run("Table", func(t *testing.T) {
+		rows := appendEntityInfo(nil, data, parseEmptiedFields(data))
+		assert.Contains(t, rows, []string{"Email", "[redacted]"})
+		assert.Contains(t, rows, []string{"Phone", ""})
+
+		rows = appendRedactedInfo(nil, data)
+		assert.Equal(t, [][]string{
+			{"Redacted", "Registrant Email (emptyValue): Server policy"},
+			{"Redacted", "Registry Domain ID (removal)"},
+		}, rows)
+	})
+
+	t.Run("No redactions", func(t *testing.T) {
+		plain := map[string]interface{}{"handle": "EXAMPLE-1"}
+		assert.NotContains(t, formatRDAPResult(plain), "Redacted")
+		assert.Empty(t, appendRedactedInfo(nil, plain))
+		assert.False(t, parseEmptiedFields(plain).has(map[string]interface{}{"roles": []interface{}{"registrant"}}, "email"))
+	})
+}
@@ -15,6 +15,8 @@ var (
 	format      string
 	client      *rdap.Client
 	outputStyle string
+	proxyURL    string
+	noProxy     []string
 )
 
 var rootCmd = &cobra.Command{
@@ -29,6 +31,9 @@ Supports queries for domains, IP addresses, and Autonomous System Numbers (ASN).
 		if baseURL != "" {
 			opts = append(opts, rdap.WithBaseURL(baseURL))
 		}
+		if proxyURL != "" {
+			opts = append(opts, rdap.WithProxy(proxyURL, noProxy...))
+		}
 		client = rdap.NewClient(opts...)
 	},
 }
@@ -45,5 +50,7 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&baseURL, "base-url", "", "Base URL for RDAP server")
 	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Enable verbose output")
 	rootCmd.PersistentFlags().StringVarP(&format, "format", "f", "pretty", "Output format: pretty, json, or compact")
+	rootCmd.PersistentFlags().StringVar(&proxyURL, "proxy", "", "Proxy URL for outbound requests (http://, https:// or socks5://); defaults to HTTPS_PROXY")
+	rootCmd.PersistentFlags().StringSliceVar(&noProxy, "no-proxy", nil, "Hosts, domains or CIDR blocks to reach without the proxy")
 	rootCmd.PersistentFlags().StringVarP(&outputStyle, "style", "s", "default", "Output style: default, table, box")
 }
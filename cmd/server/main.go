@@ -10,11 +10,11 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
 
-	"github.com/go-redis/redis/v8"
 	"github.com/gofiber/adaptor/v2"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/compress"
@@ -27,15 +27,16 @@ import (
 	"github.com/ohelal/rdap/internal/kafka"
 	"github.com/ohelal/rdap/internal/metrics"
 	"github.com/ohelal/rdap/internal/middleware"
+	"github.com/ohelal/rdap/internal/redisclient"
 	"github.com/ohelal/rdap/internal/service"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
 // Default configuration values
 const (
-	defaultRedisURL    = "redis:6379"
 	defaultPort        = "8080"
 	defaultMetricsPort = "9090"
+	queryTopic         = "rdap-queries"
 )
 
 func main() {
@@ -48,26 +49,28 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
 
 	// Initialize metrics collector
 	metricsCollector := metrics.NewMetrics()
 
-	// Initialize Redis client with fallback to default
-	redisURL := os.Getenv("REDIS_URL")
-	if redisURL == "" {
-		redisURL = defaultRedisURL
-	}
-
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:         redisURL,
-		DB:           0,
-		PoolSize:     50,
-		MinIdleConns: 10,
-		MaxRetries:   3,
-		DialTimeout:  5 * time.Second,
-		ReadTimeout:  3 * time.Second,
-		WriteTimeout: 3 * time.Second,
-	})
+	// Initialize the Redis client shared by the cache and the rate limiter. The environment
+	// overrides the configured standalone address and credentials.
+	if redisURL := os.Getenv("REDIS_URL"); redisURL != "" {
+		cfg.Redis.URL = redisURL
+	}
+	if password := os.Getenv("REDIS_PASSWORD"); password != "" {
+		cfg.Redis.Password = password
+	}
+	if db, err := strconv.Atoi(os.Getenv("REDIS_DB")); err == nil {
+		cfg.Redis.DB = db
+	}
+	redisClient, err := redisclient.New(cfg.Redis)
+	if err != nil {
+		log.Fatalf("Failed to configure Redis: %v", err)
+	}
 	defer redisClient.Close()
 
 	// Initialize Kafka producer
@@ -76,7 +79,7 @@ func main() {
 		kafkaBrokers = []string{"localhost:9092"}
 	}
 
-	producer, err := kafka.NewProducer(kafkaBrokers, "rdap-queries")
+	producer, err := kafka.NewProducer(kafkaBrokers, queryTopic)
 	if err != nil {
 		log.Fatalf("Failed to create Kafka producer: %v", err)
 	}
@@ -86,7 +89,7 @@ func main() {
 	consumer, err := kafka.NewConsumer(
 		kafkaBrokers,
 		"rdap-consumer-group",
-		[]string{"rdap-queries"},
+		[]string{queryTopic},
 		kafka.NewMessageHandler(),
 	)
 	if err != nil {
@@ -102,11 +105,26 @@ func main() {
 
 	// Initialize cache
 	cacheConfig := &cache.CacheConfig{
+		Tiers:        cfg.Cache.Tiers,
 		MaxLocalSize: 1024 * 1024 * 1024, // 1GB
 		LocalTTL:     time.Hour,
-		EnableRedis:  true,
-		RedisURL:     redisURL,
+		Redis:        redisClient,
 		RedisTTL:     time.Hour,
+		DiskPath:     cfg.Cache.Disk.Path,
+		DiskTTL:      cfg.Cache.Disk.TTL,
+		LocalCodec:   cfg.Cache.Compression.Local,
+		DiskCodec:    cfg.Cache.Compression.Disk,
+		RedisCodec:   cfg.Cache.Compression.Redis,
+
+		SnapshotDir:      cfg.Cache.Snapshot.Dir,
+		SnapshotInterval: cfg.Cache.Snapshot.Interval,
+	}
+	if path := cfg.Cache.Compression.Dictionary; path != "" {
+		dictionary, err := os.ReadFile(path)
+		if err != nil {
+			log.Fatalf("Failed to read cache compression dictionary: %v", err)
+		}
+		cacheConfig.Dictionary = dictionary
 	}
 
 	cacheManager, err := cache.NewCacheManager(cacheConfig)
@@ -114,6 +132,26 @@ func main() {
 		log.Fatalf("Failed to initialize cache: %v", err)
 	}
 	defer cacheManager.Close()
+	go cacheManager.StartSnapshots(ctx)
+	go cacheManager.Ranges().StartSweeps(ctx)
+
+	// Propagate cache invalidations to the local tier of every replica, over Kafka when it is
+	// enabled and Redis pub/sub otherwise
+	invalidationBus := cacheManager.RedisInvalidationBus(cfg.Cache.Invalidation.Channel)
+	if cfg.Kafka.Enabled && len(cfg.Kafka.Brokers) > 0 {
+		kafkaBus, err := kafka.NewInvalidationBus(cfg.Kafka.Brokers, cfg.Cache.Invalidation.KafkaTopic)
+		if err != nil {
+			log.Fatalf("Failed to create Kafka invalidation bus: %v", err)
+		}
+		invalidationBus = kafkaBus
+	}
+	if invalidationBus != nil {
+		go func() {
+			if err := cacheManager.StartInvalidation(ctx, invalidationBus); err != nil {
+				log.Printf("Cache invalidation stopped: %v", err)
+			}
+		}()
+	}
 
 	// Load bootstrap configurations
 	configDir := os.Getenv("CONFIG_DIR")
@@ -127,11 +165,43 @@ func main() {
 	}
 
 	// Initialize RDAP service
-	rdapService, err := service.NewRDAPService(dnsConfig, ipConfig, asnConfig, cfg)
+	rdapService, err := service.NewRDAPService(dnsConfig, ipConfig, asnConfig, cfg, cacheManager)
 	if err != nil {
 		log.Fatalf("Failed to initialize RDAP service: %v", err)
 	}
 
+	// Share upstream requests with the other replicas when distributed coalescing is enabled
+	rdapService.StartCoalescing(ctx, redisClient)
+
+	// Open connections to the busiest registries in the background
+	go rdapService.Prewarm(ctx)
+
+	// Refresh the most requested keys, holding readiness until enough of them are cached
+	var warmupSources []service.WarmupSource
+	for _, source := range cfg.Warmup.Sources {
+		switch source {
+		case "redis":
+			warmupSources = append(warmupSources, cacheManager.HotKeys)
+		case "file":
+			warmupSources = append(warmupSources, service.FileWarmupSource(cfg.Warmup.File))
+		case "kafka":
+			warmupSources = append(warmupSources, func(ctx context.Context, n int) ([]string, error) {
+				top, err := kafka.TopQueries(ctx, kafkaBrokers, queryTopic, cfg.Warmup.KafkaLookback, n)
+				if err != nil {
+					return nil, err
+				}
+				keys := make([]string, 0, len(top))
+				for _, q := range top {
+					if key, ok := service.QueryKey(q.Type, q.Query); ok {
+						keys = append(keys, key)
+					}
+				}
+				return keys, nil
+			})
+		}
+	}
+	rdapService.StartWarmup(ctx, warmupSources)
+
 	// Initialize handlers
 	handlers := handlers.NewHandlers(rdapService, metricsCollector, producer)
 
@@ -151,31 +221,58 @@ func main() {
 	// Middleware
 	app.Use(compress.New())
 	app.Use(recover.New())
+
+	// Admin API, mounted before the rate limiter: operators must be able to invalidate and
+	// inspect the cache while clients are being limited
+	admin := app.Group("/admin", middleware.AdminAuth(cfg.Admin))
+	admin.Get("/upstreams", rdapService.HandleUpstreamStats)
+	admin.Post("/cache/invalidate", rdapService.HandleInvalidate)
+	admin.Get("/cache/stats", rdapService.HandleCacheStats)
+	admin.Get("/cache/keys/*", rdapService.HandleCacheGet)
+	admin.Delete("/cache/keys/*", rdapService.HandleCacheDelete)
+	admin.Delete("/cache", rdapService.HandleCachePurge)
+	admin.Get("/cache/export", rdapService.HandleCacheExport)
+	admin.Post("/cache/import", rdapService.HandleCacheImport)
+
 	app.Use(middleware.NewDefaultRateLimiter(redisClient))
 
 	// Routes
 	app.Get("/metrics", adaptor.HTTPHandler(promhttp.Handler()))
 	app.Get("/health", handlers.HealthHandler)
+	app.Get("/ready", rdapService.HandleReady)
 	app.Get("/ip/:ip", handlers.IPLookupHandler)
 	app.Get("/domain/:domain", handlers.DomainLookupHandler)
 	app.Get("/autnum/:asn", handlers.ASNLookupHandler)
 
-	// Add graceful shutdown
+	// Reload upstream TLS material, credentials and proxies on SIGHUP; other settings need a restart
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			if err := rdapService.ReloadTransports(); err != nil {
+				log.Printf("Failed to reload upstream transport settings: %v", err)
+				continue
+			}
+			log.Println("Upstream transport settings reloaded")
+		}
+	}()
+
+	// Add graceful shutdown handler. It must be running before Listen blocks; once the
+	// server has stopped, Listen returns and the deferred cleanup, including the final cache
+	// snapshot, runs.
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
-
-	// Start server
-	log.Printf("Starting server on port %s...", cfg.Server.Port)
-	if err := app.Listen(fmt.Sprintf(":%s", cfg.Server.Port)); err != nil {
-		log.Fatalf("Server failed to start: %v", err)
-	}
-
-	// Add graceful shutdown handler
 	go func() {
 		<-quit
 		log.Println("Shutting down server...")
 		if err := app.ShutdownWithTimeout(10 * time.Second); err != nil {
-			log.Fatalf("Server forced to shutdown: %v", err)
+			log.Printf("Server forced to shutdown: %v", err)
 		}
 	}()
+
+	// Start server
+	log.Printf("Starting server on port %s...", cfg.Server.Port)
+	if err := app.Listen(fmt.Sprintf(":%s", cfg.Server.Port)); err != nil {
+		log.Fatalf("Server failed to start: %v", err)
+	}
 }
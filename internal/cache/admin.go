@@ -0,0 +1,177 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Stats describes the contents of each cache tier, in the order they are read
+type Stats struct {
+	Tiers []TierStats `json:"tiers"`
+}
+
+// TierStats counts the entries of a cache tier by object type: TypeDomain, TypeIP,
+// TypeAutnum and "negative" for negative entries
+type TierStats struct {
+	Name     string           `json:"name"`
+	Entries  int64            `json:"entries"`
+	Bytes    uint64           `json:"bytes"`
+	MaxBytes uint64           `json:"maxBytes,omitempty"`
+	Types    map[string]int64 `json:"types"`
+	// Gets and Misses count local reads since startup
+	Gets   uint64 `json:"gets,omitempty"`
+	Misses uint64 `json:"misses,omitempty"`
+}
+
+// ExportedEntry is one line of a cache export. The value is stored uncompressed so it can be
+// imported by servers using other codecs.
+type ExportedEntry struct {
+	Key   string `json:"key"`
+	Entry *Entry `json:"entry"`
+	Value []byte `json:"value"`
+}
+
+// KeyType returns the object type that a cache key is counted under in Stats
+func KeyType(key string) string {
+	if strings.HasPrefix(key, NegativePrefix) {
+		return "negative"
+	}
+	objectType, _, _ := strings.Cut(key, ":")
+	switch objectType {
+	case TypeDomain, TypeIP, TypeAutnum:
+		return objectType
+	}
+	return "other"
+}
+
+// Stats counts the entries of every tier. Disk and Redis tiers are scanned in full, which
+// takes a while on large databases.
+func (cm *CacheManager) Stats(ctx context.Context) (Stats, error) {
+	var stats Stats
+	for _, t := range cm.tiers {
+		var tierStats TierStats
+		if s, ok := t.cache.(sizer); ok {
+			tierStats = s.size(ctx)
+		}
+		tierStats.Name = t.name
+		tierStats.Types = make(map[string]int64)
+		for _, prefix := range []string{TypeDomain + ":", TypeIP + ":", TypeAutnum + ":", NegativePrefix} {
+			var n int64
+			err := t.cache.Scan(ctx, globEscaper.Replace(prefix)+"*", func(string) error {
+				n++
+				return nil
+			})
+			if err != nil {
+				return stats, err
+			}
+			if n > 0 {
+				tierStats.Types[KeyType(prefix)] = n
+				tierStats.Entries += n
+			}
+		}
+		stats.Tiers = append(stats.Tiers, tierStats)
+	}
+	return stats, nil
+}
+
+// Keys returns the keys starting with prefix in every tier, sorted
+func (cm *CacheManager) Keys(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := cm.Scan(ctx, globEscaper.Replace(prefix)+"*", func(key string) error {
+		if KeyType(key) != "other" {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Export writes the usable entries whose keys start with prefix to w as JSON lines and
+// returns how many were written
+func (cm *CacheManager) Export(ctx context.Context, w io.Writer, prefix string) (int, error) {
+	keys, err := cm.Keys(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+
+	enc := json.NewEncoder(w)
+	exported := 0
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return exported, err
+		}
+		entry, _, found := cm.GetEntry(ctx, key)
+		if !found {
+			continue
+		}
+		value, err := entry.Plain()
+		if err != nil {
+			continue
+		}
+		plain := *entry
+		plain.Encoding = ""
+		if err := enc.Encode(ExportedEntry{Key: key, Entry: &plain, Value: value}); err != nil {
+			return exported, err
+		}
+		exported++
+	}
+	return exported, nil
+}
+
+// Import stores the entries of an export read from r in every tier, skipping those that are
+// no longer usable, and returns how many were imported and skipped
+func (cm *CacheManager) Import(ctx context.Context, r io.Reader) (imported, skipped int, err error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64<<10), 64<<20)
+	now := time.Now()
+
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var exported ExportedEntry
+		if err := json.Unmarshal(scanner.Bytes(), &exported); err != nil {
+			return imported, skipped, fmt.Errorf("line %d: %v", line, err)
+		}
+		if exported.Key == "" || exported.Entry == nil {
+			return imported, skipped, fmt.Errorf("line %d: missing key or entry", line)
+		}
+
+		entry := exported.Entry
+		entry.Value, entry.Encoding = exported.Value, ""
+		if !entry.Usable(now) {
+			skipped++
+			continue
+		}
+		if err := cm.SetEntry(ctx, exported.Key, entry); err != nil {
+			return imported, skipped, fmt.Errorf("line %d: %v", line, err)
+		}
+		if network, ok := parseNetworkKey(exported.Key); ok {
+			cm.ranges.Add(ctx, network, exported.Key, entry.retainUntil())
+		}
+		imported++
+	}
+	return imported, skipped, scanner.Err()
+}
+
+// infoField returns a numeric field of a Redis INFO reply
+func infoField(info, field string) uint64 {
+	for _, line := range strings.Split(info, "\n") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), field+":"); ok {
+			n, _ := strconv.ParseUint(value, 10, 64)
+			return n
+		}
+	}
+	return 0
+}
@@ -0,0 +1,73 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCacheMiss is returned when a key is not found in the cache
+var ErrCacheMiss = errors.New("cache miss")
+
+// Cache stores entries with their metadata. MemoryCache, DiskCache and RedisCache implement it
+// and are composed into tiers by CacheManager, which implements it too.
+type Cache interface {
+	// Get returns the entry stored under key, or ErrCacheMiss. Values may still be
+	// compressed; use Entry.Plain to read them.
+	Get(ctx context.Context, key string) (*Entry, error)
+	// Set stores an entry for ttl, or until it can no longer be served when ttl is zero
+	Set(ctx context.Context, key string, entry *Entry, ttl time.Duration) error
+	// Delete removes keys and returns how many were present
+	Delete(ctx context.Context, keys ...string) (int, error)
+	// Scan calls fn for every key matching a glob pattern, which uses the syntax of
+	// path.Match and Redis SCAN
+	Scan(ctx context.Context, pattern string, fn func(key string) error) error
+	Close() error
+}
+
+// sizer is implemented by caches that report how much space they use
+type sizer interface {
+	size(ctx context.Context) TierStats
+}
+
+// storeFor returns how long a cache keeps an entry stored with ttl
+func storeFor(entry *Entry, ttl time.Duration) time.Duration {
+	if ttl > 0 {
+		return ttl
+	}
+	return time.Until(entry.retainUntil())
+}
+
+// decodeStored parses an entry read from a cache that compresses values with codec
+func decodeStored(data []byte, codec Codec) (*Entry, error) {
+	entry, err := DecodeEntry(data)
+	if err != nil {
+		return nil, err
+	}
+	entry.codec = codecFor(codec, entry.Encoding)
+	return entry, nil
+}
+
+// otherCodecs holds the codecs of values written by replicas configured with another codec
+var otherCodecs sync.Map
+
+// codecFor returns the codec that decodes values of an encoding. Values written with another
+// codec than own remain readable as long as no dictionary is involved.
+func codecFor(own Codec, encoding string) Codec {
+	switch encoding {
+	case "":
+		return nil
+	case own.Name():
+		return own
+	}
+	if codec, ok := otherCodecs.Load(encoding); ok {
+		return codec.(Codec)
+	}
+	codec, err := NewCodec(encoding, nil)
+	if err != nil {
+		return nil
+	}
+	otherCodecs.Store(encoding, codec)
+	return codec
+}
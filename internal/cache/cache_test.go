@@ -0,0 +1,524 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/ohelal/rdap/internal/config"
+	"github.com/ohelal/rdap/internal/redisclient"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEntryEncoding(t *testing.T) {
+	entry, err := NewEntry(map[string]interface{}{"objectClassName": "domain"}, time.Minute)
+	require.NoError(t, err)
+	entry.ObjectType = TypeDomain
+	entry.Header = http.Header{"Content-Type": {"application/rdap+json"}}
+
+	data, err := entry.Encode()
+	require.NoError(t, err)
+	decoded, err := DecodeEntry(data)
+	require.NoError(t, err)
+
+	assert.Equal(t, TypeDomain, decoded.ObjectType)
+	assert.Equal(t, "application/rdap+json", decoded.Header.Get("Content-Type"))
+	assert.WithinDuration(t, entry.ExpiresAt, decoded.ExpiresAt, time.Millisecond)
+	value, err := decoded.Decoded()
+	require.NoError(t, err)
+	assert.Equal(t, map[string]interface{}{"objectClassName": "domain"}, value)
+
+	_, err = DecodeEntry(data[:len(data)/3])
+	assert.ErrorIs(t, err, ErrCorruptEntry)
+	_, err = DecodeEntry([]byte("plain value"))
+	assert.ErrorIs(t, err, ErrCorruptEntry)
+}
+
+func TestFreshnessLifetime(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		header   http.Header
+		lifetime time.Duration
+		ok       bool
+	}{
+		{"no information", http.Header{}, 0, false},
+		{"max-age", http.Header{"Cache-Control": {"public, max-age=600"}}, 10 * time.Minute, true},
+		{"s-maxage wins", http.Header{"Cache-Control": {"max-age=600, s-maxage=60"}}, time.Minute, true},
+		{"age is subtracted", http.Header{"Cache-Control": {"max-age=600"}, "Age": {"100"}}, 500 * time.Second, true},
+		{"no-cache", http.Header{"Cache-Control": {"no-cache"}}, 0, true},
+		{"expires", http.Header{
+			"Date":    {now.Format(http.TimeFormat)},
+			"Expires": {now.Add(time.Hour).Format(http.TimeFormat)},
+		}, time.Hour, true},
+		{"invalid expires", http.Header{"Expires": {"0"}}, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lifetime, ok := FreshnessLifetime(tt.header, now)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.lifetime, lifetime)
+		})
+	}
+
+	assert.False(t, Cacheable(http.Header{"Cache-Control": {"no-store"}}))
+	bounds := TTLBounds{Min: time.Minute, Max: time.Hour, Default: 10 * time.Minute}
+	assert.Equal(t, 10*time.Minute, bounds.Clamp(0, false))
+	assert.Equal(t, time.Minute, bounds.Clamp(time.Second, true))
+	assert.Equal(t, time.Hour, bounds.Clamp(48*time.Hour, true))
+}
+
+func TestCacheManagerExpiry(t *testing.T) {
+	cm, err := NewCacheManager(&CacheConfig{MaxLocalSize: 128 << 20, LocalTTL: time.Hour})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	require.NoError(t, cm.SetValue("string", "value"))
+	val, found := cm.GetValue("string")
+	require.True(t, found)
+	assert.Equal(t, "value", val)
+
+	require.NoError(t, cm.SetValue("object", map[string]interface{}{"a": "b"}))
+	val, found = cm.GetValue("object")
+	require.True(t, found)
+	assert.Equal(t, map[string]interface{}{"a": "b"}, val)
+
+	big := make([]byte, 200<<10)
+	entry, err := NewEntry(big, time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, cm.SetEntry(ctx, "big", entry))
+	got, tier, found := cm.GetEntry(ctx, "big")
+	require.True(t, found)
+	assert.Equal(t, TierLocal, tier)
+	assert.Len(t, got.Value, len(big))
+
+	entry, err = NewEntry("short-lived", 20*time.Millisecond)
+	require.NoError(t, err)
+	require.NoError(t, cm.SetEntry(ctx, "short", entry))
+	_, _, found = cm.GetEntry(ctx, "short")
+	assert.True(t, found)
+	time.Sleep(30 * time.Millisecond)
+	_, _, found = cm.GetEntry(ctx, "short")
+	assert.False(t, found)
+}
+
+func TestRangeIndex(t *testing.T) {
+	ix := NewRangeIndex(nil)
+	ctx := context.Background()
+	hour := time.Now().Add(time.Hour)
+
+	add := func(prefix string) string {
+		r, err := PrefixRange(netip.MustParsePrefix(prefix))
+		require.NoError(t, err)
+		key := NetworkKey(r)
+		require.NoError(t, ix.Add(ctx, r, key, hour))
+		return key
+	}
+	lookup := func(ip string) string {
+		key, _ := ix.Lookup(ctx, netip.MustParseAddr(ip))
+		return key
+	}
+
+	slash24 := add("10.1.2.0/24")
+	slash8 := add("10.0.0.0/8")
+	slash16 := add("10.1.0.0/16")
+	assert.Equal(t, "ip:net:10.1.0.0-10.1.255.255", slash16)
+
+	assert.Equal(t, slash24, lookup("10.1.2.3"))
+	assert.Equal(t, slash16, lookup("10.1.3.1"))
+	assert.Equal(t, slash8, lookup("10.200.0.1"))
+	assert.Equal(t, slash16, lookup("::ffff:10.1.0.1"))
+	assert.Equal(t, "", lookup("11.0.0.1"))
+
+	ix.Remove(slash16)
+	assert.Equal(t, slash24, lookup("10.1.2.3"))
+	assert.Equal(t, slash8, lookup("10.1.3.1"))
+
+	r, err := NewIPRange(netip.MustParseAddr("10.1.2.3"), netip.MustParseAddr("10.1.2.9"))
+	require.NoError(t, err)
+	require.NoError(t, ix.Add(ctx, r, "expired", time.Now().Add(-time.Second)))
+	assert.Equal(t, slash24, lookup("10.1.2.5"))
+	assert.Equal(t, 2, ix.Len())
+}
+
+func TestInvalidate(t *testing.T) {
+	cm, err := NewCacheManager(&CacheConfig{MaxLocalSize: 128 << 20, LocalTTL: time.Hour})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	bus := &recordingBus{}
+	cm.bus = bus
+
+	for _, key := range []string{"domain:example.com", "domain:example.org", "neg:domain:foo.com", "ip:192.0.2.1"} {
+		require.NoError(t, cm.SetValue(key, "value"))
+	}
+
+	_, err = cm.Invalidate(ctx, Invalidation{})
+	assert.Error(t, err)
+
+	removed, err := cm.Invalidate(ctx, Invalidation{Pattern: "domain:*.com"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	_, found := cm.GetValue("domain:example.com")
+	assert.False(t, found)
+	_, found = cm.GetValue("neg:domain:foo.com")
+	assert.True(t, found)
+
+	removed, err = cm.Invalidate(ctx, Invalidation{Prefix: "neg:", Keys: []string{"ip:192.0.2.1"}})
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	_, found = cm.GetValue("domain:example.org")
+	assert.True(t, found)
+	assert.Len(t, bus.published, 2)
+
+	// Delete only removes the entries of this replica
+	removed, err = cm.Delete(ctx, "domain:example.org", "domain:missing.org")
+	require.NoError(t, err)
+	assert.Equal(t, 1, removed)
+	_, found = cm.GetValue("domain:example.org")
+	assert.False(t, found)
+	assert.Len(t, bus.published, 2)
+}
+
+// recordingBus is an InvalidationBus keeping the invalidations published on it
+type recordingBus struct {
+	published []Invalidation
+}
+
+func (b *recordingBus) Publish(ctx context.Context, inv Invalidation) error {
+	b.published = append(b.published, inv)
+	return nil
+}
+
+func (b *recordingBus) Subscribe(ctx context.Context, fn func(Invalidation)) error {
+	<-ctx.Done()
+	return nil
+}
+
+func (b *recordingBus) Close() error {
+	return nil
+}
+
+func TestCompressedEntries(t *testing.T) {
+	var samples [][]byte
+	for i := 0; i < 200; i++ {
+		samples = append(samples, []byte(fmt.Sprintf(`{"objectClassName":"domain","ldhName":"example%d.com",`+
+			`"notices":[{"title":"Terms of Use","description":["Service subject to Terms of Use."]}],`+
+			`"events":[{"eventAction":"registration","eventDate":"20%02d-01-01T00:00:00Z"}]}`, i, i%30)))
+	}
+	dictionary, err := TrainDictionary(samples, 4<<10)
+	require.NoError(t, err)
+
+	value := strings.Repeat(`{"title":"Terms of Use","description":["Service subject to Terms of Use."]},`, 20)
+	for _, tc := range []struct {
+		codec      string
+		dictionary []byte
+		encoding   string
+	}{
+		{CodecNone, nil, ""},
+		{CodecSnappy, nil, ""},
+		{CodecZstd, nil, "zstd"},
+		{CodecZstd, dictionary, ""},
+	} {
+		cm, err := NewCacheManager(&CacheConfig{MaxLocalSize: 128 << 20, LocalTTL: time.Hour, LocalCodec: tc.codec, Dictionary: tc.dictionary})
+		require.NoError(t, err)
+		ctx := context.Background()
+
+		require.NoError(t, cm.SetValue("large", value))
+		entry, _, found := cm.GetEntry(ctx, "large")
+		require.True(t, found)
+		if tc.codec != CodecNone {
+			assert.Equal(t, tc.codec, entry.Encoding)
+			assert.Less(t, len(entry.Value), len(value))
+		}
+		assert.Equal(t, tc.encoding, entry.ContentEncoding())
+		plain, err := entry.Plain()
+		require.NoError(t, err)
+		assert.Equal(t, value, string(plain))
+
+		require.NoError(t, cm.SetValue("small", "value"))
+		entry, _, found = cm.GetEntry(ctx, "small")
+		require.True(t, found)
+		assert.Empty(t, entry.Encoding)
+	}
+
+	_, err = NewCodec("lz4", nil)
+	assert.Error(t, err)
+}
+
+func TestSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	config := &CacheConfig{MaxLocalSize: 128 << 20, LocalTTL: time.Hour, SnapshotDir: dir}
+	ctx := context.Background()
+
+	cm, err := NewCacheManager(config)
+	require.NoError(t, err)
+	require.NoError(t, cm.SetValue("domain:example.com", "value"))
+	// Unescaped request paths can put any byte in a key
+	require.NoError(t, cm.SetValue("domain:example.com\ndomain:other.com", "escaped"))
+	entry, err := NewEntry("short-lived", 10*time.Millisecond)
+	require.NoError(t, err)
+	require.NoError(t, cm.SetEntry(ctx, "domain:example.org", entry))
+	r, err := PrefixRange(netip.MustParsePrefix("192.0.2.0/24"))
+	require.NoError(t, err)
+	require.NoError(t, cm.SetValue(NetworkKey(r), "network"))
+	require.NoError(t, cm.Close())
+	time.Sleep(20 * time.Millisecond)
+
+	cm, err = NewCacheManager(config)
+	require.NoError(t, err)
+	val, found := cm.GetValue("domain:example.com")
+	require.True(t, found)
+	assert.Equal(t, "value", val)
+	val, found = cm.GetValue("domain:example.com\ndomain:other.com")
+	require.True(t, found)
+	assert.Equal(t, "escaped", val)
+	_, found = cm.GetValue("domain:other.com")
+	assert.False(t, found)
+	_, found = cm.GetValue("domain:example.org")
+	assert.False(t, found)
+	key, found := cm.Ranges().Lookup(ctx, netip.MustParseAddr("192.0.2.7"))
+	assert.True(t, found)
+	assert.Equal(t, NetworkKey(r), key)
+	removed, err := cm.Invalidate(ctx, Invalidation{Prefix: "domain:"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	require.NoError(t, cm.SetValue("domain:example.net", "value"))
+	require.NoError(t, cm.SaveSnapshot())
+
+	// A snapshot whose data no longer matches its manifest is discarded
+	f, err := os.OpenFile(filepath.Join(dir, snapshotKeysFile), os.O_APPEND|os.O_WRONLY, 0)
+	require.NoError(t, err)
+	_, err = f.WriteString("domain:example.com\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	cm, err = NewCacheManager(config)
+	require.NoError(t, err)
+	_, found = cm.GetValue("domain:example.net")
+	assert.False(t, found)
+	_, err = os.Stat(filepath.Join(dir, snapshotManifest))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	src, err := NewCacheManager(&CacheConfig{MaxLocalSize: 128 << 20, LocalTTL: time.Hour, LocalCodec: CodecZstd})
+	require.NoError(t, err)
+	value := strings.Repeat(`{"ldhName":"example.com"}`, 20)
+	require.NoError(t, src.SetValue("domain:example.com", value))
+	require.NoError(t, src.SetValue("neg:domain:nope.com", "missing"))
+	r, err := PrefixRange(netip.MustParsePrefix("192.0.2.0/24"))
+	require.NoError(t, err)
+	require.NoError(t, src.SetValue(NetworkKey(r), "network"))
+
+	stats, err := src.Stats(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), stats.Tiers[0].Entries)
+	assert.Equal(t, map[string]int64{TypeDomain: 1, TypeIP: 1, "negative": 1}, stats.Tiers[0].Types)
+
+	var export strings.Builder
+	n, err := src.Export(ctx, &export, "")
+	require.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.NotContains(t, export.String(), `"encoding"`)
+
+	dst, err := NewCacheManager(&CacheConfig{MaxLocalSize: 128 << 20, LocalTTL: time.Hour, LocalCodec: CodecSnappy})
+	require.NoError(t, err)
+	imported, skipped, err := dst.Import(ctx, strings.NewReader(export.String()))
+	require.NoError(t, err)
+	assert.Equal(t, 3, imported)
+	assert.Equal(t, 0, skipped)
+	val, found := dst.GetValue("domain:example.com")
+	require.True(t, found)
+	assert.Equal(t, value, val)
+	key, found := dst.Ranges().Lookup(ctx, netip.MustParseAddr("192.0.2.1"))
+	assert.True(t, found)
+	assert.Equal(t, NetworkKey(r), key)
+
+	_, _, err = dst.Import(ctx, strings.NewReader("not json\n"))
+	assert.Error(t, err)
+}
+
+func TestClusterTier(t *testing.T) {
+	m := miniredis.RunT(t)
+	client, err := redisclient.New(config.RedisConfig{Mode: redisclient.ModeCluster, Addrs: []string{m.Addr()}})
+	require.NoError(t, err)
+	defer client.Close()
+
+	cm, err := NewCacheManager(&CacheConfig{
+		MaxLocalSize: 128 << 20, LocalTTL: time.Hour, RedisTTL: time.Hour, EnableRedis: true, Redis: client,
+	})
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	for _, key := range []string{"domain:example.com", "domain:example.org", "ip:192.0.2.1"} {
+		require.NoError(t, cm.SetValue(key, "value"))
+	}
+	assert.True(t, m.Exists("domain:example.com"))
+
+	removed, err := cm.Invalidate(ctx, Invalidation{Prefix: "domain:"})
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	assert.Equal(t, []string{"ip:192.0.2.1"}, m.Keys())
+
+	// The shared client stays open for its other users
+	require.NoError(t, cm.Close())
+	assert.NoError(t, client.Ping(ctx).Err())
+}
+
+func TestDiskTier(t *testing.T) {
+	config := &CacheConfig{
+		Tiers:        []string{TierLocal, TierDisk},
+		MaxLocalSize: 128 << 20,
+		LocalTTL:     time.Hour,
+		DiskPath:     filepath.Join(t.TempDir(), "cache.db"),
+		DiskCodec:    CodecZstd,
+	}
+	ctx := context.Background()
+
+	cm, err := NewCacheManager(config)
+	require.NoError(t, err)
+	value := strings.Repeat(`{"ldhName":"example.com"}`, 20)
+	require.NoError(t, cm.SetValue("domain:example.com", value))
+	require.NoError(t, cm.SetValue("domain:example.org", "value"))
+	r, err := PrefixRange(netip.MustParsePrefix("192.0.2.0/24"))
+	require.NoError(t, err)
+	require.NoError(t, cm.SetValue(NetworkKey(r), "network"))
+	entry, err := NewEntry("short-lived", 10*time.Millisecond)
+	require.NoError(t, err)
+	require.NoError(t, cm.Set(ctx, "domain:example.net", entry, 0))
+
+	removed, err := cm.Delete(ctx, "domain:example.org")
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	require.NoError(t, cm.Close())
+	time.Sleep(20 * time.Millisecond)
+
+	// A restarted node answers from disk and copies entries back to memory
+	cm, err = NewCacheManager(config)
+	require.NoError(t, err)
+	defer cm.Close()
+	entry, tier, found := cm.GetEntry(ctx, "domain:example.com")
+	require.True(t, found)
+	assert.Equal(t, TierDisk, tier)
+	assert.Equal(t, CodecZstd, entry.Encoding)
+	plain, err := entry.Plain()
+	require.NoError(t, err)
+	assert.Equal(t, value, string(plain))
+	_, tier, _ = cm.GetEntry(ctx, "domain:example.com")
+	assert.Equal(t, TierLocal, tier)
+
+	_, err = cm.Get(ctx, "domain:example.org")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+	_, err = cm.Get(ctx, "domain:example.net")
+	assert.ErrorIs(t, err, ErrCacheMiss)
+	key, found := cm.Ranges().Lookup(ctx, netip.MustParseAddr("192.0.2.7"))
+	assert.True(t, found)
+	assert.Equal(t, NetworkKey(r), key)
+
+	stats, err := cm.Stats(ctx)
+	require.NoError(t, err)
+	require.Len(t, stats.Tiers, 2)
+	assert.Equal(t, TierDisk, stats.Tiers[1].Name)
+	assert.Equal(t, map[string]int64{TypeDomain: 1, TypeIP: 1}, stats.Tiers[1].Types)
+
+	_, err = NewCacheManager(&CacheConfig{Tiers: []string{TierLocal, "cdn"}, MaxLocalSize: 128 << 20})
+	assert.Error(t, err)
+	_, err = NewCacheManager(&CacheConfig{Tiers: []string{TierLocal}, MaxLocalSize: 32 << 20})
+	assert.Error(t, err)
+}
+
+func TestMemoryCachePrunesEvictedKeys(t *testing.T) {
+	codec, err := NewCodec(CodecNone, nil)
+	require.NoError(t, err)
+	m := NewMemoryCache(MinLocalSize, codec)
+	ctx := context.Background()
+	entry := &Entry{Kind: KindBytes, Value: []byte("value"), StoredAt: time.Now(), ExpiresAt: time.Now().Add(time.Hour)}
+
+	m.pruneAt = 10
+	for i := 0; i < 10; i++ {
+		require.NoError(t, m.Set(ctx, fmt.Sprintf("domain:%d.com", i), entry, 0))
+	}
+	// Evicted by fastcache behind the index's back
+	for i := 0; i < 5; i++ {
+		m.local.Del([]byte(fmt.Sprintf("domain:%d.com", i)))
+	}
+	require.NoError(t, m.Set(ctx, "domain:10.com", entry, 0))
+
+	require.Eventually(t, func() bool {
+		m.mu.RLock()
+		defer m.mu.RUnlock()
+		return len(m.keys) == 6 && !m.pruning.Load()
+	}, time.Second, time.Millisecond)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	assert.Equal(t, maxIndexedKeys, m.pruneAt)
+}
+
+func TestSharedRangeIndex(t *testing.T) {
+	m := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { client.Close() })
+	ctx := context.Background()
+	hour := time.Now().Add(time.Hour)
+
+	add := func(ix *RangeIndex, prefix string, expiresAt time.Time) string {
+		r, err := PrefixRange(netip.MustParsePrefix(prefix))
+		require.NoError(t, err)
+		key := NetworkKey(r)
+		require.NoError(t, ix.Add(ctx, r, key, expiresAt))
+		return key
+	}
+
+	writer := NewRangeIndex(client)
+	slash8 := add(writer, "10.0.0.0/8", hour)
+	// More cached networks after the address than a page holds
+	for i := 0; i < 3*rangeScanPage; i++ {
+		add(writer, fmt.Sprintf("10.200.%d.0/24", i), hour)
+	}
+	// Refreshing a network does not add a member
+	add(writer, "10.0.0.0/8", hour.Add(time.Hour))
+	members, err := client.ZCard(ctx, rangeIndexKey).Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1+3*rangeScanPage), members)
+
+	// lookup reads the shared index from a fresh replica, returning the Redis commands it took
+	lookup := func(ip string) (string, bool, int) {
+		before := m.CommandCount()
+		key, found := NewRangeIndex(client).Lookup(ctx, netip.MustParseAddr(ip))
+		return key, found, m.CommandCount() - before
+	}
+	key, found, commands := lookup("10.200.5.7")
+	require.True(t, found)
+	assert.Equal(t, "ip:net:10.200.5.0-10.200.5.255", key)
+	assert.Equal(t, 2, commands)
+	key, found, _ = lookup("10.250.0.1")
+	require.True(t, found)
+	assert.Equal(t, slash8, key)
+	// The /8 lies more than a page past the address
+	_, found, commands = lookup("10.1.2.3")
+	assert.False(t, found)
+	assert.Equal(t, 2, commands)
+	_, found, _ = lookup("11.0.0.1")
+	assert.False(t, found)
+
+	// Expired ranges and members without an expiry are swept
+	add(writer, "192.0.2.0/24", time.Now().Add(-time.Second))
+	require.NoError(t, client.ZAdd(ctx, rangeIndexKey, &redis.Z{Member: "4c0000201|3fffffdff|123|ip:net:old"}).Err())
+	removed, err := writer.Sweep(ctx, time.Now())
+	require.NoError(t, err)
+	assert.Equal(t, 2, removed)
+	fields, err := client.HLen(ctx, rangeExpiryKey).Result()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1+3*rangeScanPage), fields)
+}
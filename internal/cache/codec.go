@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codec names accepted in the cache configuration and recorded in Entry.Encoding
+const (
+	CodecNone   = "none"
+	CodecZstd   = "zstd"
+	CodecSnappy = "snappy"
+)
+
+// minCompressSize is the value size below which compression is not worth its cost
+const minCompressSize = 256
+
+// Codec compresses cache values
+type Codec interface {
+	// Name returns the name recorded in Entry.Encoding, or "" for values stored as they are
+	Name() string
+	Encode(src []byte) []byte
+	Decode(src []byte) ([]byte, error)
+	// HTTPEncoding returns the Content-Encoding under which encoded values can be sent to
+	// clients as they are, or "" when clients cannot decode them
+	HTTPEncoding() string
+}
+
+// NewCodec returns the codec with the given name. A zstd dictionary, when given, is used to
+// compress and decompress zstd values.
+func NewCodec(name string, dictionary []byte) (Codec, error) {
+	switch name {
+	case "", CodecNone:
+		return noneCodec{}, nil
+	case CodecSnappy:
+		return snappyCodec{}, nil
+	case CodecZstd:
+		return newZstdCodec(dictionary)
+	}
+	return nil, fmt.Errorf("unsupported cache codec: %s", name)
+}
+
+// TrainDictionary builds a zstd dictionary of at most size bytes from sample RDAP responses
+func TrainDictionary(samples [][]byte, size int) ([]byte, error) {
+	return dict.BuildZstdDict(samples, dict.Options{
+		MaxDictSize: size,
+		HashBytes:   6,
+		ZstdLevel:   zstd.SpeedFastest,
+	})
+}
+
+type noneCodec struct{}
+
+func (noneCodec) Name() string                      { return "" }
+func (noneCodec) Encode(src []byte) []byte          { return src }
+func (noneCodec) Decode(src []byte) ([]byte, error) { return src, nil }
+func (noneCodec) HTTPEncoding() string              { return "" }
+
+type snappyCodec struct{}
+
+func (snappyCodec) Name() string                      { return CodecSnappy }
+func (snappyCodec) Encode(src []byte) []byte          { return snappy.Encode(nil, src) }
+func (snappyCodec) Decode(src []byte) ([]byte, error) { return snappy.Decode(nil, src) }
+func (snappyCodec) HTTPEncoding() string              { return "" }
+
+// zstdCodec compresses with zstd at its fastest level. The encoder and decoder are safe for
+// concurrent use through EncodeAll and DecodeAll.
+type zstdCodec struct {
+	encoder    *zstd.Encoder
+	decoder    *zstd.Decoder
+	dictionary bool
+}
+
+func newZstdCodec(dictionary []byte) (*zstdCodec, error) {
+	encoderOpts := []zstd.EOption{zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1)}
+	decoderOpts := []zstd.DOption{zstd.WithDecoderConcurrency(0)}
+	if len(dictionary) > 0 {
+		encoderOpts = append(encoderOpts, zstd.WithEncoderDict(dictionary))
+		decoderOpts = append(decoderOpts, zstd.WithDecoderDicts(dictionary))
+	}
+
+	encoder, err := zstd.NewWriter(nil, encoderOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid zstd dictionary: %v", err)
+	}
+	decoder, err := zstd.NewReader(nil, decoderOpts...)
+	if err != nil {
+		return nil, fmt.Errorf("invalid zstd dictionary: %v", err)
+	}
+	return &zstdCodec{encoder: encoder, decoder: decoder, dictionary: len(dictionary) > 0}, nil
+}
+
+func (c *zstdCodec) Name() string                      { return CodecZstd }
+func (c *zstdCodec) Encode(src []byte) []byte          { return c.encoder.EncodeAll(src, nil) }
+func (c *zstdCodec) Decode(src []byte) ([]byte, error) { return c.decoder.DecodeAll(src, nil) }
+
+// HTTPEncoding is "zstd" unless a dictionary is used, which clients do not have
+func (c *zstdCodec) HTTPEncoding() string {
+	if c.dictionary {
+		return ""
+	}
+	return "zstd"
+}
+
+// compress encodes an entry's value with codec, recording the codec in the entry. Values that
+// are small or do not shrink are stored as they are.
+func compress(entry *Entry, codec Codec) {
+	if codec.Name() == "" || entry.Encoding != "" || len(entry.Value) < minCompressSize {
+		return
+	}
+
+	start := time.Now()
+	encoded := codec.Encode(entry.Value)
+	compressionSeconds.WithLabelValues(codec.Name(), "encode").Add(time.Since(start).Seconds())
+	compressionRatio.WithLabelValues(codec.Name()).Observe(float64(len(encoded)) / float64(len(entry.Value)))
+	compressionBytes.WithLabelValues(codec.Name(), "original").Add(float64(len(entry.Value)))
+	compressionBytes.WithLabelValues(codec.Name(), "compressed").Add(float64(len(encoded)))
+
+	if len(encoded) < len(entry.Value) {
+		entry.Value, entry.Encoding = encoded, codec.Name()
+	}
+}
+
+// decompress returns the plain value of an entry encoded with codec
+func decompress(entry *Entry, codec Codec) ([]byte, error) {
+	if entry.Encoding == "" {
+		return entry.Value, nil
+	}
+	if entry.Encoding != codec.Name() {
+		return nil, fmt.Errorf("%w: encoded with %s", ErrCorruptEntry, entry.Encoding)
+	}
+
+	start := time.Now()
+	value, err := codec.Decode(entry.Value)
+	compressionSeconds.WithLabelValues(codec.Name(), "decode").Add(time.Since(start).Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptEntry, err)
+	}
+	return value, nil
+}
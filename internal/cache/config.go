@@ -7,13 +7,32 @@ import (
 
 // CacheConfig holds cache configuration
 type CacheConfig struct {
-	LocalTTL     time.Duration
-	RedisTTL     time.Duration
+	// Tiers lists the tiers entries are read from in order and written to: TierLocal,
+	// TierDisk and TierRedis. When it is empty, the local tier is used, followed by Redis
+	// when EnableRedis is set.
+	Tiers    []string
+	LocalTTL time.Duration
+	RedisTTL time.Duration
+	// MaxLocalSize is the memory of the local tier, at least MinLocalSize
 	MaxLocalSize int64
 	EnableRedis  bool
-	RedisURL     string
-	RedisConfig  *redis.Options
+	// Redis is the client of the distributed tier, shared with the other Redis consumers.
+	// When it is nil, a standalone client for RedisURL is created.
+	Redis    redis.UniversalClient
+	RedisURL string
+	// DiskPath is the database file of the disk tier, which keeps entries for at most DiskTTL
+	// when it is set
+	DiskPath string
+	DiskTTL  time.Duration
+	// LocalCodec, DiskCodec and RedisCodec compress the values of each tier: CodecZstd,
+	// CodecSnappy or CodecNone (the default)
+	LocalCodec string
+	DiskCodec  string
+	RedisCodec string
+	// Dictionary is an optional zstd dictionary trained on RDAP responses
+	Dictionary []byte
+	// SnapshotDir is the directory where the local tier is saved every SnapshotInterval and on
+	// Close, and loaded from at startup. Snapshots are disabled when it is empty.
+	SnapshotDir      string
+	SnapshotInterval time.Duration
 }
-
-// ErrCacheMiss is returned when a key is not found in the cache
-var ErrCacheMiss = redis.Nil
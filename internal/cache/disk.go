@@ -0,0 +1,244 @@
+package cache
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// diskBucket is the bbolt bucket holding the entries of a DiskCache
+var diskBucket = []byte("entries")
+
+// diskSweepInterval is how often expired entries are removed from a DiskCache
+const diskSweepInterval = time.Minute
+
+// DiskCache is a Cache in a bbolt database file, which keeps entries across restarts on
+// single-node deployments without Redis. Each record is the time the entry expires, as
+// big-endian Unix nanoseconds, followed by the encoded entry.
+type DiskCache struct {
+	db    *bolt.DB
+	codec Codec
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// NewDiskCache opens or creates the database at path and compresses values with codec.
+// Expired entries are removed every minute until the cache is closed.
+func NewDiskCache(path string, codec Codec) (*DiskCache, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	// The timeout stops a second process from waiting forever on the file lock
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(diskBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	c := &DiskCache{db: db, codec: codec, done: make(chan struct{})}
+	c.wg.Add(1)
+	go c.sweep()
+	return c, nil
+}
+
+// Get returns the entry stored under key until it expires
+func (c *DiskCache) Get(ctx context.Context, key string) (*Entry, error) {
+	var data []byte
+	err := c.db.View(func(tx *bolt.Tx) error {
+		record := tx.Bucket(diskBucket).Get([]byte(key))
+		if diskExpired(record, time.Now()) {
+			return ErrCacheMiss
+		}
+		// Records are only valid inside the transaction
+		data = append([]byte(nil), record[8:]...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return decodeStored(data, c.codec)
+}
+
+// Set stores an entry compressed with the cache's codec, expiring after ttl. Concurrent writes
+// are committed together.
+func (c *DiskCache) Set(ctx context.Context, key string, entry *Entry, ttl time.Duration) error {
+	ttl = storeFor(entry, ttl)
+	if ttl <= 0 {
+		return nil
+	}
+	stored, err := entry.encodeWith(c.codec)
+	if err != nil {
+		return err
+	}
+	data, err := stored.Encode()
+	if err != nil {
+		return err
+	}
+	record := make([]byte, 8, 8+len(data))
+	binary.BigEndian.PutUint64(record, uint64(time.Now().Add(ttl).UnixNano()))
+	record = append(record, data...)
+
+	return c.db.Batch(func(tx *bolt.Tx) error {
+		return tx.Bucket(diskBucket).Put([]byte(key), record)
+	})
+}
+
+// Delete removes keys
+func (c *DiskCache) Delete(ctx context.Context, keys ...string) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	removed := 0
+	err := c.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(diskBucket)
+		for _, key := range keys {
+			if b.Get([]byte(key)) == nil {
+				continue
+			}
+			if err := b.Delete([]byte(key)); err != nil {
+				return err
+			}
+			removed++
+		}
+		return nil
+	})
+	return removed, err
+}
+
+// Scan calls fn for every unexpired key matching pattern. Keys are stored in order, so only
+// those starting with the literal prefix of the pattern are visited.
+func (c *DiskCache) Scan(ctx context.Context, pattern string, fn func(key string) error) error {
+	prefix := []byte(globPrefix(pattern))
+	var keys []string
+	err := c.db.View(func(tx *bolt.Tx) error {
+		now := time.Now()
+		cur := tx.Bucket(diskBucket).Cursor()
+		for k, v := cur.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = cur.Next() {
+			if diskExpired(v, now) {
+				continue
+			}
+			if matched, _ := path.Match(pattern, string(k)); matched {
+				keys = append(keys, string(k))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// fn may write to the cache, which would deadlock inside the read transaction
+	for _, key := range keys {
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close stops the sweeper and closes the database
+func (c *DiskCache) Close() error {
+	close(c.done)
+	c.wg.Wait()
+	return c.db.Close()
+}
+
+func (c *DiskCache) size(ctx context.Context) TierStats {
+	var stats TierStats
+	c.db.View(func(tx *bolt.Tx) error {
+		stats.Bytes = uint64(tx.Size())
+		return nil
+	})
+	return stats
+}
+
+// sweep removes expired entries every diskSweepInterval until the cache is closed
+func (c *DiskCache) sweep() {
+	defer c.wg.Done()
+	ticker := time.NewTicker(diskSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.removeExpired(time.Now())
+		}
+	}
+}
+
+// removeExpired deletes the entries that expired before now, in batches so writers are not
+// blocked for long
+func (c *DiskCache) removeExpired(now time.Time) (int, error) {
+	removed := 0
+	for {
+		var expired [][]byte
+		err := c.db.View(func(tx *bolt.Tx) error {
+			cur := tx.Bucket(diskBucket).Cursor()
+			for k, v := cur.First(); k != nil && len(expired) < 1000; k, v = cur.Next() {
+				if diskExpired(v, now) {
+					expired = append(expired, append([]byte(nil), k...))
+				}
+			}
+			return nil
+		})
+		if err != nil || len(expired) == 0 {
+			return removed, err
+		}
+
+		err = c.db.Update(func(tx *bolt.Tx) error {
+			b := tx.Bucket(diskBucket)
+			for _, k := range expired {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return removed, err
+		}
+		removed += len(expired)
+		if len(expired) < 1000 {
+			return removed, nil
+		}
+	}
+}
+
+// diskExpired reports whether a record of a DiskCache has expired at now
+func diskExpired(record []byte, now time.Time) bool {
+	return len(record) < 8 || int64(binary.BigEndian.Uint64(record)) <= now.UnixNano()
+}
+
+// globPrefix returns the literal prefix of a glob pattern, before its first special character
+func globPrefix(pattern string) string {
+	var prefix strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch pattern[i] {
+		case '*', '?', '[':
+			return prefix.String()
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+		}
+		prefix.WriteByte(pattern[i])
+	}
+	return prefix.String()
+}
@@ -0,0 +1,212 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Value kinds recorded in an entry so Get returns values in the form they were stored
+const (
+	KindBytes  = "bytes"
+	KindString = "string"
+	KindJSON   = "json"
+)
+
+// Versions prefixing every encoded entry so the format can evolve. Entries with a compressed
+// value use compressedEntryVersion, which earlier releases reject as corrupt instead of
+// serving the compressed bytes.
+const (
+	entryVersion           byte = 1
+	compressedEntryVersion byte = 2
+)
+
+// ErrCorruptEntry is returned when a stored entry cannot be decoded
+var ErrCorruptEntry = errors.New("corrupt cache entry")
+
+// Entry is the envelope in which values are stored in every cache tier
+type Entry struct {
+	// ObjectType is the RDAP object type of the value, such as TypeDomain
+	ObjectType string `json:"type,omitempty"`
+	// StoredAt is when the value was produced
+	StoredAt time.Time `json:"storedAt"`
+	// ExpiresAt is when the value stops being fresh
+	ExpiresAt time.Time `json:"expiresAt"`
+	// StaleUntil is when a stale value may no longer be served; zero means at ExpiresAt
+	StaleUntil time.Time `json:"staleUntil,omitempty"`
+	// Header holds the original response headers of the value
+	Header http.Header `json:"header,omitempty"`
+	// Metadata holds additional attributes, such as the upstream URL
+	Metadata map[string]string `json:"metadata,omitempty"`
+	// Kind records the Go type of the value: KindBytes, KindString or KindJSON
+	Kind string `json:"kind"`
+	// Encoding names the codec that compressed Value, or is empty when Value is plain
+	Encoding string `json:"encoding,omitempty"`
+	// Value is the stored value; it is encoded outside the JSON metadata to avoid base64 overhead
+	Value []byte `json:"-"`
+
+	// codec decompresses Value; it is set on entries returned by the cache
+	codec Codec
+	plain []byte
+}
+
+// NewEntry wraps a value in an entry that is fresh for ttl. Byte slices and strings are stored
+// as they are; any other value is stored as JSON.
+func NewEntry(value interface{}, ttl time.Duration) (*Entry, error) {
+	now := time.Now()
+	e := &Entry{StoredAt: now, ExpiresAt: now.Add(ttl)}
+
+	switch v := value.(type) {
+	case []byte:
+		e.Kind, e.Value = KindBytes, v
+	case string:
+		e.Kind, e.Value = KindString, []byte(v)
+	default:
+		data, err := json.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal value: %v", err)
+		}
+		e.Kind, e.Value = KindJSON, data
+	}
+	return e, nil
+}
+
+// Expired reports whether the entry is no longer fresh at the given time
+func (e *Entry) Expired(now time.Time) bool {
+	return !now.Before(e.ExpiresAt)
+}
+
+// Usable reports whether the entry may still be served, fresh or stale, at the given time
+func (e *Entry) Usable(now time.Time) bool {
+	return now.Before(e.retainUntil())
+}
+
+// Staleness returns how long ago the entry stopped being fresh, or zero while it is fresh
+func (e *Entry) Staleness(now time.Time) time.Duration {
+	if d := now.Sub(e.ExpiresAt); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// retainUntil returns when the entry can be dropped from the cache
+func (e *Entry) retainUntil() time.Time {
+	if e.StaleUntil.After(e.ExpiresAt) {
+		return e.StaleUntil
+	}
+	return e.ExpiresAt
+}
+
+// TTL returns how long the entry remains fresh, or zero once it has expired
+func (e *Entry) TTL(now time.Time) time.Duration {
+	if ttl := e.ExpiresAt.Sub(now); ttl > 0 {
+		return ttl
+	}
+	return 0
+}
+
+// Plain returns the uncompressed value
+func (e *Entry) Plain() ([]byte, error) {
+	if e.Encoding == "" {
+		return e.Value, nil
+	}
+	if e.plain == nil {
+		if e.codec == nil {
+			return nil, fmt.Errorf("%w: no codec for %s", ErrCorruptEntry, e.Encoding)
+		}
+		plain, err := decompress(e, e.codec)
+		if err != nil {
+			return nil, err
+		}
+		e.plain = plain
+	}
+	return e.plain, nil
+}
+
+// ContentEncoding returns the HTTP Content-Encoding under which Value can be sent to clients
+// as it is, or "" when Value is plain or clients cannot decode it
+func (e *Entry) ContentEncoding() string {
+	if e.Encoding == "" || e.codec == nil {
+		return ""
+	}
+	return e.codec.HTTPEncoding()
+}
+
+// Decoded returns the value in the form it was stored: []byte, string, or the decoded JSON value
+func (e *Entry) Decoded() (interface{}, error) {
+	value, err := e.Plain()
+	if err != nil {
+		return nil, err
+	}
+	switch e.Kind {
+	case KindString:
+		return string(value), nil
+	case KindJSON:
+		var v interface{}
+		if err := json.Unmarshal(value, &v); err != nil {
+			return nil, err
+		}
+		return v, nil
+	default:
+		return value, nil
+	}
+}
+
+// encodeWith returns a copy of the entry with its value compressed by codec
+func (e *Entry) encodeWith(codec Codec) (*Entry, error) {
+	out := *e
+	if e.Encoding == codec.Name() {
+		return &out, nil
+	}
+	plain, err := e.Plain()
+	if err != nil {
+		return nil, err
+	}
+	out.Value, out.Encoding, out.codec, out.plain = plain, "", codec, nil
+	compress(&out, codec)
+	return &out, nil
+}
+
+// Encode serializes the entry as a version byte, the length-prefixed JSON metadata and the raw value
+func (e *Entry) Encode() ([]byte, error) {
+	meta, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer(make([]byte, 0, 1+binary.MaxVarintLen64+len(meta)+len(e.Value)))
+	if e.Encoding != "" {
+		buf.WriteByte(compressedEntryVersion)
+	} else {
+		buf.WriteByte(entryVersion)
+	}
+	var size [binary.MaxVarintLen64]byte
+	buf.Write(size[:binary.PutUvarint(size[:], uint64(len(meta)))])
+	buf.Write(meta)
+	buf.Write(e.Value)
+	return buf.Bytes(), nil
+}
+
+// DecodeEntry parses an entry produced by Encode
+func DecodeEntry(data []byte) (*Entry, error) {
+	if len(data) < 2 || (data[0] != entryVersion && data[0] != compressedEntryVersion) {
+		return nil, ErrCorruptEntry
+	}
+	size, n := binary.Uvarint(data[1:])
+	if n <= 0 || uint64(len(data)-1-n) < size {
+		return nil, ErrCorruptEntry
+	}
+	start := 1 + n
+	end := start + int(size)
+
+	var e Entry
+	if err := json.Unmarshal(data[start:end], &e); err != nil {
+		return nil, ErrCorruptEntry
+	}
+	e.Value = data[end:]
+	return &e, nil
+}
@@ -0,0 +1,79 @@
+package cache
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// hotKeysKey is the Redis sorted set counting lookups per cache key across replicas
+const hotKeysKey = "rdap:hotkeys"
+
+// hotKeysRetained is the number of most requested keys kept in the sorted set
+const hotKeysRetained = 100000
+
+// hotKeysFlushInterval is how often locally counted lookups are added to the sorted set
+const hotKeysFlushInterval = 10 * time.Second
+
+// hotKeyCounter batches lookup counts locally and adds them to the shared sorted set, so
+// recording a lookup costs no Redis round trip
+type hotKeyCounter struct {
+	mu        sync.Mutex
+	counts    map[string]float64
+	lastFlush time.Time
+	client    redis.UniversalClient
+}
+
+func newHotKeyCounter(client redis.UniversalClient) *hotKeyCounter {
+	return &hotKeyCounter{counts: make(map[string]float64), lastFlush: time.Now(), client: client}
+}
+
+// record counts a lookup of key, flushing the batch in the background when it is due
+func (h *hotKeyCounter) record(key string) {
+	h.mu.Lock()
+	h.counts[key]++
+	due := time.Since(h.lastFlush) >= hotKeysFlushInterval
+	var batch map[string]float64
+	if due {
+		batch, h.counts, h.lastFlush = h.counts, make(map[string]float64), time.Now()
+	}
+	h.mu.Unlock()
+
+	if batch != nil {
+		go h.flush(batch)
+	}
+}
+
+func (h *hotKeyCounter) flush(batch map[string]float64) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	pipe := h.client.Pipeline()
+	for key, n := range batch {
+		pipe.ZIncrBy(ctx, hotKeysKey, n, key)
+	}
+	pipe.ZRemRangeByRank(ctx, hotKeysKey, 0, -hotKeysRetained-1)
+	if _, err := pipe.Exec(ctx); err != nil {
+		log.Printf("Failed to record hot cache keys: %v", err)
+	}
+}
+
+// RecordLookup counts a lookup of key towards the hot keys shared through Redis. It does
+// nothing when Redis is disabled.
+func (cm *CacheManager) RecordLookup(key string) {
+	if cm.hotKeys != nil {
+		cm.hotKeys.record(key)
+	}
+}
+
+// HotKeys returns up to n of the most requested cache keys across all replicas, most
+// requested first. It returns nothing when Redis is disabled.
+func (cm *CacheManager) HotKeys(ctx context.Context, n int) ([]string, error) {
+	if cm.redis == nil || n <= 0 {
+		return nil, nil
+	}
+	return cm.redis.ZRevRange(ctx, hotKeysKey, 0, int64(n-1)).Result()
+}
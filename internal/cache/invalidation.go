@@ -0,0 +1,151 @@
+package cache
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// DefaultInvalidationChannel is the Redis pub/sub channel carrying invalidation events
+const DefaultInvalidationChannel = "rdap:cache:invalidate"
+
+// Invalidation describes cache entries every replica must drop from its local tier. An entry
+// matches when its key is listed in Keys, starts with Prefix, or matches the glob Pattern,
+// which uses the syntax of path.Match and Redis SCAN, such as "domain:*.com".
+type Invalidation struct {
+	Keys    []string  `json:"keys,omitempty"`
+	Prefix  string    `json:"prefix,omitempty"`
+	Pattern string    `json:"pattern,omitempty"`
+	Origin  string    `json:"origin"`
+	SentAt  time.Time `json:"sentAt"`
+}
+
+// Validate checks that the invalidation selects something and that its pattern is well formed
+func (inv Invalidation) Validate() error {
+	if len(inv.Keys) == 0 && inv.Prefix == "" && inv.Pattern == "" {
+		return fmt.Errorf("invalidation needs keys, a prefix or a pattern")
+	}
+	if inv.Pattern != "" {
+		if _, err := path.Match(inv.Pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", inv.Pattern, err)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether a key is selected by the invalidation
+func (inv Invalidation) Matches(key string) bool {
+	for _, k := range inv.Keys {
+		if k == key {
+			return true
+		}
+	}
+	if inv.Prefix != "" && strings.HasPrefix(key, inv.Prefix) {
+		return true
+	}
+	if inv.Pattern != "" {
+		matched, _ := path.Match(inv.Pattern, key)
+		return matched
+	}
+	return false
+}
+
+// scanPatterns returns the Redis SCAN patterns covering the prefix and pattern of the invalidation
+func (inv Invalidation) scanPatterns() []string {
+	var patterns []string
+	if inv.Prefix != "" {
+		patterns = append(patterns, globEscaper.Replace(inv.Prefix)+"*")
+	}
+	if inv.Pattern != "" {
+		patterns = append(patterns, inv.Pattern)
+	}
+	return patterns
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+// InvalidationBus carries invalidation events between replicas
+type InvalidationBus interface {
+	// Publish sends an invalidation to every replica
+	Publish(ctx context.Context, inv Invalidation) error
+	// Subscribe calls fn for every invalidation received until ctx is done
+	Subscribe(ctx context.Context, fn func(Invalidation)) error
+	// Close releases the resources of the bus
+	Close() error
+}
+
+// RedisInvalidationBus publishes invalidation events over Redis pub/sub
+type RedisInvalidationBus struct {
+	client  redis.UniversalClient
+	channel string
+}
+
+// NewRedisInvalidationBus creates an invalidation bus on a Redis pub/sub channel
+func NewRedisInvalidationBus(client redis.UniversalClient, channel string) *RedisInvalidationBus {
+	if channel == "" {
+		channel = DefaultInvalidationChannel
+	}
+	return &RedisInvalidationBus{client: client, channel: channel}
+}
+
+// Publish sends an invalidation to every subscribed replica
+func (b *RedisInvalidationBus) Publish(ctx context.Context, inv Invalidation) error {
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	return b.client.Publish(ctx, b.channel, data).Err()
+}
+
+// Subscribe calls fn for every invalidation published on the channel until ctx is done
+func (b *RedisInvalidationBus) Subscribe(ctx context.Context, fn func(Invalidation)) error {
+	pubsub := b.client.Subscribe(ctx, b.channel)
+	defer pubsub.Close()
+
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %v", b.channel, err)
+	}
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			var inv Invalidation
+			if err := json.Unmarshal([]byte(msg.Payload), &inv); err != nil {
+				log.Printf("Ignoring malformed cache invalidation: %v", err)
+				continue
+			}
+			fn(inv)
+		}
+	}
+}
+
+// Close is a no-op; the Redis client is owned by the cache
+func (b *RedisInvalidationBus) Close() error {
+	return nil
+}
+
+// newReplicaID returns an identifier for this process, used to recognize its own events
+func newReplicaID() string {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "rdap"
+	}
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return host + "-" + hex.EncodeToString(suffix)
+}
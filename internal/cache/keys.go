@@ -0,0 +1,58 @@
+package cache
+
+import (
+	"net"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/idna"
+)
+
+// Object types used as cache key prefixes
+const (
+	TypeDomain = "domain"
+	TypeIP     = "ip"
+	TypeAutnum = "autnum"
+)
+
+// NegativePrefix starts the keys of negative entries, which record that a lookup found nothing.
+// Keeping them apart from positive entries lets them be purged and counted on their own.
+const NegativePrefix = "neg:"
+
+// NegativeKey returns the key of the negative entry for a lookup key
+func NegativeKey(key string) string {
+	return NegativePrefix + key
+}
+
+// Key returns the normalized cache key for an RDAP lookup so that equivalent queries,
+// such as "Example.COM." and "example.com" or "AS15169" and "15169", share one entry
+func Key(objectType, query string) string {
+	return objectType + ":" + Canonical(objectType, query)
+}
+
+// Canonical returns the canonical form of a query for its object type, the form its cache
+// key is built from
+func Canonical(objectType, query string) string {
+	query = strings.TrimSpace(query)
+	switch objectType {
+	case TypeDomain:
+		name := strings.ToLower(strings.TrimSuffix(query, "."))
+		if ascii, err := idna.Lookup.ToASCII(name); err == nil {
+			return ascii
+		}
+		return name
+	case TypeIP:
+		if ip := net.ParseIP(query); ip != nil {
+			return ip.String()
+		}
+		if _, network, err := net.ParseCIDR(query); err == nil {
+			return network.String()
+		}
+	case TypeAutnum:
+		asn := strings.TrimPrefix(strings.ToUpper(query), "AS")
+		if n, err := strconv.ParseUint(asn, 10, 32); err == nil {
+			return strconv.FormatUint(n, 10)
+		}
+	}
+	return strings.ToLower(query)
+}
@@ -1,88 +1,478 @@
 package cache
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
 	"sync"
+	"time"
 
-	"github.com/VictoriaMetrics/fastcache"
+	"github.com/go-redis/redis/v8"
+	rdapconfig "github.com/ohelal/rdap/internal/config"
+	"github.com/ohelal/rdap/internal/redisclient"
 )
 
-// CacheManager manages both local and distributed caches
+// CacheManager reads and writes entries through an ordered list of cache tiers
 type CacheManager struct {
-	local       *fastcache.Cache
-	distributed *DistributedCache
-	mu          sync.RWMutex
-	config      CacheConfig
+	tiers []tier
+	// memory is the local tier, which snapshots save, or nil when it is not configured
+	memory *MemoryCache
+	// redis is the client of the Redis tier, which also shares the range index, hot keys
+	// and invalidations, or nil when it is not configured
+	redis  redis.UniversalClient
+	ranges *RangeIndex
+	mu     sync.RWMutex
+	config CacheConfig
+
+	bus       InvalidationBus
+	replicaID string
+
+	hotKeys *hotKeyCounter
+
+	// snapshotMu serializes snapshots of the local tier
+	snapshotMu sync.Mutex
 }
 
-// NewCacheManager creates a new cache manager
+// tier is a cache composed into a CacheManager
+type tier struct {
+	name  string
+	cache Cache
+	// maxTTL caps how long the tier keeps entries when it is set
+	maxTTL time.Duration
+	// shared is set for tiers seen by every replica, which invalidations are applied to by
+	// the replica that issues them only
+	shared bool
+}
+
+// Cache tiers that CacheConfig.Tiers may list, reported by GetEntry
+const (
+	TierLocal = "local"
+	TierDisk  = "disk"
+	TierRedis = "redis"
+)
+
+var _ Cache = (*CacheManager)(nil)
+
+// NewCacheManager creates a cache manager with the tiers listed in the configuration
 func NewCacheManager(config *CacheConfig) (*CacheManager, error) {
-	local := fastcache.New(int(config.MaxLocalSize))
+	names := config.Tiers
+	if len(names) == 0 {
+		names = []string{TierLocal}
+		if config.EnableRedis {
+			names = append(names, TierRedis)
+		}
+	}
 
-	var distributed *DistributedCache
-	var err error
-	if config.EnableRedis {
-		distributed, err = NewDistributedCache(config)
+	cm := &CacheManager{
+		ranges:    NewRangeIndex(nil),
+		config:    *config,
+		replicaID: newReplicaID(),
+	}
+	seen := make(map[string]bool)
+	for _, name := range names {
+		if seen[name] {
+			cm.closeTiers()
+			return nil, fmt.Errorf("cache tier %s is listed twice", name)
+		}
+		seen[name] = true
+
+		t, err := cm.newTier(name)
 		if err != nil {
+			cm.closeTiers()
 			return nil, err
 		}
+		cm.tiers = append(cm.tiers, t)
 	}
 
-	return &CacheManager{
-		local:       local,
-		distributed: distributed,
-		config:      *config,
-	}, nil
+	if cm.redis != nil {
+		cm.ranges = NewRangeIndex(cm.redis)
+		cm.hotKeys = newHotKeyCounter(cm.redis)
+	}
+	cm.indexRanges(context.Background())
+	return cm, nil
 }
 
-// Get retrieves a value from the cache
-func (cm *CacheManager) Get(key string) (interface{}, bool) {
-	// Try local cache first
-	if val := cm.local.Get(nil, []byte(key)); val != nil {
-		return val, true
+// newTier creates the cache of a tier
+func (cm *CacheManager) newTier(name string) (tier, error) {
+	config := &cm.config
+	switch name {
+	case TierLocal:
+		if config.MaxLocalSize < MinLocalSize {
+			return tier{}, fmt.Errorf("the local cache tier needs at least %d MB, got %d bytes", MinLocalSize>>20, config.MaxLocalSize)
+		}
+		codec, err := NewCodec(config.LocalCodec, config.Dictionary)
+		if err != nil {
+			return tier{}, err
+		}
+		cm.memory = cm.loadMemory(codec)
+		return tier{name: name, cache: cm.memory, maxTTL: config.LocalTTL}, nil
+
+	case TierDisk:
+		if config.DiskPath == "" {
+			return tier{}, fmt.Errorf("the disk cache tier needs a path")
+		}
+		codec, err := NewCodec(config.DiskCodec, config.Dictionary)
+		if err != nil {
+			return tier{}, err
+		}
+		disk, err := NewDiskCache(config.DiskPath, codec)
+		if err != nil {
+			return tier{}, fmt.Errorf("failed to open disk cache %s: %v", config.DiskPath, err)
+		}
+		return tier{name: name, cache: disk, maxTTL: config.DiskTTL}, nil
+
+	case TierRedis:
+		codec, err := NewCodec(config.RedisCodec, config.Dictionary)
+		if err != nil {
+			return tier{}, err
+		}
+		// Without a shared client, the tier connects to RedisURL and owns the connection
+		client, owned := config.Redis, false
+		if client == nil {
+			client, err = redisclient.New(rdapconfig.RedisConfig{
+				URL:          config.RedisURL,
+				PoolSize:     50,
+				MinIdleConns: 10,
+				DialTimeout:  5 * time.Second,
+				ReadTimeout:  3 * time.Second,
+				WriteTimeout: 3 * time.Second,
+			})
+			if err != nil {
+				return tier{}, err
+			}
+			owned = true
+		}
+		remote, err := NewRedisCache(client, codec)
+		if err != nil {
+			if owned {
+				client.Close()
+			}
+			return tier{}, err
+		}
+		remote.owned = owned
+		cm.redis = client
+		return tier{name: name, cache: remote, maxTTL: config.RedisTTL, shared: true}, nil
 	}
+	return tier{}, fmt.Errorf("unknown cache tier: %s", name)
+}
 
-	// Try distributed cache if available
-	if cm.distributed != nil {
-		if val, found := cm.distributed.Get(key); found {
-			// Update local cache
-			cm.local.Set([]byte(key), []byte(val.(string)))
-			return val, true
+// loadMemory creates the local tier, restored from the snapshot directory when one is
+// configured and holds a valid snapshot
+func (cm *CacheManager) loadMemory(codec Codec) *MemoryCache {
+	config := &cm.config
+	if config.SnapshotDir == "" {
+		return NewMemoryCache(config.MaxLocalSize, codec)
+	}
+
+	local, keys, err := loadSnapshot(config.SnapshotDir, config.MaxLocalSize)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Discarding local cache snapshot in %s: %v", config.SnapshotDir, err)
+			discardSnapshot(config.SnapshotDir)
 		}
+		return NewMemoryCache(config.MaxLocalSize, codec)
 	}
+	memory := newMemoryCache(local, config.MaxLocalSize, codec)
+	loaded, expired := memory.restoreKeys(keys)
+	log.Printf("Restored %d local cache entries from %s, dropped %d expired", loaded, config.SnapshotDir, expired)
+	return memory
+}
 
-	return nil, false
+// indexRanges adds the networks held by the tiers of this replica, restored from a snapshot
+// or kept on disk, to the range index
+func (cm *CacheManager) indexRanges(ctx context.Context) {
+	now := time.Now()
+	for _, t := range cm.tiers {
+		if t.shared {
+			continue
+		}
+		t.cache.Scan(ctx, TypeIP+":net:*", func(key string) error {
+			r, ok := parseNetworkKey(key)
+			if !ok {
+				return nil
+			}
+			entry, err := t.cache.Get(ctx, key)
+			if err == nil && entry.Usable(now) {
+				cm.ranges.addLocal(&indexedRange{IPRange: r, key: key, expiresAt: entry.retainUntil()})
+			}
+			return nil
+		})
+	}
 }
 
-// Set stores a value in both caches
-func (cm *CacheManager) Set(key string, value interface{}) error {
-	// Update local cache
-	cm.local.Set([]byte(key), []byte(value.(string)))
+// GetValue retrieves a fresh value from the cache in the form it was stored
+func (cm *CacheManager) GetValue(key string) (interface{}, bool) {
+	entry, _, found := cm.GetEntry(context.Background(), key)
+	if !found || entry.Expired(time.Now()) {
+		return nil, false
+	}
+	val, err := entry.Decoded()
+	if err != nil {
+		return nil, false
+	}
+	return val, true
+}
 
-	// Update distributed cache if available
-	if cm.distributed != nil {
-		return cm.distributed.Set(key, value)
+// SetValue stores a value of any type in every tier, fresh for LocalTTL
+func (cm *CacheManager) SetValue(key string, value interface{}) error {
+	entry, err := NewEntry(value, cm.config.LocalTTL)
+	if err != nil {
+		return err
 	}
+	return cm.SetEntry(context.Background(), key, entry)
+}
 
-	return nil
+// Get returns the entry stored under key in the first tier that has it, or ErrCacheMiss.
+// Entries past their freshness lifetime are returned while they may still be served stale,
+// so callers must check Expired.
+func (cm *CacheManager) Get(ctx context.Context, key string) (*Entry, error) {
+	entry, _, found := cm.GetEntry(ctx, key)
+	if !found {
+		return nil, ErrCacheMiss
+	}
+	return entry, nil
+}
+
+// GetEntry retrieves an entry from the tiers in order and reports the tier that answered.
+// Entries past their freshness lifetime are returned while they may still be served stale,
+// so callers must check Expired; unusable entries count as misses and are dropped from the
+// tiers of this replica. Entries found in a later tier are copied to the earlier ones. Values
+// may still be compressed; use Plain to read them.
+func (cm *CacheManager) GetEntry(ctx context.Context, key string) (*Entry, string, bool) {
+	now := time.Now()
+	for i, t := range cm.tiers {
+		entry, err := t.cache.Get(ctx, key)
+		if err == nil && entry.Usable(now) {
+			cm.setTiers(ctx, key, entry, time.Until(entry.retainUntil()), cm.tiers[:i])
+			return entry, t.name, true
+		}
+		if !t.shared && (err == nil || errors.Is(err, ErrCorruptEntry)) {
+			t.cache.Delete(ctx, key)
+			cm.ranges.Remove(key)
+		}
+	}
+	return nil, "", false
+}
+
+// Set stores an entry in every tier for ttl, or until it can no longer be served when ttl is
+// zero, and for at most the lifetime configured for each tier
+func (cm *CacheManager) Set(ctx context.Context, key string, entry *Entry, ttl time.Duration) error {
+	if retain := time.Until(entry.retainUntil()); ttl <= 0 || ttl > retain {
+		ttl = retain
+	}
+	if ttl <= 0 {
+		return nil
+	}
+	return cm.setTiers(ctx, key, entry, ttl, cm.tiers)
+}
+
+// SetEntry stores an entry in every tier until it can no longer be served. Each tier keeps it
+// for at most the lifetime configured for it and compresses the value with its own codec.
+func (cm *CacheManager) SetEntry(ctx context.Context, key string, entry *Entry) error {
+	return cm.Set(ctx, key, entry, 0)
+}
+
+// setTiers stores an entry in the given tiers, capping ttl at the lifetime of each, and
+// returns the first error
+func (cm *CacheManager) setTiers(ctx context.Context, key string, entry *Entry, ttl time.Duration, tiers []tier) error {
+	var firstErr error
+	for _, t := range tiers {
+		tierTTL := ttl
+		if t.maxTTL > 0 && tierTTL > t.maxTTL {
+			tierTTL = t.maxTTL
+		}
+		if err := t.cache.Set(ctx, key, entry, tierTTL); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
 
-// Delete removes a value from both caches
-func (cm *CacheManager) Delete(key string) error {
-	// Remove from local cache
-	cm.local.Del([]byte(key))
+// Ranges returns the index of cached IP networks, shared through Redis when it is enabled
+func (cm *CacheManager) Ranges() *RangeIndex {
+	return cm.ranges
+}
 
-	// Remove from distributed cache if available
-	if cm.distributed != nil {
-		return cm.distributed.Delete(key)
+// Delete removes keys from every tier of this replica, including the shared tiers, and
+// returns how many entries its own tiers held. Nothing is published: other replicas keep
+// their local copies until they expire. Use Invalidate to drop those too.
+func (cm *CacheManager) Delete(ctx context.Context, keys ...string) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	removed := 0
+	var firstErr error
+	for _, t := range cm.tiers {
+		n, err := t.cache.Delete(ctx, keys...)
+		if !t.shared {
+			removed += n
+		}
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, key := range keys {
+		cm.ranges.Remove(key)
 	}
+	return removed, firstErr
+}
 
+// Scan calls fn once for every key matching pattern in any tier
+func (cm *CacheManager) Scan(ctx context.Context, pattern string, fn func(key string) error) error {
+	seen := make(map[string]struct{})
+	for _, t := range cm.tiers {
+		err := t.cache.Scan(ctx, pattern, func(key string) error {
+			if _, ok := seen[key]; ok {
+				return nil
+			}
+			seen[key] = struct{}{}
+			return fn(key)
+		})
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
-// Close closes all caches
+// Invalidate removes the selected entries from every tier and publishes the invalidation so
+// every other replica drops them from its own tiers. It returns the number of entries removed
+// from the tiers of this replica.
+func (cm *CacheManager) Invalidate(ctx context.Context, inv Invalidation) (int, error) {
+	if err := inv.Validate(); err != nil {
+		return 0, err
+	}
+	inv.Origin, inv.SentAt = cm.replicaID, time.Now()
+
+	removed, err := cm.applyInvalidation(ctx, inv, false)
+	invalidations.WithLabelValues("local").Inc()
+	if err != nil {
+		return removed, err
+	}
+	if _, err := cm.applyInvalidation(ctx, inv, true); err != nil {
+		return removed, err
+	}
+
+	cm.mu.RLock()
+	bus := cm.bus
+	cm.mu.RUnlock()
+	if bus != nil {
+		if err := bus.Publish(ctx, inv); err != nil {
+			return removed, err
+		}
+	}
+	return removed, nil
+}
+
+// StartInvalidation publishes invalidations on the bus and applies those received from other
+// replicas to the tiers of this replica until ctx is done
+func (cm *CacheManager) StartInvalidation(ctx context.Context, bus InvalidationBus) error {
+	cm.mu.Lock()
+	cm.bus = bus
+	cm.mu.Unlock()
+
+	return bus.Subscribe(ctx, func(inv Invalidation) {
+		if inv.Origin == cm.replicaID {
+			return
+		}
+		if !inv.SentAt.IsZero() {
+			invalidationLag.Observe(time.Since(inv.SentAt).Seconds())
+		}
+		if err := inv.Validate(); err != nil {
+			log.Printf("Ignoring cache invalidation from %s: %v", inv.Origin, err)
+			return
+		}
+		if _, err := cm.applyInvalidation(ctx, inv, false); err != nil {
+			log.Printf("Failed to apply cache invalidation from %s: %v", inv.Origin, err)
+		}
+		invalidations.WithLabelValues("remote").Inc()
+	})
+}
+
+// RedisInvalidationBus returns an invalidation bus on the cache's Redis connection, or nil
+// when Redis is disabled
+func (cm *CacheManager) RedisInvalidationBus(channel string) InvalidationBus {
+	if cm.redis == nil {
+		return nil
+	}
+	return NewRedisInvalidationBus(cm.redis, channel)
+}
+
+// applyInvalidation removes the selected entries from the shared tiers or from the tiers of
+// this replica, and returns how many were removed
+func (cm *CacheManager) applyInvalidation(ctx context.Context, inv Invalidation, shared bool) (int, error) {
+	removed := 0
+	for _, t := range cm.tiers {
+		if t.shared != shared {
+			continue
+		}
+		n, err := deleteMatching(ctx, t.cache, inv)
+		removed += n
+		invalidatedKeys.WithLabelValues(t.name).Add(float64(n))
+		if err != nil {
+			return removed, err
+		}
+	}
+	if !shared {
+		cm.ranges.RemoveMatching(inv.Matches)
+	}
+	return removed, nil
+}
+
+// deleteMatching removes the keys of an invalidation and the keys matching its prefix or
+// pattern from a cache, in batches
+func deleteMatching(ctx context.Context, c Cache, inv Invalidation) (int, error) {
+	removed, err := c.Delete(ctx, inv.Keys...)
+	if err != nil {
+		return removed, err
+	}
+
+	batch := make([]string, 0, 1000)
+	flush := func() error {
+		n, err := c.Delete(ctx, batch...)
+		removed += n
+		batch = batch[:0]
+		return err
+	}
+	for _, pattern := range inv.scanPatterns() {
+		err := c.Scan(ctx, pattern, func(key string) error {
+			batch = append(batch, key)
+			if len(batch) == cap(batch) {
+				return flush()
+			}
+			return nil
+		})
+		if err != nil {
+			return removed, err
+		}
+	}
+	return removed, flush()
+}
+
+// Close closes all tiers, saving a final snapshot of the local tier when a snapshot
+// directory is configured
 func (cm *CacheManager) Close() error {
-	if cm.distributed != nil {
-		return cm.distributed.Close()
+	if err := cm.SaveSnapshot(); err != nil {
+		log.Print(err)
 	}
-	return nil
+	cm.mu.RLock()
+	bus := cm.bus
+	cm.mu.RUnlock()
+	if bus != nil {
+		bus.Close()
+	}
+	return cm.closeTiers()
+}
+
+// closeTiers closes every tier and returns the first error
+func (cm *CacheManager) closeTiers() error {
+	var firstErr error
+	for _, t := range cm.tiers {
+		if err := t.cache.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
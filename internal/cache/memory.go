@@ -0,0 +1,173 @@
+package cache
+
+import (
+	"context"
+	"path"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/VictoriaMetrics/fastcache"
+)
+
+// MemoryCache is a Cache held in process memory by fastcache, which evicts the oldest entries
+// once it is full
+type MemoryCache struct {
+	local    *fastcache.Cache
+	codec    Codec
+	maxBytes int64
+
+	// keys indexes the keys written to fastcache, which cannot enumerate them, so Scan can
+	// find them. Keys evicted by fastcache are pruned from it in the background once it holds
+	// more than pruneAt keys.
+	mu      sync.RWMutex
+	keys    map[string]struct{}
+	pruneAt int
+	pruning atomic.Bool
+}
+
+// MinLocalSize is the smallest size of a MemoryCache. fastcache splits its memory into 512
+// buckets and values over 64 KB into 64 KB chunks spread across them; below this size a
+// bucket holds too few chunks, and large answers are evicted by the next few writes to any
+// of their buckets.
+const MinLocalSize = 128 << 20
+
+// maxIndexedKeys is the size of the key index above which keys evicted by fastcache are
+// pruned from it
+const maxIndexedKeys = 1 << 20
+
+// pruneGrowth is how many keys are added to the index after a prune before the next one, so
+// an index of mostly live keys is not scanned again on every write
+const pruneGrowth = maxIndexedKeys / 10
+
+// NewMemoryCache creates an in-memory cache of maxBytes that compresses values with codec
+func NewMemoryCache(maxBytes int64, codec Codec) *MemoryCache {
+	return newMemoryCache(fastcache.New(int(maxBytes)), maxBytes, codec)
+}
+
+func newMemoryCache(local *fastcache.Cache, maxBytes int64, codec Codec) *MemoryCache {
+	return &MemoryCache{
+		local:    local,
+		codec:    codec,
+		maxBytes: maxBytes,
+		keys:     make(map[string]struct{}),
+		pruneAt:  maxIndexedKeys,
+	}
+}
+
+// Get returns the entry stored under key. fastcache needs GetBig for values over 64 KB.
+func (m *MemoryCache) Get(ctx context.Context, key string) (*Entry, error) {
+	data := m.local.GetBig(nil, []byte(key))
+	if data == nil {
+		return nil, ErrCacheMiss
+	}
+	return decodeStored(data, m.codec)
+}
+
+// Set stores an entry compressed with the cache's codec. fastcache does not expire values, so
+// the freshness and stale lifetimes of the stored copy are capped at ttl instead.
+func (m *MemoryCache) Set(ctx context.Context, key string, entry *Entry, ttl time.Duration) error {
+	local, err := entry.encodeWith(m.codec)
+	if err != nil {
+		return err
+	}
+	if ttl > 0 {
+		limit := time.Now().Add(ttl)
+		if limit.Before(local.ExpiresAt) {
+			local.ExpiresAt = limit
+		}
+		if limit.Before(local.StaleUntil) {
+			local.StaleUntil = limit
+		}
+	}
+	data, err := local.Encode()
+	if err != nil {
+		return err
+	}
+	m.local.SetBig([]byte(key), data)
+
+	m.mu.Lock()
+	m.keys[key] = struct{}{}
+	prune := len(m.keys) > m.pruneAt && m.pruning.CompareAndSwap(false, true)
+	m.mu.Unlock()
+	if prune {
+		go m.prune()
+	}
+	return nil
+}
+
+// prune removes the keys evicted by fastcache from the index. The index is only read-locked
+// while the keys are checked, so lookups and writes are not held up for the whole scan.
+func (m *MemoryCache) prune() {
+	defer m.pruning.Store(false)
+
+	m.mu.RLock()
+	var evicted []string
+	for key := range m.keys {
+		if !m.local.Has([]byte(key)) {
+			evicted = append(evicted, key)
+		}
+	}
+	m.mu.RUnlock()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range evicted {
+		// The key may have been written again since it was checked
+		if !m.local.Has([]byte(key)) {
+			delete(m.keys, key)
+		}
+	}
+	m.pruneAt = max(maxIndexedKeys, len(m.keys)+pruneGrowth)
+}
+
+// Delete removes keys from fastcache and the key index
+func (m *MemoryCache) Delete(ctx context.Context, keys ...string) (int, error) {
+	removed := 0
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, key := range keys {
+		if _, ok := m.keys[key]; ok {
+			removed++
+		}
+		m.local.Del([]byte(key))
+		delete(m.keys, key)
+	}
+	return removed, nil
+}
+
+// Scan calls fn for the indexed keys matching pattern that are still in fastcache
+func (m *MemoryCache) Scan(ctx context.Context, pattern string, fn func(key string) error) error {
+	m.mu.RLock()
+	var keys []string
+	for key := range m.keys {
+		if matched, _ := path.Match(pattern, key); matched && m.local.Has([]byte(key)) {
+			keys = append(keys, key)
+		}
+	}
+	m.mu.RUnlock()
+
+	for _, key := range keys {
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close releases the memory of the cache
+func (m *MemoryCache) Close() error {
+	m.local.Reset()
+	return nil
+}
+
+func (m *MemoryCache) size(ctx context.Context) TierStats {
+	var fs fastcache.Stats
+	m.local.UpdateStats(&fs)
+	return TierStats{
+		Bytes:    fs.BytesSize,
+		MaxBytes: fs.MaxBytesSize,
+		Gets:     fs.GetCalls + fs.GetBigCalls,
+		Misses:   fs.Misses,
+	}
+}
@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	invalidations = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rdap_cache_invalidations_total",
+			Help: "Cache invalidations applied, by origin (local, remote)",
+		},
+		[]string{"origin"},
+	)
+
+	invalidatedKeys = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rdap_cache_invalidated_keys_total",
+			Help: "Cache keys removed by invalidations, by tier (local, redis)",
+		},
+		[]string{"tier"},
+	)
+
+	invalidationLag = promauto.NewHistogram(
+		prometheus.HistogramOpts{
+			Name:    "rdap_cache_invalidation_lag_seconds",
+			Help:    "Time between publishing an invalidation and applying it on another replica",
+			Buckets: []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+		},
+	)
+
+	compressionRatio = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "rdap_cache_compression_ratio",
+			Help:    "Compressed size of cache values relative to their original size, by codec",
+			Buckets: []float64{.05, .1, .15, .2, .25, .3, .4, .5, .75, 1},
+		},
+		[]string{"codec"},
+	)
+
+	compressionBytes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rdap_cache_compression_bytes_total",
+			Help: "Bytes of cache values passed to compression, by codec and size (original, compressed)",
+		},
+		[]string{"codec", "size"},
+	)
+
+	compressionSeconds = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rdap_cache_compression_seconds_total",
+			Help: "Time spent compressing and decompressing cache values, by codec and operation (encode, decode)",
+		},
+		[]string{"codec", "op"},
+	)
+
+	snapshotSaves = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rdap_cache_snapshot_saves_total",
+			Help: "Snapshots of the local cache tier, by result (ok, error)",
+		},
+		[]string{"result"},
+	)
+
+	snapshotDuration = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "rdap_cache_snapshot_duration_seconds",
+			Help: "Time taken by the last successful snapshot of the local cache tier",
+		},
+	)
+
+	snapshotRestored = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rdap_cache_snapshot_restored_entries_total",
+			Help: "Entries read from a local cache snapshot at startup, by result (loaded, expired)",
+		},
+		[]string{"result"},
+	)
+)
@@ -0,0 +1,498 @@
+package cache
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/netip"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// rangeIndexKey is the Redis sorted set that shares the IP range index between replicas, and
+// rangeExpiryKey the hash holding when each of its members expires
+const (
+	rangeIndexKey  = "rdap:ipranges"
+	rangeExpiryKey = "rdap:ipranges:expiry"
+)
+
+// rangeScanPage is the number of Redis members a lookup examines. A covering range further
+// along the index is left to the upstream query that follows the miss.
+const rangeScanPage = 64
+
+// rangeSweepInterval is how often expired ranges are removed from the shared index
+const rangeSweepInterval = 10 * time.Minute
+
+// IPRange is an inclusive range of IP addresses of one family
+type IPRange struct {
+	Start netip.Addr
+	End   netip.Addr
+}
+
+// NewIPRange returns the range between two addresses of the same family
+func NewIPRange(start, end netip.Addr) (IPRange, error) {
+	start, end = start.Unmap(), end.Unmap()
+	if !start.IsValid() || !end.IsValid() || start.BitLen() != end.BitLen() || end.Less(start) {
+		return IPRange{}, fmt.Errorf("invalid IP range %s - %s", start, end)
+	}
+	return IPRange{Start: start, End: end}, nil
+}
+
+// PrefixRange returns the range covered by a CIDR prefix
+func PrefixRange(prefix netip.Prefix) (IPRange, error) {
+	prefix = prefix.Masked()
+	if !prefix.IsValid() {
+		return IPRange{}, fmt.Errorf("invalid prefix %s", prefix)
+	}
+	start := prefix.Addr().Unmap()
+	bits := start.BitLen()
+	end := start.AsSlice()
+	for i := prefix.Bits(); i < bits; i++ {
+		end[i/8] |= 1 << (7 - uint(i%8))
+	}
+	endAddr, _ := netip.AddrFromSlice(end)
+	return NewIPRange(start, endAddr)
+}
+
+// Contains reports whether an address lies within the range
+func (r IPRange) Contains(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	return ip.BitLen() == r.Start.BitLen() && !ip.Less(r.Start) && !r.End.Less(ip)
+}
+
+// within reports whether the range lies inside another one without being equal to it
+func (r IPRange) within(other IPRange) bool {
+	return other.Contains(r.Start) && other.Contains(r.End) && r != other
+}
+
+// NetworkKey returns the cache key under which the network answer for a range is stored
+func NetworkKey(r IPRange) string {
+	return TypeIP + ":net:" + r.Start.String() + "-" + r.End.String()
+}
+
+// parseNetworkKey returns the range of a key produced by NetworkKey
+func parseNetworkKey(key string) (IPRange, bool) {
+	bounds, ok := strings.CutPrefix(key, TypeIP+":net:")
+	if !ok {
+		return IPRange{}, false
+	}
+	// IPv6 addresses contain no "-", so the last one separates the bounds
+	i := strings.LastIndex(bounds, "-")
+	if i < 0 {
+		return IPRange{}, false
+	}
+	start, err1 := netip.ParseAddr(bounds[:i])
+	end, err2 := netip.ParseAddr(bounds[i+1:])
+	if err1 != nil || err2 != nil {
+		return IPRange{}, false
+	}
+	r, err := NewIPRange(start, end)
+	return r, err == nil
+}
+
+// indexedRange is a range in the index together with the cache key of its network answer
+type indexedRange struct {
+	IPRange
+	key       string
+	expiresAt time.Time
+}
+
+// segment is a piece of the address space answered by the most specific range covering it
+type segment struct {
+	start, end netip.Addr
+	owner      *indexedRange
+}
+
+// RangeIndex maps IP addresses to cached network answers by the address ranges those answers
+// cover, so any address inside a cached network is answered without another upstream query.
+// The index is kept locally as disjoint segments and, when Redis is available, shared with
+// other replicas through a lexicographically ordered sorted set.
+type RangeIndex struct {
+	mu       sync.RWMutex
+	ranges   map[string]*indexedRange
+	segments []segment
+	redis    redis.UniversalClient
+}
+
+// NewRangeIndex creates a range index, shared through Redis when client is non-nil
+func NewRangeIndex(client redis.UniversalClient) *RangeIndex {
+	return &RangeIndex{ranges: make(map[string]*indexedRange), redis: client}
+}
+
+// Add records that the network answer stored under key covers a range until expiresAt.
+// Adding a range again only moves its expiry.
+func (ix *RangeIndex) Add(ctx context.Context, r IPRange, key string, expiresAt time.Time) error {
+	ix.addLocal(&indexedRange{IPRange: r, key: key, expiresAt: expiresAt})
+
+	if ix.redis == nil {
+		return nil
+	}
+	// The expiry is written first so a member never appears without one. Members share a
+	// score, as ZRANGEBYLEX requires.
+	member := rangeMember(r, key)
+	pipe := ix.redis.Pipeline()
+	pipe.HSet(ctx, rangeExpiryKey, member, expiresAt.Unix())
+	pipe.ZAdd(ctx, rangeIndexKey, &redis.Z{Member: member})
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// Lookup returns the key of the most specific unexpired network answer covering an address
+func (ix *RangeIndex) Lookup(ctx context.Context, ip netip.Addr) (string, bool) {
+	ip = ip.Unmap()
+	now := time.Now()
+
+	for {
+		ix.mu.RLock()
+		owner := ix.find(ip)
+		ix.mu.RUnlock()
+		if owner == nil {
+			break
+		}
+		if now.Before(owner.expiresAt) {
+			return owner.key, true
+		}
+		// An expired range may hide a less specific one that is still valid
+		ix.Remove(owner.key)
+	}
+
+	if ix.redis == nil {
+		return "", false
+	}
+	found, ok := ix.lookupRedis(ctx, ip, now)
+	if !ok {
+		return "", false
+	}
+	ix.addLocal(found)
+	return found.key, true
+}
+
+// Remove drops the range of a network answer from the local index
+func (ix *RangeIndex) Remove(key string) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if _, ok := ix.ranges[key]; !ok {
+		return
+	}
+	delete(ix.ranges, key)
+	ix.rebuild()
+}
+
+// RemoveMatching drops the ranges of the network answers whose keys match from the local index
+func (ix *RangeIndex) RemoveMatching(match func(key string) bool) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	removed := false
+	for key := range ix.ranges {
+		if match(key) {
+			delete(ix.ranges, key)
+			removed = true
+		}
+	}
+	if removed {
+		ix.rebuild()
+	}
+}
+
+// Len returns the number of ranges in the local index
+func (ix *RangeIndex) Len() int {
+	ix.mu.RLock()
+	defer ix.mu.RUnlock()
+	return len(ix.ranges)
+}
+
+func (ix *RangeIndex) addLocal(r *indexedRange) {
+	ix.mu.Lock()
+	defer ix.mu.Unlock()
+
+	if old, ok := ix.ranges[r.key]; ok {
+		if old.IPRange == r.IPRange {
+			old.expiresAt = r.expiresAt
+			return
+		}
+		delete(ix.ranges, r.key)
+		ix.rebuild()
+	}
+	ix.ranges[r.key] = r
+	ix.insert(r)
+}
+
+// find returns the owner of the segment containing an address; the caller holds the lock
+func (ix *RangeIndex) find(ip netip.Addr) *indexedRange {
+	i := sort.Search(len(ix.segments), func(i int) bool {
+		return !ix.segments[i].end.Less(ip)
+	})
+	if i < len(ix.segments) && !ip.Less(ix.segments[i].start) {
+		return ix.segments[i].owner
+	}
+	return nil
+}
+
+// rebuild recomputes the segments from the remaining ranges, least specific first
+func (ix *RangeIndex) rebuild() {
+	ranges := make([]*indexedRange, 0, len(ix.ranges))
+	for _, r := range ix.ranges {
+		ranges = append(ranges, r)
+	}
+	sort.Slice(ranges, func(i, j int) bool {
+		return ranges[j].within(ranges[i].IPRange)
+	})
+
+	ix.segments = ix.segments[:0]
+	for _, r := range ranges {
+		ix.insert(r)
+	}
+}
+
+// insert adds a range to the segments. Where it overlaps existing segments it takes them over
+// unless they belong to a more specific range. The caller holds the lock.
+func (ix *RangeIndex) insert(r *indexedRange) {
+	segs := ix.segments
+	i := sort.Search(len(segs), func(i int) bool {
+		return !segs[i].end.Less(r.Start)
+	})
+
+	var out []segment
+	cur, covered := r.Start, false
+	j := i
+	for ; j < len(segs) && !r.End.Less(segs[j].start); j++ {
+		s := segs[j]
+		if s.start.Less(r.Start) {
+			out = append(out, segment{s.start, r.Start.Prev(), s.owner})
+		}
+		if !covered && cur.Less(s.start) {
+			out = append(out, segment{cur, s.start.Prev(), r})
+		}
+
+		start, end := maxAddr(s.start, r.Start), minAddr(s.end, r.End)
+		owner := r
+		if s.owner.within(r.IPRange) {
+			owner = s.owner
+		}
+		out = append(out, segment{start, end, owner})
+
+		if r.End.Less(s.end) {
+			out = append(out, segment{r.End.Next(), s.end, s.owner})
+		}
+		if end == r.End {
+			covered = true
+		} else {
+			cur = end.Next()
+		}
+	}
+	if !covered {
+		out = append(out, segment{cur, r.End, r})
+	}
+
+	merged := make([]segment, 0, len(segs)-(j-i)+len(out))
+	merged = append(merged, segs[:i]...)
+	merged = append(merged, out...)
+	merged = append(merged, segs[j:]...)
+	ix.segments = merged
+}
+
+// lookupRedis reads one page of the shared index for the most specific range covering an
+// address, in two round trips. Members are ordered by range end and, for equal ends, by
+// descending start, so the first covering member is the most specific one. Networks cached
+// after the address push a covering one off the page, which is then reported as a miss.
+func (ix *RangeIndex) lookupRedis(ctx context.Context, ip netip.Addr, now time.Time) (*indexedRange, bool) {
+	members, err := ix.redis.ZRangeByLex(ctx, rangeIndexKey, &redis.ZRangeBy{
+		Min:   "[" + familyPrefix(ip) + hex.EncodeToString(ip.AsSlice()),
+		Max:   "(" + familyPrefix(ip) + "~",
+		Count: rangeScanPage,
+	}).Result()
+	if err != nil || len(members) == 0 {
+		return nil, false
+	}
+	expiries, err := ix.redis.HMGet(ctx, rangeExpiryKey, members...).Result()
+	if err != nil {
+		return nil, false
+	}
+
+	var found *indexedRange
+	var expired []string
+	for i, member := range members {
+		r, ok := parseRangeMember(member, expiries[i])
+		if !ok || !now.Before(r.expiresAt) {
+			expired = append(expired, member)
+			continue
+		}
+		if r.Contains(ip) {
+			found = r
+			break
+		}
+	}
+	ix.removeMembers(ctx, expired)
+	return found, found != nil
+}
+
+// StartSweeps removes expired ranges from the shared index every rangeSweepInterval until ctx
+// is done. Every replica may run it.
+func (ix *RangeIndex) StartSweeps(ctx context.Context) {
+	if ix.redis == nil {
+		return
+	}
+	ticker := time.NewTicker(rangeSweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := ix.Sweep(ctx, time.Now()); err != nil {
+				log.Printf("Failed to sweep the shared IP range index: %v", err)
+			}
+		}
+	}
+}
+
+// Sweep removes the ranges that expired before now from the shared index, along with members
+// that have no expiry, and returns how many were removed
+func (ix *RangeIndex) Sweep(ctx context.Context, now time.Time) (int, error) {
+	removed := 0
+	var cursor uint64
+	for {
+		// ZSCAN returns members followed by their scores
+		page, next, err := ix.redis.ZScan(ctx, rangeIndexKey, cursor, "", 1000).Result()
+		if err != nil {
+			return removed, err
+		}
+		var members []string
+		for i := 0; i < len(page); i += 2 {
+			members = append(members, page[i])
+		}
+		if len(members) > 0 {
+			expiries, err := ix.redis.HMGet(ctx, rangeExpiryKey, members...).Result()
+			if err != nil {
+				return removed, err
+			}
+			var expired []string
+			for i, member := range members {
+				if r, ok := parseRangeMember(member, expiries[i]); !ok || !now.Before(r.expiresAt) {
+					expired = append(expired, member)
+				}
+			}
+			ix.removeMembers(ctx, expired)
+			removed += len(expired)
+		}
+		if cursor = next; cursor == 0 {
+			break
+		}
+	}
+
+	// Expiries left behind by a failed ZADD
+	for {
+		page, next, err := ix.redis.HScan(ctx, rangeExpiryKey, cursor, "", 1000).Result()
+		if err != nil {
+			return removed, err
+		}
+		var expired []string
+		for i := 0; i+1 < len(page); i += 2 {
+			if expiry, err := strconv.ParseInt(page[i+1], 10, 64); err != nil || !now.Before(time.Unix(expiry, 0)) {
+				expired = append(expired, page[i])
+			}
+		}
+		if len(expired) > 0 {
+			ix.redis.HDel(ctx, rangeExpiryKey, expired...)
+		}
+		if cursor = next; cursor == 0 {
+			return removed, nil
+		}
+	}
+}
+
+// removeMembers drops members and their expiries from the shared index
+func (ix *RangeIndex) removeMembers(ctx context.Context, members []string) {
+	if len(members) == 0 {
+		return
+	}
+	zmembers := make([]interface{}, len(members))
+	for i, member := range members {
+		zmembers[i] = member
+	}
+	pipe := ix.redis.Pipeline()
+	pipe.ZRem(ctx, rangeIndexKey, zmembers...)
+	pipe.HDel(ctx, rangeExpiryKey, members...)
+	pipe.Exec(ctx)
+}
+
+// rangeMember encodes a range as "<family><end>|<inverted start>|<key>"
+func rangeMember(r IPRange, key string) string {
+	start := r.Start.AsSlice()
+	for i := range start {
+		start[i] = ^start[i]
+	}
+	return familyPrefix(r.End) + hex.EncodeToString(r.End.AsSlice()) + "|" +
+		hex.EncodeToString(start) + "|" + key
+}
+
+// parseRangeMember decodes a member of the shared index and its expiry, as read from the
+// expiry hash. Members without an expiry, including those written with the expiry inside the
+// member by earlier versions, are rejected.
+func parseRangeMember(member string, expiry interface{}) (*indexedRange, bool) {
+	unix, ok := expiry.(string)
+	if !ok {
+		return nil, false
+	}
+	expiresAt, err := strconv.ParseInt(unix, 10, 64)
+	if err != nil {
+		return nil, false
+	}
+
+	parts := strings.SplitN(member, "|", 3)
+	if len(parts) != 3 || len(parts[0]) < 1 {
+		return nil, false
+	}
+	end, err := hex.DecodeString(parts[0][1:])
+	if err != nil {
+		return nil, false
+	}
+	start, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return nil, false
+	}
+	for i := range start {
+		start[i] = ^start[i]
+	}
+
+	startAddr, ok1 := netip.AddrFromSlice(start)
+	endAddr, ok2 := netip.AddrFromSlice(end)
+	if !ok1 || !ok2 {
+		return nil, false
+	}
+	r, err := NewIPRange(startAddr, endAddr)
+	if err != nil {
+		return nil, false
+	}
+	return &indexedRange{IPRange: r, key: parts[2], expiresAt: time.Unix(expiresAt, 0)}, true
+}
+
+// familyPrefix keeps IPv4 and IPv6 members apart in the shared index
+func familyPrefix(ip netip.Addr) string {
+	if ip.Is4() {
+		return "4"
+	}
+	return "6"
+}
+
+func maxAddr(a, b netip.Addr) netip.Addr {
+	if a.Less(b) {
+		return b
+	}
+	return a
+}
+
+func minAddr(a, b netip.Addr) netip.Addr {
+	if a.Less(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,88 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/ohelal/rdap/internal/redisclient"
+)
+
+// RedisCache is a Cache in Redis, shared by every replica
+type RedisCache struct {
+	client redis.UniversalClient
+	codec  Codec
+	// owned is set when the cache created the client and must close it
+	owned bool
+}
+
+// NewRedisCache creates a cache on client that compresses values with codec, after checking
+// that Redis is reachable
+func NewRedisCache(client redis.UniversalClient, codec Codec) (*RedisCache, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := client.Ping(ctx).Err(); err != nil {
+		return nil, fmt.Errorf("failed to connect to Redis: %v", err)
+	}
+	return &RedisCache{client: client, codec: codec}, nil
+}
+
+// Get returns the entry stored under key
+func (c *RedisCache) Get(ctx context.Context, key string) (*Entry, error) {
+	data, err := c.client.Get(ctx, key).Bytes()
+	if err == redis.Nil {
+		return nil, ErrCacheMiss
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeStored(data, c.codec)
+}
+
+// Set stores an entry compressed with the cache's codec, expiring after ttl
+func (c *RedisCache) Set(ctx context.Context, key string, entry *Entry, ttl time.Duration) error {
+	ttl = storeFor(entry, ttl)
+	if ttl <= 0 {
+		return nil
+	}
+	remote, err := entry.encodeWith(c.codec)
+	if err != nil {
+		return err
+	}
+	data, err := remote.Encode()
+	if err != nil {
+		return err
+	}
+	if err := c.client.Set(ctx, key, data, ttl).Err(); err != nil {
+		return fmt.Errorf("failed to set cache: %v", err)
+	}
+	return nil
+}
+
+// Delete removes keys, which may be in different cluster slots
+func (c *RedisCache) Delete(ctx context.Context, keys ...string) (int, error) {
+	return redisclient.Del(ctx, c.client, keys...)
+}
+
+// Scan calls fn for every key matching a SCAN pattern, on every master of a cluster
+func (c *RedisCache) Scan(ctx context.Context, pattern string, fn func(key string) error) error {
+	return redisclient.Scan(ctx, c.client, pattern, fn)
+}
+
+// Close closes the Redis connection when the cache created it
+func (c *RedisCache) Close() error {
+	if !c.owned {
+		return nil
+	}
+	return c.client.Close()
+}
+
+func (c *RedisCache) size(ctx context.Context) TierStats {
+	var stats TierStats
+	if info, err := c.client.Info(ctx, "memory").Result(); err == nil {
+		stats.Bytes = infoField(info, "used_memory")
+	}
+	return stats
+}
@@ -0,0 +1,303 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/VictoriaMetrics/fastcache"
+)
+
+// Files of a local cache snapshot inside the snapshot directory
+const (
+	snapshotDataDir  = "local"
+	snapshotKeysFile = "keys"
+	snapshotManifest = "manifest.json"
+	snapshotVersion  = 1
+)
+
+// maxSnapshotKeyLen bounds the length of a key read from a snapshot
+const maxSnapshotKeyLen = 64 << 10
+
+// snapshotMeta records what a snapshot contains so an incomplete or corrupt one is detected
+type snapshotMeta struct {
+	Version   int               `json:"version"`
+	SavedAt   time.Time         `json:"savedAt"`
+	MaxBytes  int64             `json:"maxBytes"`
+	Keys      int               `json:"keys"`
+	Checksums map[string]string `json:"checksums"`
+}
+
+// SaveSnapshot writes the local tier and its key index to the snapshot directory. The
+// manifest is replaced last, so a snapshot interrupted midway fails verification on load.
+func (cm *CacheManager) SaveSnapshot() error {
+	dir := cm.config.SnapshotDir
+	if dir == "" || cm.memory == nil {
+		return nil
+	}
+	cm.snapshotMu.Lock()
+	defer cm.snapshotMu.Unlock()
+
+	start := time.Now()
+	err := cm.memory.saveSnapshot(dir)
+	if err != nil {
+		snapshotSaves.WithLabelValues("error").Inc()
+		return fmt.Errorf("failed to save cache snapshot: %v", err)
+	}
+	snapshotSaves.WithLabelValues("ok").Inc()
+	snapshotDuration.Set(time.Since(start).Seconds())
+	return nil
+}
+
+func (m *MemoryCache) saveSnapshot(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	// Invalidate the current snapshot before its files are replaced
+	if err := os.Remove(filepath.Join(dir, snapshotManifest)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	if err := m.local.SaveToFileConcurrent(filepath.Join(dir, snapshotDataDir), 0); err != nil {
+		return err
+	}
+
+	// Keys are collected after the data so every entry in the snapshot is indexed; keys
+	// written in between whose entries are missing are skipped on load
+	m.mu.RLock()
+	keys := make([]string, 0, len(m.keys))
+	for key := range m.keys {
+		keys = append(keys, key)
+	}
+	m.mu.RUnlock()
+	sort.Strings(keys)
+
+	if err := writeKeys(filepath.Join(dir, snapshotKeysFile), keys); err != nil {
+		return err
+	}
+
+	checksums, err := snapshotChecksums(dir)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(snapshotMeta{
+		Version:   snapshotVersion,
+		SavedAt:   time.Now(),
+		MaxBytes:  m.maxBytes,
+		Keys:      len(keys),
+		Checksums: checksums,
+	})
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(dir, snapshotManifest), data)
+}
+
+// StartSnapshots saves the local tier every SnapshotInterval until ctx is done
+func (cm *CacheManager) StartSnapshots(ctx context.Context) {
+	if cm.config.SnapshotDir == "" || cm.config.SnapshotInterval <= 0 || cm.memory == nil {
+		return
+	}
+	ticker := time.NewTicker(cm.config.SnapshotInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := cm.SaveSnapshot(); err != nil {
+				log.Print(err)
+			}
+		}
+	}
+}
+
+// loadSnapshot returns the local tier and key index saved in dir after verifying them against
+// the manifest
+func loadSnapshot(dir string, maxBytes int64) (*fastcache.Cache, []string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, snapshotManifest))
+	if err != nil {
+		return nil, nil, err
+	}
+	var meta snapshotMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, nil, fmt.Errorf("invalid manifest: %v", err)
+	}
+	if meta.Version != snapshotVersion {
+		return nil, nil, fmt.Errorf("unsupported snapshot version %d", meta.Version)
+	}
+	if meta.MaxBytes != maxBytes {
+		return nil, nil, fmt.Errorf("snapshot was taken with a %d byte cache, not %d", meta.MaxBytes, maxBytes)
+	}
+
+	checksums, err := snapshotChecksums(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(checksums) != len(meta.Checksums) {
+		return nil, nil, fmt.Errorf("snapshot has %d files, manifest lists %d", len(checksums), len(meta.Checksums))
+	}
+	for name, sum := range meta.Checksums {
+		if checksums[name] != sum {
+			return nil, nil, fmt.Errorf("checksum mismatch for %s", name)
+		}
+	}
+
+	keys, err := readKeys(filepath.Join(dir, snapshotKeysFile))
+	if err != nil {
+		return nil, nil, err
+	}
+	local, err := fastcache.LoadFromFile(filepath.Join(dir, snapshotDataDir))
+	if err != nil {
+		return nil, nil, err
+	}
+	return local, keys, nil
+}
+
+// restoreKeys rebuilds the key index from a loaded snapshot, dropping entries that can no
+// longer be served
+func (m *MemoryCache) restoreKeys(keys []string) (loaded, expired int) {
+	now := time.Now()
+	for _, key := range keys {
+		data := m.local.GetBig(nil, []byte(key))
+		if data == nil {
+			continue
+		}
+		entry, err := DecodeEntry(data)
+		if err != nil || !entry.Usable(now) {
+			m.local.Del([]byte(key))
+			expired++
+			continue
+		}
+		m.keys[key] = struct{}{}
+		loaded++
+	}
+	snapshotRestored.WithLabelValues("loaded").Add(float64(loaded))
+	snapshotRestored.WithLabelValues("expired").Add(float64(expired))
+	return loaded, expired
+}
+
+// snapshotChecksums returns the SHA-256 of every file in the snapshot except the manifest,
+// keyed by path relative to dir
+func snapshotChecksums(dir string) (map[string]string, error) {
+	checksums := make(map[string]string)
+	err := filepath.WalkDir(dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || rel == snapshotManifest || strings.HasPrefix(d.Name(), ".") {
+			return nil
+		}
+		if rel != snapshotKeysFile && !strings.HasPrefix(rel, snapshotDataDir+string(filepath.Separator)) {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		h := sha256.New()
+		if _, err := io.Copy(h, f); err != nil {
+			return err
+		}
+		checksums[filepath.ToSlash(rel)] = hex.EncodeToString(h.Sum(nil))
+		return nil
+	})
+	return checksums, err
+}
+
+// discardSnapshot removes the files of a snapshot that failed to load
+func discardSnapshot(dir string) {
+	for _, name := range []string{snapshotManifest, snapshotKeysFile, snapshotDataDir} {
+		os.RemoveAll(filepath.Join(dir, name))
+	}
+}
+
+// writeKeys writes keys as records of their length as a uvarint followed by their bytes, since
+// unescaped keys may contain any byte
+func writeKeys(path string, keys []string) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	var length [binary.MaxVarintLen64]byte
+	for _, key := range keys {
+		w.Write(length[:binary.PutUvarint(length[:], uint64(len(key)))])
+		w.WriteString(key)
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readKeys reads the records written by writeKeys
+func readKeys(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var keys []string
+	r := bufio.NewReader(f)
+	for {
+		n, err := binary.ReadUvarint(r)
+		if err == io.EOF {
+			return keys, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid keys file: %v", err)
+		}
+		if n > maxSnapshotKeyLen {
+			return nil, fmt.Errorf("invalid keys file: key of %d bytes", n)
+		}
+		key := make([]byte, n)
+		if _, err := io.ReadFull(r, key); err != nil {
+			return nil, fmt.Errorf("invalid keys file: %v", err)
+		}
+		keys = append(keys, string(key))
+	}
+}
+
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
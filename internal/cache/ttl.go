@@ -0,0 +1,100 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TTLBounds limits how long objects of one type are kept
+type TTLBounds struct {
+	Min     time.Duration
+	Max     time.Duration
+	Default time.Duration
+}
+
+// Clamp keeps a TTL within the bounds, using Default when the upstream gave none
+func (b TTLBounds) Clamp(ttl time.Duration, ok bool) time.Duration {
+	if !ok {
+		ttl = b.Default
+	}
+	if b.Min > 0 && ttl < b.Min {
+		ttl = b.Min
+	}
+	if b.Max > 0 && ttl > b.Max {
+		ttl = b.Max
+	}
+	return ttl
+}
+
+// Cacheable reports whether a response may be stored, which is the case unless it carries
+// Cache-Control: no-store
+func Cacheable(header http.Header) bool {
+	_, noStore := cacheControl(header)["no-store"]
+	return !noStore
+}
+
+// FreshnessLifetime derives the remaining freshness of a response from its Cache-Control
+// (s-maxage, then max-age) or Expires and Date headers, minus its Age, following RFC 9111.
+// It reports false when the response carries no freshness information.
+func FreshnessLifetime(header http.Header, now time.Time) (time.Duration, bool) {
+	directives := cacheControl(header)
+
+	var lifetime time.Duration
+	found := false
+	if _, noCache := directives["no-cache"]; noCache {
+		lifetime, found = 0, true
+	} else if v, ok := directives["s-maxage"]; ok {
+		lifetime, found = parseSeconds(v)
+	} else if v, ok := directives["max-age"]; ok {
+		lifetime, found = parseSeconds(v)
+	}
+
+	if !found {
+		expires, err := http.ParseTime(header.Get("Expires"))
+		if err != nil {
+			// An invalid Expires value means the response is already stale
+			if header.Get("Expires") != "" {
+				return 0, true
+			}
+			return 0, false
+		}
+		date, err := http.ParseTime(header.Get("Date"))
+		if err != nil {
+			date = now
+		}
+		lifetime, found = expires.Sub(date), true
+	}
+
+	if age, ok := parseSeconds(header.Get("Age")); ok {
+		lifetime -= age
+	}
+	if lifetime < 0 {
+		lifetime = 0
+	}
+	return lifetime, found
+}
+
+// cacheControl parses the Cache-Control header into lower-cased directives and their values
+func cacheControl(header http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, line := range header.Values("Cache-Control") {
+		for _, part := range strings.Split(line, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+			if name == "" {
+				continue
+			}
+			directives[strings.ToLower(name)] = strings.Trim(value, `"`)
+		}
+	}
+	return directives
+}
+
+func parseSeconds(v string) (time.Duration, bool) {
+	n, err := strconv.ParseInt(strings.TrimSpace(v), 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
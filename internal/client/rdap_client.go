@@ -9,6 +9,8 @@ import (
 	"net/url"
 	"strings"
 	"time"
+
+	"github.com/ohelal/rdap/internal/outbound"
 )
 
 const (
@@ -30,6 +32,18 @@ func NewRDAPClient() *RDAPClient {
 	}
 }
 
+// NewRDAPClientWithProxy creates a client whose bootstrap and RDAP requests go through an outbound proxy
+func NewRDAPClientWithProxy(proxy outbound.ProxyConfig) (*RDAPClient, error) {
+	transport, err := outbound.NewTransport(proxy)
+	if err != nil {
+		return nil, fmt.Errorf("configuring proxy: %w", err)
+	}
+
+	c := NewRDAPClient()
+	c.httpClient.Transport = transport
+	return c, nil
+}
+
 type bootstrapResponse struct {
 	Services [][]interface{} `json:"services"`
 }
@@ -0,0 +1,60 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ohelal/rdap/internal/outbound"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRDAPClientProxy(t *testing.T) {
+	// The stub proxy answers plain HTTP requests itself and refuses to tunnel
+	requests := make(chan string, 4)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodConnect {
+			requests <- "CONNECT " + r.Host
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		requests <- r.Method + " " + r.URL.String() + " " + r.Header.Get("Proxy-Authorization")
+		w.Header().Set("Content-Type", "application/rdap+json")
+		w.Write([]byte(`{"objectClassName":"domain","ldhName":"example.com"}`))
+	}))
+	defer proxy.Close()
+
+	c, err := NewRDAPClientWithProxy(outbound.ProxyConfig{URL: proxy.URL, Username: "rdap", Password: "s3cret"})
+	require.NoError(t, err)
+
+	t.Run("RDAP requests go through the proxy", func(t *testing.T) {
+		result, err := c.makeRequest(context.Background(), "http://rdap.example/domain/example.com")
+		require.NoError(t, err)
+		assert.Equal(t, "example.com", result["ldhName"])
+		assert.Equal(t, "GET http://rdap.example/domain/example.com Basic cmRhcDpzM2NyZXQ=", <-requests)
+	})
+
+	t.Run("Bootstrap requests are tunnelled through the proxy", func(t *testing.T) {
+		_, err := c.QueryDomain(context.Background(), "example.com")
+		assert.Error(t, err)
+		assert.Equal(t, "CONNECT data.iana.org:443", <-requests)
+	})
+
+	t.Run("Bypassed hosts are reached directly", func(t *testing.T) {
+		registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"objectClassName":"domain"}`))
+		}))
+		defer registry.Close()
+
+		direct, err := NewRDAPClientWithProxy(outbound.ProxyConfig{URL: proxy.URL, NoProxy: []string{"127.0.0.1"}})
+		require.NoError(t, err)
+		_, err = direct.makeRequest(context.Background(), registry.URL+"/domain/example.com")
+		require.NoError(t, err)
+		assert.Empty(t, requests)
+	})
+
+	_, err = NewRDAPClientWithProxy(outbound.ProxyConfig{URL: "ftp://proxy.example"})
+	assert.Error(t, err)
+}
@@ -1,3 +1,118 @@
+// Package coalescing merges concurrent calls for the same key into one, so a burst of
+// identical lookups reaches the upstream servers once.
 package coalescing
 
-// Package implementation goes here
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Group runs at most one call per key at a time. Callers asking for a key while its call is
+// in flight wait for that call and share its result instead of starting another.
+//
+// Calls run detached from the context of any single caller: a caller that gives up only
+// stops waiting, and the call is cancelled once every caller waiting for it has gone.
+type Group[K comparable, V any] struct {
+	name string
+
+	mu    sync.Mutex
+	calls map[K]*call[V]
+}
+
+// call is a call in flight and the callers waiting for it
+type call[V any] struct {
+	done   chan struct{}
+	cancel context.CancelFunc
+	val    V
+	err    error
+	shared bool
+
+	// waiters counts the callers still waiting, callers all those that joined and finished
+	// whether fn has returned. They are guarded by the mutex of the group.
+	waiters  int
+	callers  int
+	finished bool
+}
+
+// NewGroup creates a group whose metrics are labelled with name
+func NewGroup[K comparable, V any](name string) *Group[K, V] {
+	return &Group[K, V]{name: name, calls: make(map[K]*call[V])}
+}
+
+// Do returns the result of fn for key, calling it only when no call for key is in flight and
+// otherwise waiting for that call. shared reports whether the result was also handed to
+// other callers, who must then not modify it.
+//
+// fn receives a context carrying the values of the context of the caller that started the
+// call, cancelled once all callers have stopped waiting. When ctx ends first, Do returns its
+// error.
+func (g *Group[K, V]) Do(ctx context.Context, key K, fn func(ctx context.Context) (V, error)) (v V, shared bool, err error) {
+	g.mu.Lock()
+	c, ok := g.calls[key]
+	if ok {
+		c.waiters++
+		c.callers++
+		g.mu.Unlock()
+		coalescedRequests.WithLabelValues(g.name, "follower").Inc()
+	} else {
+		callCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		c = &call[V]{done: make(chan struct{}), cancel: cancel, waiters: 1, callers: 1}
+		g.calls[key] = c
+		g.mu.Unlock()
+		coalescedRequests.WithLabelValues(g.name, "leader").Inc()
+		go g.run(callCtx, key, c, fn)
+	}
+
+	select {
+	case <-c.done:
+		return c.val, c.shared, c.err
+	case <-ctx.Done():
+		g.leave(key, c)
+		return v, false, ctx.Err()
+	}
+}
+
+// InFlight reports how many calls are running
+func (g *Group[K, V]) InFlight() int {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return len(g.calls)
+}
+
+// run calls fn and hands its result to the waiting callers
+func (g *Group[K, V]) run(ctx context.Context, key K, c *call[V], fn func(ctx context.Context) (V, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.err = fmt.Errorf("coalesced call panicked: %v", r)
+		}
+		c.cancel()
+
+		g.mu.Lock()
+		// The call was already removed when all callers left, and a new one may have
+		// taken its place
+		if g.calls[key] == c {
+			delete(g.calls, key)
+		}
+		c.finished = true
+		c.shared = c.callers > 1
+		g.mu.Unlock()
+
+		coalescedFanIn.WithLabelValues(g.name).Observe(float64(c.callers))
+		close(c.done)
+	}()
+	c.val, c.err = fn(ctx)
+}
+
+// leave stops a caller waiting for a call, cancelling the call when it was the last one
+func (g *Group[K, V]) leave(key K, c *call[V]) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	c.waiters--
+	if c.waiters > 0 || c.finished {
+		return
+	}
+	delete(g.calls, key)
+	c.cancel()
+	coalescedCancellations.WithLabelValues(g.name).Inc()
+}
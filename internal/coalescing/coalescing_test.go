@@ -0,0 +1,121 @@
+package coalescing
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGroupSharesOneCall(t *testing.T) {
+	g := NewGroup[string, int]("test")
+	release := make(chan struct{})
+	var calls int32
+
+	const callers = 50
+	var wg sync.WaitGroup
+	results := make(chan int, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			v, _, err := g.Do(context.Background(), "key", func(ctx context.Context) (int, error) {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return 42, nil
+			})
+			assert.NoError(t, err)
+			results <- v
+		}()
+	}
+	// Let every caller join before the call returns
+	require.Eventually(t, func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		c := g.calls["key"]
+		return c != nil && c.callers == callers
+	}, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(results)
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	for v := range results {
+		assert.Equal(t, 42, v)
+	}
+	assert.Equal(t, 0, g.InFlight())
+
+	// Later calls start afresh
+	v, shared, err := g.Do(context.Background(), "key", func(ctx context.Context) (int, error) {
+		return 7, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 7, v)
+	assert.False(t, shared)
+}
+
+func TestGroupCancelsWhenAllCallersLeave(t *testing.T) {
+	g := NewGroup[string, int]("test")
+	started := make(chan struct{})
+	cancelled := make(chan struct{})
+	fn := func(ctx context.Context) (int, error) {
+		close(started)
+		<-ctx.Done()
+		close(cancelled)
+		return 0, ctx.Err()
+	}
+
+	first, cancelFirst := context.WithCancel(context.Background())
+	second, cancelSecond := context.WithCancel(context.Background())
+	errs := make(chan error, 2)
+	go func() {
+		_, _, err := g.Do(first, "key", fn)
+		errs <- err
+	}()
+	<-started
+	go func() {
+		_, _, err := g.Do(second, "key", func(ctx context.Context) (int, error) {
+			t.Error("a second call ran while the first was in flight")
+			return 0, nil
+		})
+		errs <- err
+	}()
+	require.Eventually(t, func() bool {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return g.calls["key"].waiters == 2
+	}, time.Second, time.Millisecond)
+
+	// The leader giving up leaves the call running for the other caller
+	cancelFirst()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+	select {
+	case <-cancelled:
+		t.Fatal("call cancelled while a caller was still waiting")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cancelSecond()
+	assert.ErrorIs(t, <-errs, context.Canceled)
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Fatal("call not cancelled after every caller left")
+	}
+	assert.Equal(t, 0, g.InFlight())
+}
+
+func TestGroupRecoversPanics(t *testing.T) {
+	g := NewGroup[string, int]("test")
+	_, _, err := g.Do(context.Background(), "key", func(ctx context.Context) (int, error) {
+		panic("boom")
+	})
+	require.Error(t, err)
+	assert.False(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, 0, g.InFlight())
+}
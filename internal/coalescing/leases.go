@@ -0,0 +1,240 @@
+package coalescing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// DefaultLeaseChannel is the Redis pub/sub channel announcing that a lease was released
+const DefaultLeaseChannel = "rdap:coalesce:done"
+
+// leaseKeyPrefix prefixes the Redis keys holding leases
+const leaseKeyPrefix = "rdap:lease:"
+
+// Leases coalesce calls across replicas. The first replica to call for a key takes a Redis
+// lease on it and runs the call, which is expected to leave its result where the others can
+// read it, such as a shared cache. The other replicas wait until the result is ready, woken by
+// a pub/sub message when the lease is released and polling in between. When the lease holder
+// dies, its lease expires and a waiting replica takes it over.
+type Leases struct {
+	client  redis.UniversalClient
+	channel string
+	ttl     time.Duration
+	poll    time.Duration
+	maxWait time.Duration
+
+	mu      sync.Mutex
+	waiting map[string]map[chan struct{}]struct{}
+}
+
+// LeaseOptions configure Leases. A lease is held for TTL and renewed while its call runs.
+// Waiting replicas check for the result every PollInterval and give up after MaxWait.
+type LeaseOptions struct {
+	Channel      string
+	TTL          time.Duration
+	PollInterval time.Duration
+	MaxWait      time.Duration
+}
+
+// releaseScript deletes a lease only when it is still held by the caller
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// renewScript extends a lease only when it is still held by the caller
+var renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// NewLeases creates leases on client. Call Listen to be woken when leases are released;
+// without it, waiting replicas only poll.
+func NewLeases(client redis.UniversalClient, opts LeaseOptions) *Leases {
+	if opts.Channel == "" {
+		opts.Channel = DefaultLeaseChannel
+	}
+	return &Leases{
+		client:  client,
+		channel: opts.Channel,
+		ttl:     opts.TTL,
+		poll:    opts.PollInterval,
+		maxWait: opts.MaxWait,
+		waiting: make(map[string]map[chan struct{}]struct{}),
+	}
+}
+
+// Listen wakes the callers waiting for a key whenever its lease is released, until ctx is done
+func (l *Leases) Listen(ctx context.Context) error {
+	pubsub := l.client.Subscribe(ctx, l.channel)
+	defer pubsub.Close()
+
+	if _, err := pubsub.Receive(ctx); err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %v", l.channel, err)
+	}
+
+	messages := pubsub.Channel()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case msg, ok := <-messages:
+			if !ok {
+				return nil
+			}
+			l.notify(msg.Payload)
+		}
+	}
+}
+
+// Do makes sure a result for key is ready, either by running fetch under the lease on key or
+// by waiting for the replica holding it. ready reports whether the result left by another
+// replica can be read. A replica that waited MaxWait, or was told the lease was released
+// without the result becoming ready, runs fetch itself. So does every replica when Redis
+// cannot be reached.
+func (l *Leases) Do(ctx context.Context, key string, fetch func(ctx context.Context) error, ready func(ctx context.Context) bool) error {
+	// Register before trying the lease, so a release right after is not missed
+	released := l.subscribe(key)
+	defer l.unsubscribe(key, released)
+
+	token := newToken()
+	deadline := time.NewTimer(l.maxWait)
+	defer deadline.Stop()
+	poll := time.NewTicker(l.poll)
+	defer poll.Stop()
+
+	for waited := false; ; {
+		acquired, err := l.client.SetNX(ctx, leaseKeyPrefix+key, token, l.ttl).Result()
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			leasedCalls.WithLabelValues("unavailable").Inc()
+			return fetch(ctx)
+		}
+		if acquired {
+			if waited {
+				leasedCalls.WithLabelValues("takeover").Inc()
+			} else {
+				leasedCalls.WithLabelValues("leader").Inc()
+			}
+			return l.lead(ctx, key, token, fetch)
+		}
+		waited = true
+
+		// Wait for the lease to be released or to expire with its holder
+	wait:
+		for {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-deadline.C:
+				leasedCalls.WithLabelValues("timeout").Inc()
+				return fetch(ctx)
+			case <-released:
+				if ready(ctx) {
+					leasedCalls.WithLabelValues("follower").Inc()
+					return nil
+				}
+				// The holder failed or its result cannot be shared
+				leasedCalls.WithLabelValues("fallback").Inc()
+				return fetch(ctx)
+			case <-poll.C:
+				if ready(ctx) {
+					leasedCalls.WithLabelValues("follower").Inc()
+					return nil
+				}
+				held, err := l.client.Exists(ctx, leaseKeyPrefix+key).Result()
+				if err == nil && held == 0 {
+					break wait
+				}
+			}
+		}
+	}
+}
+
+// lead runs fetch while holding the lease on key, renewing it until fetch returns, then
+// releases it and tells the waiting replicas
+func (l *Leases) lead(ctx context.Context, key, token string, fetch func(ctx context.Context) error) error {
+	leaseKey := leaseKeyPrefix + key
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(l.ttl / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				renewScript.Run(context.Background(), l.client, []string{leaseKey}, token, l.ttl.Milliseconds())
+			}
+		}
+	}()
+
+	err := fetch(ctx)
+	close(done)
+	wg.Wait()
+
+	// Release even when ctx is done, so waiting replicas need not wait for the lease to expire
+	release, cancel := context.WithTimeout(context.WithoutCancel(ctx), l.ttl)
+	defer cancel()
+	if err := releaseScript.Run(release, l.client, []string{leaseKey}, token).Err(); err != nil {
+		log.Printf("Failed to release lease on %s: %v", key, err)
+	}
+	if err := l.client.Publish(release, l.channel, key).Err(); err != nil {
+		log.Printf("Failed to announce release of lease on %s: %v", key, err)
+	}
+	return err
+}
+
+// subscribe returns a channel receiving a value when the lease on key is released
+func (l *Leases) subscribe(key string) chan struct{} {
+	ch := make(chan struct{}, 1)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.waiting[key] == nil {
+		l.waiting[key] = make(map[chan struct{}]struct{})
+	}
+	l.waiting[key][ch] = struct{}{}
+	return ch
+}
+
+func (l *Leases) unsubscribe(key string, ch chan struct{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.waiting[key], ch)
+	if len(l.waiting[key]) == 0 {
+		delete(l.waiting, key)
+	}
+}
+
+// notify wakes the callers waiting for key
+func (l *Leases) notify(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for ch := range l.waiting[key] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// newToken returns a random value identifying the holder of a lease
+func newToken() string {
+	token := make([]byte, 16)
+	rand.Read(token)
+	return hex.EncodeToString(token)
+}
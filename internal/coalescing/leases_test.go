@@ -0,0 +1,101 @@
+package coalescing
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestReplicas returns leases for n replicas sharing one Redis server
+func newTestReplicas(t *testing.T, m *miniredis.Miniredis, n int) []*Leases {
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+
+	replicas := make([]*Leases, n)
+	for i := range replicas {
+		client := redis.NewClient(&redis.Options{Addr: m.Addr()})
+		t.Cleanup(func() { client.Close() })
+		replicas[i] = NewLeases(client, LeaseOptions{
+			TTL:          time.Second,
+			PollInterval: 10 * time.Millisecond,
+			MaxWait:      5 * time.Second,
+		})
+		go replicas[i].Listen(ctx)
+	}
+	// Let the subscriptions reach the server
+	require.Eventually(t, func() bool {
+		return len(m.PubSubChannels("")) == 1 && m.PubSubNumSub(DefaultLeaseChannel)[DefaultLeaseChannel] == n
+	}, time.Second, time.Millisecond)
+	return replicas
+}
+
+func TestLeasesFetchOnceAcrossReplicas(t *testing.T) {
+	m := miniredis.RunT(t)
+	replicas := newTestReplicas(t, m, 5)
+
+	var fetches int32
+	var stored atomic.Bool
+	release := make(chan struct{})
+	fetch := func(ctx context.Context) error {
+		atomic.AddInt32(&fetches, 1)
+		<-release
+		stored.Store(true)
+		return nil
+	}
+	ready := func(ctx context.Context) bool { return stored.Load() }
+
+	var wg sync.WaitGroup
+	for _, l := range replicas {
+		wg.Add(1)
+		go func(l *Leases) {
+			defer wg.Done()
+			assert.NoError(t, l.Do(context.Background(), "domain:example.com", fetch, ready))
+		}(l)
+	}
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&fetches) == 1 }, time.Second, time.Millisecond)
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+	assert.False(t, m.Exists(leaseKeyPrefix+"domain:example.com"), "lease not released")
+}
+
+func TestLeasesTakeOverFromDeadHolder(t *testing.T) {
+	m := miniredis.RunT(t)
+	l := newTestReplicas(t, m, 1)[0]
+
+	// A replica that died while holding the lease
+	key := "domain:example.com"
+	require.NoError(t, m.Set(leaseKeyPrefix+key, "dead"))
+	m.SetTTL(leaseKeyPrefix+key, time.Second)
+
+	fetched := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- l.Do(context.Background(), key, func(ctx context.Context) error {
+			close(fetched)
+			return nil
+		}, func(ctx context.Context) bool { return false })
+	}()
+
+	select {
+	case <-fetched:
+		t.Fatal("fetched while the lease was held")
+	case <-time.After(50 * time.Millisecond):
+	}
+	m.FastForward(time.Second)
+	select {
+	case <-fetched:
+	case <-time.After(time.Second):
+		t.Fatal("lease not taken over after it expired")
+	}
+	require.NoError(t, <-done)
+}
@@ -0,0 +1,41 @@
+package coalescing
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	coalescedRequests = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rdap_coalesced_requests_total",
+			Help: "Calls made through a coalescing group, by group and role (leader when the call ran, follower when it joined a call in flight)",
+		},
+		[]string{"group", "role"},
+	)
+
+	coalescedFanIn = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "rdap_coalesced_fan_in",
+			Help:    "Callers that shared the result of each coalesced call",
+			Buckets: []float64{1, 2, 4, 8, 16, 32, 64, 128, 256},
+		},
+		[]string{"group"},
+	)
+
+	coalescedCancellations = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rdap_coalesced_cancellations_total",
+			Help: "Coalesced calls cancelled because every caller waiting for them gave up",
+		},
+		[]string{"group"},
+	)
+
+	leasedCalls = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rdap_coalesced_leases_total",
+			Help: "Calls coalesced across replicas by outcome (leader, follower, takeover, fallback, timeout, unavailable)",
+		},
+		[]string{"result"},
+	)
+)
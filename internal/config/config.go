@@ -5,20 +5,36 @@
 package config
 
 import (
+	"fmt"
+	"net"
+	"os"
 	"time"
+
+	"github.com/spf13/viper"
 )
 
 // Config holds the service configuration
 type Config struct {
-	Server    ServerConfig    `mapstructure:"server"`
-	Redis     RedisConfig     `mapstructure:"redis"`
-	Kafka     KafkaConfig     `mapstructure:"kafka"`
-	Metrics   MetricsConfig   `mapstructure:"metrics"`
-	Logging   LoggingConfig   `mapstructure:"logging"`
-	Security  SecurityConfig  `mapstructure:"security"`
-	RDAP      RDAPConfig      `mapstructure:"rdap"`
-	RateLimit RateLimitConfig `mapstructure:"rateLimit"`
-	Error     ErrorConfig     `mapstructure:"error"`
+	Server     ServerConfig              `mapstructure:"server"`
+	Redis      RedisConfig               `mapstructure:"redis"`
+	Kafka      KafkaConfig               `mapstructure:"kafka"`
+	Metrics    MetricsConfig             `mapstructure:"metrics"`
+	Logging    LoggingConfig             `mapstructure:"logging"`
+	Security   SecurityConfig            `mapstructure:"security"`
+	RDAP       RDAPConfig                `mapstructure:"rdap"`
+	RateLimit  RateLimitConfig           `mapstructure:"rateLimit"`
+	Error      ErrorConfig               `mapstructure:"error"`
+	Redaction  RedactionConfig           `mapstructure:"redaction"`
+	Metadata   MetadataConfig            `mapstructure:"metadata"`
+	Transport  TransportConfig           `mapstructure:"transport"`
+	Proxy      ProxyConfig               `mapstructure:"proxy"`
+	Hedging    HedgingConfig             `mapstructure:"hedging"`
+	Coalescing CoalescingConfig          `mapstructure:"coalescing"`
+	Timeouts   AdaptiveTimeoutConfig     `mapstructure:"adaptive_timeout"`
+	Admin      AdminConfig               `mapstructure:"admin"`
+	Cache      CacheConfig               `mapstructure:"cache"`
+	Warmup     WarmupConfig              `mapstructure:"warmup"`
+	Upstreams  map[string]UpstreamConfig `mapstructure:"upstreams"`
 }
 
 // ServerConfig holds HTTP server configuration
@@ -32,12 +48,37 @@ type ServerConfig struct {
 	RateLimitPerMinute int           `mapstructure:"rate_limit_per_minute" default:"100"`
 }
 
-// RedisConfig holds Redis configuration
+// RedisConfig holds Redis configuration. Mode selects the topology: "standalone" connects to
+// URL, a host:port or redis:// or rediss:// URL; "sentinel" asks the Sentinels in Addrs for the
+// master named MasterName; "cluster" discovers the cluster from the seed nodes in Addrs.
 type RedisConfig struct {
-	URL      string        `mapstructure:"url" default:"redis:6379"`
-	Password string        `mapstructure:"password"`
-	DB       int           `mapstructure:"db" default:"0"`
-	TTL      time.Duration `mapstructure:"ttl" default:"3600s"`
+	Mode             string         `mapstructure:"mode" default:"standalone"`
+	URL              string         `mapstructure:"url" default:"redis:6379"`
+	Addrs            []string       `mapstructure:"addrs"`
+	MasterName       string         `mapstructure:"master_name"`
+	Username         string         `mapstructure:"username"`
+	Password         string         `mapstructure:"password"`
+	SentinelUsername string         `mapstructure:"sentinel_username"`
+	SentinelPassword string         `mapstructure:"sentinel_password"`
+	DB               int            `mapstructure:"db" default:"0"`
+	TTL              time.Duration  `mapstructure:"ttl" default:"3600s"`
+	PoolSize         int            `mapstructure:"pool_size" default:"50"`
+	MinIdleConns     int            `mapstructure:"min_idle_conns" default:"10"`
+	DialTimeout      time.Duration  `mapstructure:"dial_timeout" default:"5s"`
+	ReadTimeout      time.Duration  `mapstructure:"read_timeout" default:"3s"`
+	WriteTimeout     time.Duration  `mapstructure:"write_timeout" default:"3s"`
+	TLS              RedisTLSConfig `mapstructure:"tls"`
+}
+
+// RedisTLSConfig enables TLS to Redis and, for Sentinel, to the Sentinels. CAFile replaces the
+// system roots; CertFile and KeyFile hold a client certificate.
+type RedisTLSConfig struct {
+	Enabled            bool   `mapstructure:"enabled" default:"false"`
+	CAFile             string `mapstructure:"ca_file"`
+	CertFile           string `mapstructure:"cert_file"`
+	KeyFile            string `mapstructure:"key_file"`
+	ServerName         string `mapstructure:"server_name"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify" default:"false"`
 }
 
 // KafkaConfig holds Kafka configuration
@@ -88,6 +129,237 @@ type ErrorConfig struct {
 	DetailedErrors bool          `mapstructure:"detailed_errors" default:"false"`
 }
 
+// RedactionConfig holds the service's own RFC 9537 redaction policy. Replacement is the text
+// that stands in for redacted values with the replacementValue method.
+type RedactionConfig struct {
+	Enabled     bool     `mapstructure:"enabled" default:"false"`
+	Roles       []string `mapstructure:"roles" default:"[registrant,administrative,technical,billing]"`
+	Fields      []string `mapstructure:"fields" default:"[email,tel,adr]"`
+	Method      string   `mapstructure:"method" default:"removal"`
+	Reason      string   `mapstructure:"reason" default:"Server policy"`
+	Replacement string   `mapstructure:"replacement" default:""`
+}
+
+// MetadataConfig controls the proxy metadata attached to lookup responses
+type MetadataConfig struct {
+	ResponseHeaders bool `mapstructure:"response_headers" default:"true"`
+	Notice          bool `mapstructure:"notice" default:"false"`
+}
+
+// TransportConfig holds the outbound HTTP transport settings used for upstream RDAP servers
+type TransportConfig struct {
+	MaxIdleConns         int           `mapstructure:"max_idle_conns" default:"512"`
+	MaxIdleConnsPerHost  int           `mapstructure:"max_idle_conns_per_host" default:"32"`
+	MaxConnsPerHost      int           `mapstructure:"max_conns_per_host" default:"64"`
+	IdleConnTimeout      time.Duration `mapstructure:"idle_conn_timeout" default:"90s"`
+	DialTimeout          time.Duration `mapstructure:"dial_timeout" default:"5s"`
+	KeepAlive            time.Duration `mapstructure:"keep_alive" default:"30s"`
+	TLSHandshakeTimeout  time.Duration `mapstructure:"tls_handshake_timeout" default:"5s"`
+	TLSSessionCacheSize  int           `mapstructure:"tls_session_cache_size" default:"64"`
+	EnableHTTP2          bool          `mapstructure:"enable_http2" default:"true"`
+	PrewarmTopRegistries int           `mapstructure:"prewarm_top_registries" default:"10"`
+	PrewarmTimeout       time.Duration `mapstructure:"prewarm_timeout" default:"10s"`
+	CAFiles              []string      `mapstructure:"ca_files"`
+	MinTLSVersion        string        `mapstructure:"min_tls_version" default:"1.2"`
+}
+
+// HedgingConfig controls hedged upstream requests. When the first server has not answered
+// within Percentile of its recent latency (clamped to MinDelay and MaxDelay), the query is
+// also sent to the next server or mirror. Budget caps hedges as a fraction of all lookups.
+type HedgingConfig struct {
+	Enabled    bool          `mapstructure:"enabled" default:"false"`
+	Percentile float64       `mapstructure:"percentile" default:"95"`
+	MinDelay   time.Duration `mapstructure:"min_delay" default:"50ms"`
+	MaxDelay   time.Duration `mapstructure:"max_delay" default:"2s"`
+	MinSamples int           `mapstructure:"min_samples" default:"20"`
+	Budget     float64       `mapstructure:"budget" default:"0.1"`
+}
+
+// CoalescingConfig shares upstream requests between replicas when Distributed is set. The
+// first replica to miss a key holds a Redis lease on it for LeaseTTL, renewed while it
+// fetches; the others wait up to MaxWait for the answer to reach the cache, woken through the
+// pub/sub Channel and polling every PollInterval, and fetch it themselves if it does not.
+// The answer is shared through the redis cache tier, which Validate requires.
+type CoalescingConfig struct {
+	Distributed  bool          `mapstructure:"distributed" default:"false"`
+	LeaseTTL     time.Duration `mapstructure:"lease_ttl" default:"5s"`
+	PollInterval time.Duration `mapstructure:"poll_interval" default:"100ms"`
+	MaxWait      time.Duration `mapstructure:"max_wait" default:"10s"`
+	Channel      string        `mapstructure:"channel" default:"rdap:coalesce:done"`
+}
+
+// AdaptiveTimeoutConfig derives per-upstream timeouts from observed latency: the timeout is
+// Percentile of a host's recent response times multiplied by Factor, clamped to MinTimeout
+// and MaxTimeout. RDAP.Timeout applies until MinSamples responses have been seen.
+type AdaptiveTimeoutConfig struct {
+	Enabled    bool          `mapstructure:"enabled" default:"false"`
+	Percentile float64       `mapstructure:"percentile" default:"99"`
+	Factor     float64       `mapstructure:"factor" default:"2"`
+	MinTimeout time.Duration `mapstructure:"min_timeout" default:"1s"`
+	MaxTimeout time.Duration `mapstructure:"max_timeout" default:"30s"`
+	MinSamples int           `mapstructure:"min_samples" default:"50"`
+}
+
+// AdminConfig controls the /admin endpoints, which require Token as a bearer token. Enabling
+// them without a token is a configuration error.
+type AdminConfig struct {
+	Enabled bool   `mapstructure:"enabled" default:"false"`
+	Token   string `mapstructure:"token"`
+}
+
+// CacheConfig holds the freshness limits for cached lookups per RDAP object type.
+// StaleWhileRevalidate is how long past its freshness an entry is served while it is
+// refreshed in the background; StaleIfError is how long it is served when the upstream fails.
+// NegativeTTL is how long not-found and invalid answers are cached; zero disables that.
+// IPRanges answers IP lookups from any cached network containing the address.
+// Invalidation configures how invalidations reach the local tier of other replicas.
+// Compression selects the codec compressing the values of each cache tier.
+// Snapshot persists the local tier across restarts.
+type CacheConfig struct {
+	// Tiers lists the cache tiers in the order they are read: "local" (in memory), "disk"
+	// and "redis". Every tier is written.
+	Tiers                []string           `mapstructure:"tiers"`
+	Disk                 DiskCacheConfig    `mapstructure:"disk"`
+	Domain               CacheTTLConfig     `mapstructure:"domain"`
+	IP                   CacheTTLConfig     `mapstructure:"ip"`
+	Autnum               CacheTTLConfig     `mapstructure:"autnum"`
+	StaleWhileRevalidate time.Duration      `mapstructure:"stale_while_revalidate" default:"5m"`
+	StaleIfError         time.Duration      `mapstructure:"stale_if_error" default:"24h"`
+	NegativeTTL          time.Duration      `mapstructure:"negative_ttl" default:"5m"`
+	IPRanges             bool               `mapstructure:"ip_ranges" default:"true"`
+	Invalidation         InvalidationConfig `mapstructure:"invalidation"`
+	Compression          CompressionConfig  `mapstructure:"compression"`
+	Snapshot             SnapshotConfig     `mapstructure:"snapshot"`
+}
+
+// DiskCacheConfig configures the disk tier, a database file at Path that keeps entries for at
+// most TTL, or until they can no longer be served when TTL is zero
+type DiskCacheConfig struct {
+	Path string        `mapstructure:"path" default:"data/cache.db"`
+	TTL  time.Duration `mapstructure:"ttl" default:"24h"`
+}
+
+// SnapshotConfig saves the local cache tier to Dir every Interval and on shutdown, and loads
+// it at startup. Snapshots are disabled when Dir is empty.
+type SnapshotConfig struct {
+	Dir      string        `mapstructure:"dir"`
+	Interval time.Duration `mapstructure:"interval" default:"5m"`
+}
+
+// CompressionConfig names the codec of each cache tier: "zstd", "snappy" or "none".
+// Dictionary is the path of an optional zstd dictionary trained on RDAP responses.
+type CompressionConfig struct {
+	Local      string `mapstructure:"local" default:"zstd"`
+	Disk       string `mapstructure:"disk" default:"zstd"`
+	Redis      string `mapstructure:"redis" default:"zstd"`
+	Dictionary string `mapstructure:"dictionary"`
+}
+
+// WarmupConfig controls refreshing the most requested keys at startup and every Interval.
+// Keys come from the "redis" hot-key set, a "file" with one key per line, or the "kafka"
+// query topic. Readiness is held until ReadyFraction of the first run has completed or
+// ReadyTimeout has passed.
+type WarmupConfig struct {
+	Enabled       bool          `mapstructure:"enabled" default:"false"`
+	Sources       []string      `mapstructure:"sources"`
+	File          string        `mapstructure:"file"`
+	TopN          int           `mapstructure:"top_n" default:"1000"`
+	Interval      time.Duration `mapstructure:"interval" default:"1h"`
+	Concurrency   int           `mapstructure:"concurrency" default:"16"`
+	PerUpstream   int           `mapstructure:"per_upstream" default:"2"`
+	UpstreamDelay time.Duration `mapstructure:"upstream_delay" default:"100ms"`
+	ReadyFraction float64       `mapstructure:"ready_fraction" default:"0.8"`
+	ReadyTimeout  time.Duration `mapstructure:"ready_timeout" default:"2m"`
+	KafkaLookback int64         `mapstructure:"kafka_lookback" default:"100000"`
+}
+
+// InvalidationConfig names the Redis pub/sub channel and, when Kafka is enabled, the Kafka
+// topic that carry cache invalidations between replicas
+type InvalidationConfig struct {
+	Channel    string `mapstructure:"channel" default:"rdap:cache:invalidate"`
+	KafkaTopic string `mapstructure:"kafka_topic" default:"rdap-cache-invalidations"`
+}
+
+// CacheTTLConfig bounds the TTL derived from upstream Cache-Control and Expires headers.
+// DefaultTTL applies when the upstream sends neither.
+type CacheTTLConfig struct {
+	MinTTL     time.Duration `mapstructure:"min_ttl"`
+	MaxTTL     time.Duration `mapstructure:"max_ttl"`
+	DefaultTTL time.Duration `mapstructure:"default_ttl"`
+}
+
+// TTLFor returns the TTL limits for an object type ("domain", "ip" or "autnum")
+func (c CacheConfig) TTLFor(objectType string) CacheTTLConfig {
+	switch objectType {
+	case "ip":
+		return c.IP
+	case "autnum":
+		return c.Autnum
+	default:
+		return c.Domain
+	}
+}
+
+// UpstreamConfig holds settings for a single upstream RDAP host, keyed by host name in Config.Upstreams
+type UpstreamConfig struct {
+	MaxIdleConnsPerHost int                `mapstructure:"max_idle_conns_per_host"`
+	MaxConnsPerHost     int                `mapstructure:"max_conns_per_host"`
+	DisableHTTP2        bool               `mapstructure:"disable_http2"`
+	TLS                 UpstreamTLSConfig  `mapstructure:"tls"`
+	Auth                UpstreamAuthConfig `mapstructure:"auth"`
+	Proxy               ProxyConfig        `mapstructure:"proxy"`
+	Mirrors             []string           `mapstructure:"mirrors"`
+}
+
+// ProxyConfig holds the HTTP CONNECT or SOCKS5 proxy used for outbound registry traffic.
+// URL takes the http, https, socks5 or socks5h scheme; on an upstream, "direct" bypasses
+// the global proxy. NoProxy lists host names, domain suffixes, IPs and CIDR blocks reached directly.
+type ProxyConfig struct {
+	URL      string   `mapstructure:"url"`
+	Username string   `mapstructure:"username"`
+	Password string   `mapstructure:"password"`
+	NoProxy  []string `mapstructure:"no_proxy"`
+}
+
+// UpstreamTLSConfig holds TLS settings for connections to an upstream RDAP host
+type UpstreamTLSConfig struct {
+	CAFiles    []string `mapstructure:"ca_files"`
+	CertFile   string   `mapstructure:"cert_file"`
+	KeyFile    string   `mapstructure:"key_file"`
+	MinVersion string   `mapstructure:"min_version"`
+	ServerName string   `mapstructure:"server_name"`
+}
+
+// UpstreamAuthConfig holds the credentials attached to requests for an upstream RDAP host.
+// Type is one of "header", "bearer" or "oidc".
+type UpstreamAuthConfig struct {
+	Type    string            `mapstructure:"type"`
+	Headers map[string]string `mapstructure:"headers"`
+	Token   string            `mapstructure:"token"`
+	OIDC    OIDCConfig        `mapstructure:"oidc"`
+}
+
+// OIDCConfig holds the OpenID Connect client credentials used to obtain RFC 9560 access tokens
+type OIDCConfig struct {
+	TokenURL     string   `mapstructure:"token_url"`
+	ClientID     string   `mapstructure:"client_id"`
+	ClientSecret string   `mapstructure:"client_secret"`
+	Scopes       []string `mapstructure:"scopes"`
+	Audience     string   `mapstructure:"audience"`
+}
+
+// Upstream returns the settings for an upstream host, matching "host:port" before the bare host name
+func (cfg *Config) Upstream(host string) (UpstreamConfig, bool) {
+	if up, ok := cfg.Upstreams[host]; ok {
+		return up, true
+	}
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		up, ok := cfg.Upstreams[name]
+		return up, ok
+	}
+	return UpstreamConfig{}, false
+}
+
 func (ec *ErrorConfig) IsRetryableCode(code int) bool {
 	for _, c := range ec.RetryableCodes {
 		if c == code {
@@ -97,8 +369,50 @@ func (ec *ErrorConfig) IsRetryableCode(code int) bool {
 	return false
 }
 
+// configPaths lists the locations searched for a config file when RDAP_CONFIG is not set
+var configPaths = []string{
+	"./config.yaml",
+	"./config/config.yaml",
+	"/etc/rdap/config.yaml",
+}
+
 // LoadConfig loads the configuration from environment variables and config file
 func LoadConfig() (*Config, error) {
+	cfg := defaultConfig()
+
+	path := configFilePath()
+	if path == "" {
+		return cfg, nil
+	}
+
+	// Upstream keys are host names, so "." cannot be the key delimiter
+	v := viper.NewWithOptions(viper.KeyDelimiter("::"))
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	if err := v.Unmarshal(cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// configFilePath returns the config file named by RDAP_CONFIG or the first default location that exists
+func configFilePath() string {
+	if path := os.Getenv("RDAP_CONFIG"); path != "" {
+		return path
+	}
+	for _, path := range configPaths {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// defaultConfig returns the built-in default configuration
+func defaultConfig() *Config {
 	return &Config{
 		Server: ServerConfig{
 			Port:               "8080",
@@ -110,10 +424,16 @@ func LoadConfig() (*Config, error) {
 			RateLimitPerMinute: 100,
 		},
 		Redis: RedisConfig{
-			URL:      "redis:6379",
-			Password: "",
-			DB:       0,
-			TTL:      3600 * time.Second,
+			Mode:         "standalone",
+			URL:          "redis:6379",
+			Password:     "",
+			DB:           0,
+			TTL:          3600 * time.Second,
+			PoolSize:     50,
+			MinIdleConns: 10,
+			DialTimeout:  5 * time.Second,
+			ReadTimeout:  3 * time.Second,
+			WriteTimeout: 3 * time.Second,
 		},
 		Kafka: KafkaConfig{
 			Enabled: false,
@@ -149,5 +469,96 @@ func LoadConfig() (*Config, error) {
 			MaxErrorAge:    24 * time.Hour,
 			DetailedErrors: false,
 		},
-	}, nil
+		Redaction: RedactionConfig{
+			Enabled: false,
+			Roles:   []string{"registrant", "administrative", "technical", "billing"},
+			Fields:  []string{"email", "tel", "adr"},
+			Method:  "removal",
+			Reason:  "Server policy",
+		},
+		Metadata: MetadataConfig{
+			ResponseHeaders: true,
+			Notice:          false,
+		},
+		Transport: TransportConfig{
+			MaxIdleConns:         512,
+			MaxIdleConnsPerHost:  32,
+			MaxConnsPerHost:      64,
+			IdleConnTimeout:      90 * time.Second,
+			DialTimeout:          5 * time.Second,
+			KeepAlive:            30 * time.Second,
+			TLSHandshakeTimeout:  5 * time.Second,
+			TLSSessionCacheSize:  64,
+			EnableHTTP2:          true,
+			PrewarmTopRegistries: 10,
+			PrewarmTimeout:       10 * time.Second,
+			MinTLSVersion:        "1.2",
+		},
+		Hedging: HedgingConfig{
+			Enabled:    false,
+			Percentile: 95,
+			MinDelay:   50 * time.Millisecond,
+			MaxDelay:   2 * time.Second,
+			MinSamples: 20,
+			Budget:     0.1,
+		},
+		Coalescing: CoalescingConfig{
+			Distributed:  false,
+			LeaseTTL:     5 * time.Second,
+			PollInterval: 100 * time.Millisecond,
+			MaxWait:      10 * time.Second,
+			Channel:      "rdap:coalesce:done",
+		},
+		Timeouts: AdaptiveTimeoutConfig{
+			Enabled:    false,
+			Percentile: 99,
+			Factor:     2,
+			MinTimeout: time.Second,
+			MaxTimeout: 30 * time.Second,
+			MinSamples: 50,
+		},
+		Admin: AdminConfig{
+			Enabled: false,
+		},
+		Cache: CacheConfig{
+			Tiers: []string{"local", "redis"},
+			Disk: DiskCacheConfig{
+				Path: "data/cache.db",
+				TTL:  24 * time.Hour,
+			},
+			Domain: CacheTTLConfig{MinTTL: 5 * time.Minute, MaxTTL: 24 * time.Hour, DefaultTTL: time.Hour},
+			IP:     CacheTTLConfig{MinTTL: time.Hour, MaxTTL: 7 * 24 * time.Hour, DefaultTTL: 6 * time.Hour},
+			Autnum: CacheTTLConfig{MinTTL: time.Hour, MaxTTL: 7 * 24 * time.Hour, DefaultTTL: 12 * time.Hour},
+
+			StaleWhileRevalidate: 5 * time.Minute,
+			StaleIfError:         24 * time.Hour,
+			NegativeTTL:          5 * time.Minute,
+			IPRanges:             true,
+			Invalidation: InvalidationConfig{
+				Channel:    "rdap:cache:invalidate",
+				KafkaTopic: "rdap-cache-invalidations",
+			},
+			Compression: CompressionConfig{
+				Local: "zstd",
+				Disk:  "zstd",
+				Redis: "zstd",
+			},
+			Snapshot: SnapshotConfig{
+				Interval: 5 * time.Minute,
+			},
+		},
+		Warmup: WarmupConfig{
+			Enabled:       false,
+			Sources:       []string{"redis"},
+			TopN:          1000,
+			Interval:      time.Hour,
+			Concurrency:   16,
+			PerUpstream:   2,
+			UpstreamDelay: 100 * time.Millisecond,
+			ReadyFraction: 0.8,
+			ReadyTimeout:  2 * time.Minute,
+			KafkaLookback: 100000,
+		},
+		Upstreams: map[string]UpstreamConfig{},
+	}
 }
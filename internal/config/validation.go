@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"strconv"
+	"time"
 )
 
 func (cfg *Config) Validate() error {
@@ -16,5 +17,123 @@ func (cfg *Config) Validate() error {
 	if cfg.RateLimit.RequestsPerSecond <= 0 {
 		return fmt.Errorf("rate limit must be greater than zero")
 	}
+	if cfg.Redaction.Enabled {
+		switch cfg.Redaction.Method {
+		case "removal", "emptyValue":
+		case "replacementValue":
+			if cfg.Redaction.Replacement == "" {
+				return fmt.Errorf("the replacementValue redaction method needs a replacement")
+			}
+		default:
+			return fmt.Errorf("unsupported redaction method: %s", cfg.Redaction.Method)
+		}
+	}
+	if cfg.Hedging.Enabled {
+		if cfg.Hedging.Percentile <= 0 || cfg.Hedging.Percentile > 100 {
+			return fmt.Errorf("hedging percentile must be in (0, 100]")
+		}
+		if cfg.Hedging.Budget < 0 || cfg.Hedging.Budget > 1 {
+			return fmt.Errorf("hedging budget must be between 0 and 1")
+		}
+		if cfg.Hedging.MaxDelay < cfg.Hedging.MinDelay {
+			return fmt.Errorf("hedging max_delay must not be less than min_delay")
+		}
+	}
+	if cfg.Coalescing.Distributed {
+		if cfg.Coalescing.LeaseTTL < time.Millisecond || cfg.Coalescing.PollInterval <= 0 || cfg.Coalescing.MaxWait <= 0 {
+			return fmt.Errorf("coalescing lease_ttl, poll_interval and max_wait must be positive")
+		}
+	}
+	if cfg.Admin.Enabled && cfg.Admin.Token == "" {
+		return fmt.Errorf("admin endpoints require admin.token when enabled")
+	}
+	if cfg.Timeouts.Enabled {
+		if cfg.Timeouts.Percentile <= 0 || cfg.Timeouts.Percentile > 100 {
+			return fmt.Errorf("adaptive timeout percentile must be in (0, 100]")
+		}
+		if cfg.Timeouts.Factor < 1 {
+			return fmt.Errorf("adaptive timeout factor must be at least 1")
+		}
+		if cfg.Timeouts.MinTimeout <= 0 || cfg.Timeouts.MaxTimeout < cfg.Timeouts.MinTimeout {
+			return fmt.Errorf("adaptive timeout bounds must satisfy 0 < min_timeout <= max_timeout")
+		}
+	}
+	for _, objectType := range []string{"domain", "ip", "autnum"} {
+		ttl := cfg.Cache.TTLFor(objectType)
+		if ttl.MinTTL < 0 || (ttl.MaxTTL > 0 && ttl.MaxTTL < ttl.MinTTL) {
+			return fmt.Errorf("invalid cache TTL bounds for %s", objectType)
+		}
+	}
+	switch cfg.Redis.Mode {
+	case "", "standalone":
+	case "sentinel":
+		if cfg.Redis.MasterName == "" || len(cfg.Redis.Addrs) == 0 {
+			return fmt.Errorf("redis sentinel mode requires master_name and addrs")
+		}
+	case "cluster":
+		if len(cfg.Redis.Addrs) == 0 {
+			return fmt.Errorf("redis cluster mode requires addrs")
+		}
+		if cfg.Redis.DB != 0 {
+			return fmt.Errorf("redis cluster mode supports only db 0")
+		}
+	default:
+		return fmt.Errorf("unsupported redis mode: %s", cfg.Redis.Mode)
+	}
+	if len(cfg.Cache.Tiers) == 0 {
+		return fmt.Errorf("at least one cache tier is required")
+	}
+	tiers := make(map[string]bool)
+	for _, tier := range cfg.Cache.Tiers {
+		switch tier {
+		case "local", "redis":
+		case "disk":
+			if cfg.Cache.Disk.Path == "" {
+				return fmt.Errorf("the disk cache tier requires cache.disk.path")
+			}
+			if cfg.Cache.Disk.TTL < 0 {
+				return fmt.Errorf("disk cache TTL must not be negative")
+			}
+		default:
+			return fmt.Errorf("unsupported cache tier: %s", tier)
+		}
+		if tiers[tier] {
+			return fmt.Errorf("cache tier %s is listed twice", tier)
+		}
+		tiers[tier] = true
+	}
+	// Replicas waiting on a lease read the answer from the shared tier
+	if cfg.Coalescing.Distributed && !tiers["redis"] {
+		return fmt.Errorf("distributed coalescing requires the redis cache tier")
+	}
+	for _, codec := range []string{cfg.Cache.Compression.Local, cfg.Cache.Compression.Disk, cfg.Cache.Compression.Redis} {
+		switch codec {
+		case "", "none", "zstd", "snappy":
+		default:
+			return fmt.Errorf("unsupported cache compression codec: %s", codec)
+		}
+	}
+	if cfg.Cache.Snapshot.Dir != "" && cfg.Cache.Snapshot.Interval < 0 {
+		return fmt.Errorf("cache snapshot interval must not be negative")
+	}
+	if cfg.Warmup.Enabled {
+		for _, source := range cfg.Warmup.Sources {
+			switch source {
+			case "redis", "kafka":
+			case "file":
+				if cfg.Warmup.File == "" {
+					return fmt.Errorf("warmup file source requires warmup.file")
+				}
+			default:
+				return fmt.Errorf("unsupported warmup source: %s", source)
+			}
+		}
+		if cfg.Warmup.TopN <= 0 || cfg.Warmup.Concurrency <= 0 || cfg.Warmup.PerUpstream <= 0 {
+			return fmt.Errorf("warmup top_n, concurrency and per_upstream must be greater than zero")
+		}
+		if cfg.Warmup.ReadyFraction < 0 || cfg.Warmup.ReadyFraction > 1 {
+			return fmt.Errorf("warmup ready_fraction must be between 0 and 1")
+		}
+	}
 	return nil
 }
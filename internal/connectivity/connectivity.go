@@ -8,7 +8,8 @@ import (
 	"time"
 
 	"github.com/Shopify/sarama"
-	"github.com/go-redis/redis/v8"
+	"github.com/ohelal/rdap/internal/config"
+	"github.com/ohelal/rdap/internal/redisclient"
 )
 
 func RunTests() error {
@@ -38,9 +39,11 @@ func testRedis() error {
 	}
 
 	// Create Redis client
-	rdb := redis.NewClient(&redis.Options{
-		Addr: redisURL,
-	})
+	rdb, err := redisclient.New(config.RedisConfig{URL: redisURL})
+	if err != nil {
+		return err
+	}
+	defer rdb.Close()
 
 	// Test connection with ping
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -1,10 +1,9 @@
 package handlers
 
 import (
-	"errors"
+	"context"
 	"github.com/gofiber/fiber/v2"
 	"github.com/ohelal/rdap/internal/cache"
-	"github.com/ohelal/rdap/internal/cdn"
 	"github.com/ohelal/rdap/internal/coalescing"
 	"time"
 )
@@ -12,21 +11,16 @@ import (
 // CoalescedHandler handles coalesced requests
 type CoalescedHandler struct {
 	cache     *cache.CacheManager
-	coalescer *coalescing.RequestCoalescer
-	cdnConfig *cdn.CDNConfig
+	coalescer *coalescing.Group[string, interface{}]
+	timeout   time.Duration
 }
 
 // NewCoalescedHandler creates a new coalesced handler
 func NewCoalescedHandler(cacheManager *cache.CacheManager, timeout time.Duration) (*CoalescedHandler, error) {
-	cdnConfig := cdn.NewCDNConfig("https://cdn.example.com", 30) // 30 seconds timeout
-	if cdnConfig == nil {
-		return nil, errors.New("failed to create cdn config")
-	}
-
 	return &CoalescedHandler{
 		cache:     cacheManager,
-		coalescer: coalescing.NewRequestCoalescer(timeout),
-		cdnConfig: cdnConfig,
+		coalescer: coalescing.NewGroup[string, interface{}]("handler"),
+		timeout:   timeout,
 	}, nil
 }
 
@@ -39,9 +33,12 @@ func (h *CoalescedHandler) HandleRequest(c *fiber.Ctx) error {
 		})
 	}
 
-	result, err := h.coalescer.Execute(c.Context(), coalescing.RequestKey(key), func() (interface{}, error) {
+	ctx, cancel := context.WithTimeout(c.UserContext(), h.timeout)
+	defer cancel()
+
+	result, _, err := h.coalescer.Do(ctx, key, func(ctx context.Context) (interface{}, error) {
 		// Check cache first
-		if cached, found := h.cache.Get(key); found {
+		if cached, found := h.cache.GetValue(key); found {
 			return cached, nil
 		}
 
@@ -51,7 +48,7 @@ func (h *CoalescedHandler) HandleRequest(c *fiber.Ctx) error {
 			return nil, err
 		}
 
-		h.cache.Set(key, result)
+		h.cache.SetValue(key, result)
 		return result, nil
 	})
 
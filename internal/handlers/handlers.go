@@ -71,39 +71,39 @@ func (h *Handlers) LookupHandler(c *fiber.Ctx) error {
 // IPLookupHandler handles IP lookup requests
 func (h *Handlers) IPLookupHandler(c *fiber.Ctx) error {
 	// Handle IP lookup requests
-	ip := c.Query("q")
+	ip := c.Params("ip")
 	err := h.svc.HandleIPLookup(c)
 	if err != nil {
 		return err
 	}
 
-	h.sendToKafka("ip", ip, false)
+	h.recordLookup(c, "ip", ip)
 	return nil
 }
 
 // DomainLookupHandler handles domain lookup requests
 func (h *Handlers) DomainLookupHandler(c *fiber.Ctx) error {
 	// Handle domain lookup requests
-	domain := c.Query("q")
+	domain := c.Params("domain")
 	err := h.svc.HandleDomainLookup(c)
 	if err != nil {
 		return err
 	}
 
-	h.sendToKafka("domain", domain, false)
+	h.recordLookup(c, "domain", domain)
 	return nil
 }
 
 // ASNLookupHandler handles ASN lookup requests
 func (h *Handlers) ASNLookupHandler(c *fiber.Ctx) error {
 	// Handle ASN lookup requests
-	asn := c.Query("q")
+	asn := c.Params("asn")
 	err := h.svc.HandleASNLookup(c)
 	if err != nil {
 		return err
 	}
 
-	h.sendToKafka("asn", asn, false)
+	h.recordLookup(c, "asn", asn)
 	return nil
 }
 
@@ -116,6 +116,21 @@ func (h *Handlers) HealthHandler(c *fiber.Ctx) error {
 	})
 }
 
+// recordLookup counts the cache outcome of a lookup and publishes it to Kafka
+func (h *Handlers) recordLookup(c *fiber.Ctx, queryType, query string) {
+	// Negative cache hits are counted separately by the service
+	status := service.CacheStatus(c)
+	switch status {
+	case service.CacheStatusHit, service.CacheStatusStale:
+		h.metrics.CacheHits.WithLabelValues(queryType).Inc()
+	case service.CacheStatusMiss:
+		h.metrics.CacheMisses.WithLabelValues(queryType).Inc()
+	}
+
+	fromCache := status != "" && status != service.CacheStatusMiss
+	h.sendToKafka(queryType, query, fromCache)
+}
+
 func (h *Handlers) sendToKafka(queryType, query string, cacheHit bool) {
 	// Send message to Kafka
 	msg := &kafka.Message{
@@ -38,7 +38,7 @@ func (h *OptimizedHandler) HandleRequest(c *fiber.Ctx) error {
 	defer h.pool.Put(buf)
 
 	// Check cache first
-	if cached, found := h.cache.Get(key); found {
+	if cached, found := h.cache.GetValue(key); found {
 		return c.JSON(cached)
 	}
 
@@ -51,7 +51,7 @@ func (h *OptimizedHandler) HandleRequest(c *fiber.Ctx) error {
 	}
 
 	// Cache result
-	h.cache.Set(key, result)
+	h.cache.SetValue(key, result)
 
 	return c.JSON(result)
 }
@@ -0,0 +1,122 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/ohelal/rdap/internal/cache"
+)
+
+// InvalidationBus carries cache invalidation events over a Kafka topic. Every replica reads all
+// partitions from the newest offset without a consumer group, so each one sees every event
+// published while it runs.
+type InvalidationBus struct {
+	producer sarama.SyncProducer
+	consumer sarama.Consumer
+	topic    string
+	cb       *CircuitBreaker
+}
+
+var _ cache.InvalidationBus = (*InvalidationBus)(nil)
+
+// NewInvalidationBus creates an invalidation bus on a Kafka topic
+func NewInvalidationBus(brokers []string, topic string) (*InvalidationBus, error) {
+	config := sarama.NewConfig()
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Producer.Retry.Max = 5
+	config.Producer.Return.Successes = true
+	config.Consumer.Return.Errors = true
+
+	producer, err := sarama.NewSyncProducer(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+	consumer, err := sarama.NewConsumer(brokers, config)
+	if err != nil {
+		producer.Close()
+		return nil, err
+	}
+
+	return &InvalidationBus{
+		producer: producer,
+		consumer: consumer,
+		topic:    topic,
+		cb:       NewCircuitBreaker(5, 1*time.Minute),
+	}, nil
+}
+
+// Publish sends an invalidation to every replica
+func (b *InvalidationBus) Publish(_ context.Context, inv cache.Invalidation) error {
+	if !b.cb.AllowRequest() {
+		return fmt.Errorf("circuit breaker is open")
+	}
+
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+
+	if _, _, err := b.producer.SendMessage(&sarama.ProducerMessage{
+		Topic: b.topic,
+		Value: sarama.ByteEncoder(data),
+	}); err != nil {
+		b.cb.OnFailure()
+		return err
+	}
+	b.cb.OnSuccess()
+	return nil
+}
+
+// Subscribe calls fn for every invalidation published on the topic until ctx is done
+func (b *InvalidationBus) Subscribe(ctx context.Context, fn func(cache.Invalidation)) error {
+	partitions, err := b.consumer.Partitions(b.topic)
+	if err != nil {
+		return fmt.Errorf("failed to list partitions of %s: %v", b.topic, err)
+	}
+
+	wg := &sync.WaitGroup{}
+	for _, partition := range partitions {
+		pc, err := b.consumer.ConsumePartition(b.topic, partition, sarama.OffsetNewest)
+		if err != nil {
+			return fmt.Errorf("failed to consume partition %d of %s: %v", partition, b.topic, err)
+		}
+
+		wg.Add(1)
+		go func(pc sarama.PartitionConsumer) {
+			defer wg.Done()
+			defer pc.Close()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case err := <-pc.Errors():
+					log.Printf("Error consuming cache invalidations: %v", err)
+				case msg := <-pc.Messages():
+					var inv cache.Invalidation
+					if err := json.Unmarshal(msg.Value, &inv); err != nil {
+						log.Printf("Ignoring malformed cache invalidation: %v", err)
+						continue
+					}
+					fn(inv)
+				}
+			}
+		}(pc)
+	}
+
+	wg.Wait()
+	return nil
+}
+
+// Close closes the producer and consumer
+func (b *InvalidationBus) Close() error {
+	if err := b.producer.Close(); err != nil {
+		b.consumer.Close()
+		return err
+	}
+	return b.consumer.Close()
+}
@@ -0,0 +1,105 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/Shopify/sarama"
+)
+
+// QueryCount is the number of times a query appeared in the query stream
+type QueryCount struct {
+	Type  string
+	Query string
+	Count int
+}
+
+// TopQueries reads up to lookback of the most recent messages in each partition of the query
+// topic and returns the n most frequent queries, most frequent first
+func TopQueries(ctx context.Context, brokers []string, topic string, lookback int64, n int) ([]QueryCount, error) {
+	config := sarama.NewConfig()
+	config.Consumer.Return.Errors = true
+
+	client, err := sarama.NewClient(brokers, config)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return nil, err
+	}
+	defer consumer.Close()
+
+	partitions, err := client.Partitions(topic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list partitions of %s: %v", topic, err)
+	}
+
+	counts := make(map[[2]string]int)
+	for _, partition := range partitions {
+		if err := countPartition(ctx, client, consumer, topic, partition, lookback, counts); err != nil {
+			return nil, err
+		}
+	}
+
+	top := make([]QueryCount, 0, len(counts))
+	for q, count := range counts {
+		top = append(top, QueryCount{Type: q[0], Query: q[1], Count: count})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Type+top[i].Query < top[j].Type+top[j].Query
+	})
+	if len(top) > n {
+		top = top[:n]
+	}
+	return top, nil
+}
+
+// countPartition counts the queries in the last lookback messages of one partition
+func countPartition(ctx context.Context, client sarama.Client, consumer sarama.Consumer, topic string, partition int32, lookback int64, counts map[[2]string]int) error {
+	newest, err := client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return err
+	}
+	oldest, err := client.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return err
+	}
+	start := newest - lookback
+	if start < oldest {
+		start = oldest
+	}
+	if start >= newest {
+		return nil
+	}
+
+	pc, err := consumer.ConsumePartition(topic, partition, start)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-pc.Errors():
+			return err
+		case msg := <-pc.Messages():
+			var m Message
+			if err := json.Unmarshal(msg.Value, &m); err == nil && m.Query != "" {
+				counts[[2]string{m.Type, m.Query}]++
+			}
+			if msg.Offset >= newest-1 {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ohelal/rdap/internal/config"
+)
+
+// AdminAuth guards the admin endpoints, which require the configured token as a bearer token.
+// They answer 404 while disabled or when no token is configured, so they are never left open.
+func AdminAuth(cfg config.AdminConfig) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !cfg.Enabled || cfg.Token == "" {
+			return c.SendStatus(fiber.StatusNotFound)
+		}
+		token := strings.TrimPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(token), []byte(cfg.Token)) != 1 {
+			c.Set(fiber.HeaderWWWAuthenticate, `Bearer realm="rdap-admin"`)
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Unauthorized",
+			})
+		}
+		return c.Next()
+	}
+}
@@ -1,26 +1,32 @@
 package middleware
 
 import (
-	"context"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/go-redis/redis/v8"
 	"github.com/gofiber/fiber/v2"
+	"github.com/ohelal/rdap/internal/ratelimit"
 )
 
 // RateLimiterConfig holds the configuration for rate limiting
 type RateLimiterConfig struct {
-	RedisClient *redis.Client
-	MaxRequests map[string]int // Requests per window per endpoint
+	RedisClient redis.UniversalClient
+	MaxRequests map[string]int // Requests per window per endpoint, such as "/domain"
 	WindowSize  time.Duration
 	DefaultMax  int // Default max requests for unspecified endpoints
 }
 
-// RateLimiter creates a new rate limiting middleware with Redis backend
+// RateLimiter creates a new rate limiting middleware with Redis backend. Each client may make
+// the maximum number of requests of an endpoint at once, after which the quota is restored
+// evenly over the window. Every response reports the quota in X-Rate-Limit headers.
 func RateLimiter(config RateLimiterConfig) fiber.Handler {
+	limiter := ratelimit.NewLimiter(config.RedisClient)
+
 	return func(c *fiber.Ctx) error {
-		endpoint := c.Path()
+		endpoint := endpointOf(c.Path())
 		clientIP := c.IP()
 
 		// Determine max requests for this endpoint
@@ -29,32 +35,8 @@ func RateLimiter(config RateLimiterConfig) fiber.Handler {
 			maxRequests = limit
 		}
 
-		// Create a unique key for this client and endpoint
-		key := fmt.Sprintf("ratelimit:%s:%s", clientIP, endpoint)
-
-		// Use Redis to implement a sliding window rate limit
-		ctx := context.Background()
-		now := time.Now().UnixNano()
-		windowStart := now - config.WindowSize.Nanoseconds()
-
-		pipe := config.RedisClient.Pipeline()
-
-		// Remove old requests outside the window
-		pipe.ZRemRangeByScore(ctx, key, "0", fmt.Sprintf("%d", windowStart))
-
-		// Add current request
-		pipe.ZAdd(ctx, key, &redis.Z{
-			Score:  float64(now),
-			Member: now,
-		})
-
-		// Count requests in current window
-		pipe.ZCard(ctx, key)
-
-		// Set key expiration
-		pipe.Expire(ctx, key, config.WindowSize)
-
-		results, err := pipe.Exec(ctx)
+		result, err := limiter.Allow(c.UserContext(), clientIP+":"+endpoint,
+			ratelimit.Limit{Rate: maxRequests, Period: config.WindowSize})
 		if err != nil {
 			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 				"error":   "Rate limiting error",
@@ -62,17 +44,18 @@ func RateLimiter(config RateLimiterConfig) fiber.Handler {
 			})
 		}
 
-		// Get the current request count
-		requestCount := results[2].(*redis.IntCmd).Val()
+		c.Set("X-Rate-Limit-Limit", strconv.Itoa(result.Limit))
+		c.Set("X-Rate-Limit-Remaining", strconv.Itoa(result.Remaining))
+		c.Set("X-Rate-Limit-Reset", strconv.FormatInt(time.Now().Add(result.ResetAfter).Unix(), 10))
 
-		if requestCount > int64(maxRequests) {
-			retryAfter := time.Duration(windowStart+config.WindowSize.Nanoseconds()-now) * time.Nanosecond
-			c.Set("Retry-After", fmt.Sprintf("%.0f", retryAfter.Seconds()))
+		if !result.Allowed {
+			retryAfter := int((result.RetryAfter + time.Second - 1) / time.Second)
+			c.Set("Retry-After", strconv.Itoa(retryAfter))
 
 			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
 				"error":               "Rate limit exceeded",
 				"details":             fmt.Sprintf("Maximum of %d requests per %v allowed", maxRequests, config.WindowSize),
-				"retry_after_seconds": int(retryAfter.Seconds()),
+				"retry_after_seconds": retryAfter,
 			})
 		}
 
@@ -80,8 +63,17 @@ func RateLimiter(config RateLimiterConfig) fiber.Handler {
 	}
 }
 
+// endpointOf returns the first segment of a request path, such as "/domain" for
+// "/domain/example.com", so all lookups of an object type share one limit
+func endpointOf(path string) string {
+	if i := strings.IndexByte(strings.TrimPrefix(path, "/"), '/'); i >= 0 {
+		return path[:i+1]
+	}
+	return path
+}
+
 // NewDefaultRateLimiter creates a rate limiter with default configuration
-func NewDefaultRateLimiter(redisClient *redis.Client) fiber.Handler {
+func NewDefaultRateLimiter(redisClient redis.UniversalClient) fiber.Handler {
 	config := RateLimiterConfig{
 		RedisClient: redisClient,
 		MaxRequests: map[string]int{
@@ -2,6 +2,8 @@
 package models
 
 import (
+	"encoding/json"
+	"fmt"
 	"sync"
 )
 
@@ -85,6 +87,8 @@ func (p *ObjectPool) PutResponse(r *RDAPResponse) {
 	r.Country = ""
 	r.Status = nil
 	r.Port43 = ""
+	r.RDAPConformance = nil
+	r.Redacted = nil
 	p.responses.Put(r)
 }
 
@@ -159,21 +163,23 @@ func (p *ObjectPool) PutEntity(e *Entity) {
 
 // RDAPResponse represents an RDAP response
 type RDAPResponse struct {
-	ObjectClassName string    `json:"objectClassName,omitempty"`
-	Handle          string    `json:"handle,omitempty"`
-	StartAddress    string    `json:"startAddress,omitempty"`
-	EndAddress      string    `json:"endAddress,omitempty"`
-	IPVersion       string    `json:"ipVersion,omitempty"`
-	Name            string    `json:"name,omitempty"`
-	Type            string    `json:"type,omitempty"`
-	Country         string    `json:"country,omitempty"`
-	Status          []string  `json:"status,omitempty"`
-	Events          []*Event  `json:"events,omitempty"`
-	Entities        []Entity  `json:"entities,omitempty"`
-	Notices         []*Notice `json:"notices,omitempty"`
-	Links           []*Link   `json:"links,omitempty"`
-	Port43          string    `json:"port43,omitempty"`
-	Remarks         []string  `json:"remarks,omitempty"`
+	ObjectClassName string      `json:"objectClassName,omitempty"`
+	Handle          string      `json:"handle,omitempty"`
+	StartAddress    string      `json:"startAddress,omitempty"`
+	EndAddress      string      `json:"endAddress,omitempty"`
+	IPVersion       string      `json:"ipVersion,omitempty"`
+	Name            string      `json:"name,omitempty"`
+	Type            string      `json:"type,omitempty"`
+	Country         string      `json:"country,omitempty"`
+	Status          []string    `json:"status,omitempty"`
+	Events          []*Event    `json:"events,omitempty"`
+	Entities        []Entity    `json:"entities,omitempty"`
+	Notices         []*Notice   `json:"notices,omitempty"`
+	Links           []*Link     `json:"links,omitempty"`
+	Port43          string      `json:"port43,omitempty"`
+	Remarks         []string    `json:"remarks,omitempty"`
+	RDAPConformance []string    `json:"rdapConformance,omitempty"`
+	Redacted        []*Redacted `json:"redacted,omitempty"`
 }
 
 // Event represents an RDAP event
@@ -208,3 +214,85 @@ type Entity struct {
 	Links           []*Link       `json:"links,omitempty"`
 	Status          []string      `json:"status,omitempty"`
 }
+
+// RedactedConformance is the rdapConformance value announcing RFC 9537 support
+const RedactedConformance = "redacted"
+
+// RFC 9537 redaction methods
+const (
+	RedactionMethodRemoval          = "removal"
+	RedactionMethodEmptyValue       = "emptyValue"
+	RedactionMethodPartialValue     = "partialValue"
+	RedactionMethodReplacementValue = "replacementValue"
+)
+
+// Redacted represents an RFC 9537 redaction member
+type Redacted struct {
+	Name            *RedactedLabel `json:"name"`
+	Reason          *RedactedLabel `json:"reason,omitempty"`
+	PrePath         string         `json:"prePath,omitempty"`
+	PostPath        string         `json:"postPath,omitempty"`
+	ReplacementPath string         `json:"replacementPath,omitempty"`
+	PathLang        string         `json:"pathLang,omitempty"`
+	Method          string         `json:"method,omitempty"`
+}
+
+// RedactedLabel holds either a free-form description or a registered type
+type RedactedLabel struct {
+	Description string `json:"description,omitempty"`
+	Type        string `json:"type,omitempty"`
+}
+
+// String returns the registered type if present, otherwise the description
+func (l *RedactedLabel) String() string {
+	if l == nil {
+		return ""
+	}
+	if l.Type != "" {
+		return l.Type
+	}
+	return l.Description
+}
+
+// RedactionMethod returns the redaction method, defaulting to removal as RFC 9537 requires
+func (r *Redacted) RedactionMethod() string {
+	if r.Method == "" {
+		return RedactionMethodRemoval
+	}
+	return r.Method
+}
+
+// Path returns the JSONPath expression locating the redacted field
+func (r *Redacted) Path() string {
+	switch r.RedactionMethod() {
+	case RedactionMethodRemoval:
+		return r.PrePath
+	case RedactionMethodReplacementValue:
+		if r.ReplacementPath != "" {
+			return r.ReplacementPath
+		}
+	}
+	if r.PostPath != "" {
+		return r.PostPath
+	}
+	return r.PrePath
+}
+
+// ParseRedacted extracts the redacted members from a decoded RDAP response
+func ParseRedacted(data map[string]interface{}) ([]*Redacted, error) {
+	raw, ok := data["redacted"]
+	if !ok {
+		return nil, nil
+	}
+
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("encoding redacted members: %w", err)
+	}
+
+	var redacted []*Redacted
+	if err := json.Unmarshal(encoded, &redacted); err != nil {
+		return nil, fmt.Errorf("decoding redacted members: %w", err)
+	}
+	return redacted, nil
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"github.com/stretchr/testify/assert"
 	"testing"
 )
@@ -99,6 +100,58 @@ func TestObjectPool(t *testing.T) {
 	})
 }
 
+func TestParseRedacted(t *testing.T) {
+	body := `{
+		"objectClassName": "domain",
+		"rdapConformance": ["rdap_level_0", "redacted"],
+		"redacted": [
+			{
+				"name": {"type": "Registrant Email"},
+				"prePath": "$.entities[?(@.roles[0]=='registrant')].vcardArray[1][?(@[0]=='email')]",
+				"method": "removal",
+				"reason": {"description": "Server policy"}
+			},
+			{
+				"name": {"description": "Registrant Name"},
+				"postPath": "$.entities[?(@.roles[0]=='registrant')].vcardArray[1][?(@[0]=='fn')][3]",
+				"pathLang": "jsonpath",
+				"method": "emptyValue"
+			},
+			{
+				"name": {"type": "Registry Domain ID"},
+				"prePath": "$.handle"
+			}
+		]
+	}`
+
+	var data map[string]interface{}
+	assert.NoError(t, json.Unmarshal([]byte(body), &data))
+
+	redacted, err := ParseRedacted(data)
+	assert.NoError(t, err)
+	assert.Len(t, redacted, 3)
+
+	assert.Equal(t, "Registrant Email", redacted[0].Name.String())
+	assert.Equal(t, "Server policy", redacted[0].Reason.String())
+	assert.Equal(t, RedactionMethodRemoval, redacted[0].RedactionMethod())
+	assert.Contains(t, redacted[0].Path(), "'email'")
+
+	assert.Equal(t, "Registrant Name", redacted[1].Name.String())
+	assert.Equal(t, "jsonpath", redacted[1].PathLang)
+	assert.Equal(t, RedactionMethodEmptyValue, redacted[1].RedactionMethod())
+	assert.Contains(t, redacted[1].Path(), "[3]")
+
+	// Method defaults to removal when omitted
+	assert.Equal(t, RedactionMethodRemoval, redacted[2].RedactionMethod())
+	assert.Equal(t, "$.handle", redacted[2].Path())
+	assert.Empty(t, redacted[2].Reason.String())
+
+	var resp RDAPResponse
+	assert.NoError(t, json.Unmarshal([]byte(body), &resp))
+	assert.Contains(t, resp.RDAPConformance, RedactedConformance)
+	assert.Len(t, resp.Redacted, 3)
+}
+
 func BenchmarkObjectPool(b *testing.B) {
 	pool := NewObjectPool()
 
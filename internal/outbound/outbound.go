@@ -0,0 +1,162 @@
+// Package outbound routes outgoing HTTP traffic through an HTTP CONNECT or SOCKS5 proxy.
+package outbound
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+)
+
+// Direct is the proxy URL that forces direct connections, overriding a broader proxy setting
+const Direct = "direct"
+
+// ProxyConfig describes an outbound proxy.
+// URL uses the http, https, socks5 or socks5h scheme; credentials may be given in the URL
+// or through Username and Password. NoProxy lists hosts that are always reached directly:
+// exact host names, domain suffixes ("example.com" or ".example.com"), IP addresses, CIDR
+// blocks, or "*" for everything.
+type ProxyConfig struct {
+	URL      string
+	Username string
+	Password string
+	NoProxy  []string
+}
+
+// Enabled reports whether the configuration names a proxy
+func (c ProxyConfig) Enabled() bool {
+	return c.URL != "" && c.URL != Direct
+}
+
+// Configure sets up a transport to send requests through the proxy. Without a proxy URL the
+// transport keeps honouring HTTP_PROXY, HTTPS_PROXY and NO_PROXY from the environment;
+// the URL "direct" disables proxying altogether.
+func Configure(t *http.Transport, dialer *net.Dialer, cfg ProxyConfig) error {
+	if cfg.URL == "" {
+		t.Proxy = http.ProxyFromEnvironment
+		t.DialContext = dialer.DialContext
+		return nil
+	}
+	if cfg.URL == Direct {
+		t.Proxy = nil
+		t.DialContext = dialer.DialContext
+		return nil
+	}
+
+	proxyURL, err := url.Parse(cfg.URL)
+	if err != nil {
+		return fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	if proxyURL.Host == "" {
+		return fmt.Errorf("invalid proxy URL %q: missing host", cfg.URL)
+	}
+	if cfg.Username != "" {
+		proxyURL.User = url.UserPassword(cfg.Username, cfg.Password)
+	}
+	bypass := newBypassList(cfg.NoProxy)
+
+	switch proxyURL.Scheme {
+	case "http", "https":
+		t.Proxy = func(req *http.Request) (*url.URL, error) {
+			if bypass.Match(req.URL.Host) {
+				return nil, nil
+			}
+			return proxyURL, nil
+		}
+		t.DialContext = dialer.DialContext
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if proxyURL.User != nil {
+			password, _ := proxyURL.User.Password()
+			auth = &proxy.Auth{User: proxyURL.User.Username(), Password: password}
+		}
+		socks, err := proxy.SOCKS5("tcp", proxyURL.Host, auth, dialer)
+		if err != nil {
+			return fmt.Errorf("creating SOCKS5 dialer: %w", err)
+		}
+		contextDialer, ok := socks.(proxy.ContextDialer)
+		if !ok {
+			return fmt.Errorf("SOCKS5 dialer does not support contexts")
+		}
+		t.Proxy = nil
+		t.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			if bypass.Match(addr) {
+				return dialer.DialContext(ctx, network, addr)
+			}
+			return contextDialer.DialContext(ctx, network, addr)
+		}
+	default:
+		return fmt.Errorf("unsupported proxy scheme: %s", proxyURL.Scheme)
+	}
+
+	return nil
+}
+
+// NewTransport returns a copy of http.DefaultTransport that sends requests through the proxy
+func NewTransport(cfg ProxyConfig) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if err := Configure(t, dialer, cfg); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// bypassList matches hosts that must not go through the proxy
+type bypassList struct {
+	all      bool
+	hosts    []string
+	networks []*net.IPNet
+}
+
+func newBypassList(entries []string) *bypassList {
+	b := &bypassList{}
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+		case entry == "*":
+			b.all = true
+		default:
+			if _, network, err := net.ParseCIDR(entry); err == nil {
+				b.networks = append(b.networks, network)
+				continue
+			}
+			b.hosts = append(b.hosts, strings.TrimPrefix(entry, "."))
+		}
+	}
+	return b
+}
+
+// Match reports whether a host, optionally with a port, bypasses the proxy
+func (b *bypassList) Match(hostport string) bool {
+	if b.all {
+		return true
+	}
+
+	// URL hosts hold IPv6 addresses in brackets even without a port
+	host := strings.TrimSuffix(strings.TrimPrefix(hostport, "["), "]")
+	if h, _, err := net.SplitHostPort(hostport); err == nil {
+		host = h
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+
+	if ip := net.ParseIP(host); ip != nil {
+		for _, network := range b.networks {
+			if network.Contains(ip) {
+				return true
+			}
+		}
+	}
+	for _, entry := range b.hosts {
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
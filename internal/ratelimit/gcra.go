@@ -0,0 +1,119 @@
+// Package ratelimit limits request rates across replicas with the generic cell rate algorithm
+// (GCRA) in Redis.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// DefaultPrefix prefixes the Redis keys holding the state of a Limiter
+const DefaultPrefix = "ratelimit:"
+
+// Limit allows Rate requests per Period, of which up to Burst may be made at once. Burst
+// defaults to Rate, allowing a whole period's requests in one go.
+type Limit struct {
+	Rate   int
+	Period time.Duration
+	Burst  int
+}
+
+// PerMinute returns a limit of rate requests per minute
+func PerMinute(rate int) Limit {
+	return Limit{Rate: rate, Period: time.Minute}
+}
+
+// interval returns the time between requests made at the sustained rate
+func (l Limit) interval() time.Duration {
+	return l.Period / time.Duration(l.Rate)
+}
+
+func (l Limit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Rate
+}
+
+// Result is the decision on a request
+type Result struct {
+	Allowed bool
+	// Limit is the number of requests that can be made at once
+	Limit int
+	// Remaining is the number of requests that can still be made at once
+	Remaining int
+	// RetryAfter is how long until a rejected request would be allowed; zero when allowed
+	RetryAfter time.Duration
+	// ResetAfter is how long until the full burst is available again
+	ResetAfter time.Duration
+}
+
+// gcraScript applies the generic cell rate algorithm to the theoretical arrival time stored
+// under KEYS[1], in microseconds of the Redis clock. ARGV holds the emission interval and the
+// burst tolerance, in microseconds. Rejected requests leave the state unchanged, and the key
+// expires once the full burst is available again.
+var gcraScript = redis.NewScript(`
+redis.replicate_commands()
+local interval = tonumber(ARGV[1])
+local tolerance = tonumber(ARGV[2])
+
+local time = redis.call("TIME")
+local now = tonumber(time[1]) * 1000000 + tonumber(time[2])
+local tat = tonumber(redis.call("GET", KEYS[1]))
+if tat == nil or tat < now then
+	tat = now
+end
+
+local new_tat = tat + interval
+local diff = now - (new_tat - tolerance)
+if diff < 0 then
+	local remaining = math.floor((now - (tat - tolerance)) / interval)
+	return {0, math.max(remaining, 0), -diff, tat - now}
+end
+
+local reset_after = new_tat - now
+redis.call("SET", KEYS[1], string.format("%d", new_tat), "PX", math.ceil(reset_after / 1000))
+return {1, math.floor(diff / interval), 0, reset_after}
+`)
+
+// Limiter decides whether requests are allowed. Each decision costs one Redis round-trip and
+// each key a single value, and the clock of Redis is used so replicas agree.
+type Limiter struct {
+	client redis.UniversalClient
+	prefix string
+}
+
+// NewLimiter creates a limiter on a client from redisclient.New, storing its state under
+// keys starting with DefaultPrefix
+func NewLimiter(client redis.UniversalClient) *Limiter {
+	return &Limiter{client: client, prefix: DefaultPrefix}
+}
+
+// Allow decides whether a request counted against key is allowed under limit. Only allowed
+// requests consume quota.
+func (rl *Limiter) Allow(ctx context.Context, key string, limit Limit) (Result, error) {
+	if limit.Rate <= 0 || limit.Period <= 0 || limit.interval() < time.Microsecond {
+		return Result{}, fmt.Errorf("invalid rate limit: %d per %s", limit.Rate, limit.Period)
+	}
+	interval := limit.interval()
+	tolerance := interval * time.Duration(limit.burst())
+
+	values, err := gcraScript.Run(ctx, rl.client, []string{rl.prefix + key},
+		interval.Microseconds(), tolerance.Microseconds()).Int64Slice()
+	if err != nil {
+		return Result{}, err
+	}
+	if len(values) != 4 {
+		return Result{}, fmt.Errorf("unexpected rate limit reply: %v", values)
+	}
+	return Result{
+		Allowed:    values[0] == 1,
+		Limit:      limit.burst(),
+		Remaining:  int(values[1]),
+		RetryAfter: time.Duration(values[2]) * time.Microsecond,
+		ResetAfter: time.Duration(values[3]) * time.Microsecond,
+	}, nil
+}
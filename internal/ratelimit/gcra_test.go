@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLimiterGCRA(t *testing.T) {
+	m := miniredis.RunT(t)
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	m.SetTime(now)
+	client := redis.NewClient(&redis.Options{Addr: m.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	rl := NewLimiter(client)
+	ctx := context.Background()
+	limit := Limit{Rate: 60, Period: time.Minute, Burst: 3}
+
+	// The burst is allowed at once
+	for i := 2; i >= 0; i-- {
+		res, err := rl.Allow(ctx, "client", limit)
+		require.NoError(t, err)
+		assert.True(t, res.Allowed)
+		assert.Equal(t, 3, res.Limit)
+		assert.Equal(t, i, res.Remaining)
+		assert.Zero(t, res.RetryAfter)
+	}
+
+	// Then requests are rejected until one interval has passed
+	res, err := rl.Allow(ctx, "client", limit)
+	require.NoError(t, err)
+	assert.False(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+	assert.Equal(t, time.Second, res.RetryAfter)
+	assert.Equal(t, 3*time.Second, res.ResetAfter)
+
+	// Rejected requests do not consume quota
+	m.SetTime(now.Add(time.Second))
+	res, err = rl.Allow(ctx, "client", limit)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 0, res.Remaining)
+
+	// Other keys have their own quota
+	res, err = rl.Allow(ctx, "other", limit)
+	require.NoError(t, err)
+	assert.True(t, res.Allowed)
+	assert.Equal(t, 2, res.Remaining)
+
+	// State is one value per key, expiring once the burst is restored
+	assert.Equal(t, []string{"ratelimit:client", "ratelimit:other"}, m.Keys())
+	m.FastForward(3 * time.Second)
+	assert.False(t, m.Exists("ratelimit:client"))
+
+	_, err = rl.Allow(ctx, "client", Limit{})
+	assert.Error(t, err)
+}
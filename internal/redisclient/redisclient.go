@@ -0,0 +1,204 @@
+// Package redisclient builds the Redis client shared by the cache, the rate limiters and the
+// other Redis consumers, for standalone, Sentinel and Cluster deployments.
+package redisclient
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/ohelal/rdap/internal/config"
+)
+
+// Topologies accepted in config.RedisConfig.Mode
+const (
+	ModeStandalone = "standalone"
+	ModeSentinel   = "sentinel"
+	ModeCluster    = "cluster"
+)
+
+// New returns a client for the topology described by cfg. It does not connect; the first
+// command does.
+func New(cfg config.RedisConfig) (redis.UniversalClient, error) {
+	opts, err := Options(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	switch cfg.Mode {
+	case "", ModeStandalone:
+		return redis.NewClient(opts.Simple()), nil
+	case ModeSentinel:
+		return redis.NewFailoverClient(opts.Failover()), nil
+	case ModeCluster:
+		return redis.NewClusterClient(opts.Cluster()), nil
+	}
+	return nil, fmt.Errorf("unsupported redis mode: %s", cfg.Mode)
+}
+
+// Options converts cfg to go-redis options. In standalone mode, a redis:// or rediss:// URL
+// sets the address, credentials, database and TLS, which the other fields override when set.
+func Options(cfg config.RedisConfig) (*redis.UniversalOptions, error) {
+	opts := &redis.UniversalOptions{
+		Addrs:            cfg.Addrs,
+		MasterName:       cfg.MasterName,
+		Username:         cfg.Username,
+		Password:         cfg.Password,
+		SentinelUsername: cfg.SentinelUsername,
+		SentinelPassword: cfg.SentinelPassword,
+		DB:               cfg.DB,
+		MaxRetries:       3,
+		PoolSize:         cfg.PoolSize,
+		MinIdleConns:     cfg.MinIdleConns,
+		DialTimeout:      cfg.DialTimeout,
+		ReadTimeout:      cfg.ReadTimeout,
+		WriteTimeout:     cfg.WriteTimeout,
+	}
+
+	switch cfg.Mode {
+	case "", ModeStandalone:
+		if err := applyURL(opts, cfg); err != nil {
+			return nil, err
+		}
+	case ModeSentinel:
+		if cfg.MasterName == "" || len(cfg.Addrs) == 0 {
+			return nil, fmt.Errorf("redis sentinel mode requires a master name and sentinel addresses")
+		}
+	case ModeCluster:
+		if len(cfg.Addrs) == 0 {
+			return nil, fmt.Errorf("redis cluster mode requires seed addresses")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported redis mode: %s", cfg.Mode)
+	}
+
+	if cfg.TLS.Enabled {
+		tlsConfig, err := tlsConfig(cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		opts.TLSConfig = tlsConfig
+	}
+	return opts, nil
+}
+
+// applyURL sets the standalone address from cfg.URL, which is either host:port or a URL
+func applyURL(opts *redis.UniversalOptions, cfg config.RedisConfig) error {
+	if cfg.URL == "" {
+		return fmt.Errorf("redis url is required in standalone mode")
+	}
+	if !strings.Contains(cfg.URL, "://") {
+		opts.Addrs = []string{cfg.URL}
+		return nil
+	}
+
+	parsed, err := redis.ParseURL(cfg.URL)
+	if err != nil {
+		return fmt.Errorf("invalid redis url: %v", err)
+	}
+	opts.Addrs = []string{parsed.Addr}
+	if opts.Username == "" {
+		opts.Username = parsed.Username
+	}
+	if opts.Password == "" {
+		opts.Password = parsed.Password
+	}
+	if opts.DB == 0 {
+		opts.DB = parsed.DB
+	}
+	opts.TLSConfig = parsed.TLSConfig
+	return nil
+}
+
+// tlsConfig builds the TLS client configuration for Redis connections
+func tlsConfig(cfg config.RedisTLSConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read redis CA file %s: %w", cfg.CAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in redis CA file %s", cfg.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if cfg.CertFile != "" || cfg.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load redis client certificate %s: %w", cfg.CertFile, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// Scan calls fn for every key matching a SCAN pattern. On a cluster, every master is scanned.
+func Scan(ctx context.Context, client redis.UniversalClient, pattern string, fn func(key string) error) error {
+	scan := func(ctx context.Context, node redis.UniversalClient) error {
+		iter := node.Scan(ctx, 0, pattern, 1000).Iterator()
+		for iter.Next(ctx) {
+			if err := fn(iter.Val()); err != nil {
+				return err
+			}
+		}
+		return iter.Err()
+	}
+
+	if cluster, ok := client.(*redis.ClusterClient); ok {
+		// ForEachMaster runs concurrently and fn need not be safe for that, so masters are
+		// collected first and scanned one at a time
+		var mu sync.Mutex
+		var masters []*redis.Client
+		err := cluster.ForEachMaster(ctx, func(ctx context.Context, master *redis.Client) error {
+			mu.Lock()
+			masters = append(masters, master)
+			mu.Unlock()
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, master := range masters {
+			if err := scan(ctx, master); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return scan(ctx, client)
+}
+
+// Del deletes keys one command per key in a pipeline, so keys in different cluster slots can
+// be deleted together, and returns how many existed
+func Del(ctx context.Context, client redis.UniversalClient, keys ...string) (int, error) {
+	if len(keys) == 0 {
+		return 0, nil
+	}
+	pipe := client.Pipeline()
+	cmds := make([]*redis.IntCmd, len(keys))
+	for i, key := range keys {
+		cmds[i] = pipe.Del(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+
+	removed := 0
+	for _, cmd := range cmds {
+		removed += int(cmd.Val())
+	}
+	return removed, nil
+}
@@ -0,0 +1,205 @@
+package redisclient
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/alicebob/miniredis/v2/server"
+	"github.com/ohelal/rdap/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newSentinel starts a stand-in Sentinel that reports master as the address of the master
+// named "mymaster" and requires password
+func newSentinel(t *testing.T, master *miniredis.Miniredis, password string) string {
+	srv, err := server.NewServer("127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(srv.Close)
+
+	authed := make(map[*server.Peer]bool)
+	srv.Register("AUTH", func(c *server.Peer, cmd string, args []string) {
+		if len(args) == 1 && args[0] == password {
+			authed[c] = true
+			c.WriteOK()
+			return
+		}
+		c.WriteError("WRONGPASS invalid password")
+	})
+	srv.Register("SENTINEL", func(c *server.Peer, cmd string, args []string) {
+		if !authed[c] {
+			c.WriteError("NOAUTH Authentication required.")
+			return
+		}
+		switch strings.ToLower(args[0]) {
+		case "get-master-addr-by-name":
+			if args[1] != "mymaster" {
+				c.WriteNull()
+				return
+			}
+			host, port, _ := net.SplitHostPort(master.Addr())
+			c.WriteStrings([]string{host, port})
+		default:
+			c.WriteLen(0)
+		}
+	})
+	srv.Register("SUBSCRIBE", func(c *server.Peer, cmd string, args []string) {
+		for i, channel := range args {
+			c.WriteLen(3)
+			c.WriteBulk("subscribe")
+			c.WriteBulk(channel)
+			c.WriteInt(i + 1)
+		}
+	})
+	srv.Register("PING", func(c *server.Peer, cmd string, args []string) {
+		c.WriteInline("PONG")
+	})
+	return srv.Addr().String()
+}
+
+// writeTLSFiles creates a self-signed certificate for 127.0.0.1 and returns the server TLS
+// configuration and the path of the certificate as a CA file
+func writeTLSFiles(t *testing.T) (*tls.Config, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "redis"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	require.NoError(t, os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600))
+	return &tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: key}}}, caFile
+}
+
+func TestNew(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("Standalone with password and database", func(t *testing.T) {
+		m := miniredis.RunT(t)
+		m.RequireAuth("s3cret")
+
+		client, err := New(config.RedisConfig{URL: m.Addr(), Password: "s3cret", DB: 2})
+		require.NoError(t, err)
+		defer client.Close()
+		require.NoError(t, client.Set(ctx, "k", "v", 0).Err())
+		got, err := m.DB(2).Get("k")
+		require.NoError(t, err)
+		assert.Equal(t, "v", got)
+
+		client, err = New(config.RedisConfig{URL: m.Addr()})
+		require.NoError(t, err)
+		defer client.Close()
+		assert.ErrorContains(t, client.Ping(ctx).Err(), "NOAUTH")
+	})
+
+	t.Run("Standalone URL", func(t *testing.T) {
+		m := miniredis.RunT(t)
+		m.RequireUserAuth("rdap", "s3cret")
+
+		client, err := New(config.RedisConfig{URL: "redis://rdap:s3cret@" + m.Addr() + "/3"})
+		require.NoError(t, err)
+		defer client.Close()
+		require.NoError(t, client.Set(ctx, "k", "v", 0).Err())
+		assert.True(t, m.DB(3).Exists("k"))
+	})
+
+	t.Run("TLS", func(t *testing.T) {
+		serverTLS, caFile := writeTLSFiles(t)
+		m, err := miniredis.RunTLS(serverTLS)
+		require.NoError(t, err)
+		defer m.Close()
+
+		client, err := New(config.RedisConfig{URL: m.Addr(), TLS: config.RedisTLSConfig{Enabled: true, CAFile: caFile}})
+		require.NoError(t, err)
+		defer client.Close()
+		require.NoError(t, client.Ping(ctx).Err())
+
+		client, err = New(config.RedisConfig{URL: m.Addr(), TLS: config.RedisTLSConfig{Enabled: true}})
+		require.NoError(t, err)
+		defer client.Close()
+		assert.ErrorContains(t, client.Ping(ctx).Err(), "certificate")
+	})
+
+	t.Run("Sentinel", func(t *testing.T) {
+		m := miniredis.RunT(t)
+		m.RequireAuth("s3cret")
+		sentinel := newSentinel(t, m, "sentinel-s3cret")
+
+		client, err := New(config.RedisConfig{
+			Mode:             ModeSentinel,
+			Addrs:            []string{sentinel},
+			MasterName:       "mymaster",
+			Password:         "s3cret",
+			SentinelPassword: "sentinel-s3cret",
+			DB:               1,
+		})
+		require.NoError(t, err)
+		defer client.Close()
+		require.NoError(t, client.Set(ctx, "k", "v", 0).Err())
+		assert.True(t, m.DB(1).Exists("k"))
+	})
+
+	t.Run("Cluster", func(t *testing.T) {
+		m := miniredis.RunT(t)
+		client, err := New(config.RedisConfig{Mode: ModeCluster, Addrs: []string{m.Addr()}})
+		require.NoError(t, err)
+		defer client.Close()
+
+		for i := 0; i < 5; i++ {
+			require.NoError(t, client.Set(ctx, "domain:example"+strconv.Itoa(i)+".com", "v", 0).Err())
+		}
+		require.NoError(t, client.Set(ctx, "ip:192.0.2.1", "v", 0).Err())
+
+		var keys []string
+		require.NoError(t, Scan(ctx, client, "domain:*", func(key string) error {
+			keys = append(keys, key)
+			return nil
+		}))
+		sort.Strings(keys)
+		assert.Len(t, keys, 5)
+		assert.Equal(t, "domain:example0.com", keys[0])
+
+		removed, err := Del(ctx, client, append(keys, "domain:missing.com")...)
+		require.NoError(t, err)
+		assert.Equal(t, 5, removed)
+		assert.Equal(t, []string{"ip:192.0.2.1"}, m.Keys())
+	})
+
+	t.Run("Invalid configuration", func(t *testing.T) {
+		_, err := New(config.RedisConfig{Mode: ModeSentinel, Addrs: []string{"127.0.0.1:26379"}})
+		assert.Error(t, err)
+		_, err = New(config.RedisConfig{Mode: ModeCluster})
+		assert.Error(t, err)
+		_, err = New(config.RedisConfig{Mode: "replicated", URL: "redis:6379"})
+		assert.Error(t, err)
+		_, err = New(config.RedisConfig{URL: "redis://host:6379/notadb"})
+		assert.Error(t, err)
+	})
+}
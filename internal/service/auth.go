@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ohelal/rdap/internal/coalescing"
+	"github.com/ohelal/rdap/internal/config"
+)
+
+// tokenExpirySkew renews OIDC access tokens this long before they expire
+const tokenExpirySkew = 30 * time.Second
+
+// upstreamAuthenticator attaches credentials to requests for a single upstream host
+type upstreamAuthenticator interface {
+	// Authenticate adds credentials to a request that is about to be sent
+	Authenticate(req *http.Request) error
+	// Invalidate drops cached credentials after the upstream rejected them
+	Invalidate()
+}
+
+// newUpstreamAuthenticator builds the authenticator for an auth profile, returning nil when none is configured
+func newUpstreamAuthenticator(cfg config.UpstreamAuthConfig, client *http.Client) (upstreamAuthenticator, error) {
+	switch strings.ToLower(cfg.Type) {
+	case "":
+		return nil, nil
+	case "header":
+		if len(cfg.Headers) == 0 {
+			return nil, fmt.Errorf("header auth requires at least one header")
+		}
+		return &headerAuth{headers: cfg.Headers}, nil
+	case "bearer":
+		if cfg.Token == "" {
+			return nil, fmt.Errorf("bearer auth requires a token")
+		}
+		return &headerAuth{headers: map[string]string{"Authorization": "Bearer " + cfg.Token}}, nil
+	case "oidc":
+		if cfg.OIDC.TokenURL == "" || cfg.OIDC.ClientID == "" {
+			return nil, fmt.Errorf("oidc auth requires token_url and client_id")
+		}
+		return &oidcAuth{cfg: cfg.OIDC, client: client, requests: coalescing.NewGroup[string, string]("oidc_token")}, nil
+	default:
+		return nil, fmt.Errorf("unsupported auth type: %s", cfg.Type)
+	}
+}
+
+// headerAuth sets static headers such as API keys or bearer tokens
+type headerAuth struct {
+	headers map[string]string
+}
+
+func (a *headerAuth) Authenticate(req *http.Request) error {
+	for name, value := range a.headers {
+		req.Header.Set(name, value)
+	}
+	return nil
+}
+
+func (a *headerAuth) Invalidate() {}
+
+// oidcAuth obtains access tokens with the OAuth 2.0 client credentials grant and
+// presents them as bearer tokens, as described for RDAP in RFC 9560. Concurrent requests
+// needing a new token share a single token request.
+type oidcAuth struct {
+	cfg      config.OIDCConfig
+	client   *http.Client
+	requests *coalescing.Group[string, string]
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// tokenResponse is the token endpoint answer defined in RFC 6749 section 5.1
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int64  `json:"expires_in"`
+}
+
+func (a *oidcAuth) Authenticate(req *http.Request) error {
+	token, err := a.accessToken(req.Context())
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+func (a *oidcAuth) Invalidate() {
+	a.mu.Lock()
+	a.token = ""
+	a.mu.Unlock()
+}
+
+// accessToken returns the cached token or requests a new one once it is about to expire
+func (a *oidcAuth) accessToken(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	token, expiry := a.token, a.expiry
+	a.mu.Unlock()
+	if token != "" && time.Now().Before(expiry) {
+		return token, nil
+	}
+
+	token, _, err := a.requests.Do(ctx, a.cfg.TokenURL, a.requestToken)
+	return token, err
+}
+
+// requestToken obtains a new access token from the token endpoint and caches it
+func (a *oidcAuth) requestToken(ctx context.Context) (string, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	if len(a.cfg.Scopes) > 0 {
+		form.Set("scope", strings.Join(a.cfg.Scopes, " "))
+	}
+	if a.cfg.Audience != "" {
+		form.Set("audience", a.cfg.Audience)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.cfg.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("creating token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(a.cfg.ClientID), url.QueryEscape(a.cfg.ClientSecret))
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("requesting access token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("token endpoint returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+
+	var token tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("decoding token response: %w", err)
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("token endpoint returned no access token")
+	}
+	if token.TokenType != "" && !strings.EqualFold(token.TokenType, "bearer") {
+		return "", fmt.Errorf("unsupported token type: %s", token.TokenType)
+	}
+
+	expiry := time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - tokenExpirySkew)
+	if token.ExpiresIn <= 0 {
+		// Without a lifetime the token is used until the upstream rejects it
+		expiry = time.Now().Add(time.Hour)
+	}
+
+	a.mu.Lock()
+	a.token, a.expiry = token.AccessToken, expiry
+	a.mu.Unlock()
+	return token.AccessToken, nil
+}
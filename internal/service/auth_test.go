@@ -0,0 +1,206 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ohelal/rdap/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestOIDCProvider starts a stand-in OpenID provider issuing tokens with the client credentials grant
+func newTestOIDCProvider(t *testing.T, issued *int32) *httptest.Server {
+	provider := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/token" || r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+		clientID, secret, ok := r.BasicAuth()
+		if !ok || clientID != "rdap-proxy" || secret != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "unsupported_grant_type"})
+			return
+		}
+
+		n := atomic.AddInt32(issued, 1)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": fmt.Sprintf("token-%d-%s", n, r.PostForm.Get("scope")),
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+	}))
+	t.Cleanup(provider.Close)
+	return provider
+}
+
+// newTestUpstream starts an RDAP server recording the Authorization header it receives
+func newTestUpstream(t *testing.T, status int) (*httptest.Server, *atomic.Value) {
+	var seen atomic.Value
+	seen.Store("")
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen.Store(r.Header.Get("Authorization") + r.Header.Get("X-Api-Key"))
+		w.Header().Set("Content-Type", "application/rdap+json")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"objectClassName":"domain"}`))
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream, &seen
+}
+
+func hostOf(t *testing.T, rawURL string) string {
+	u, err := url.Parse(rawURL)
+	require.NoError(t, err)
+	return u.Host
+}
+
+func TestUpstreamAuthentication(t *testing.T) {
+	var issued int32
+	provider := newTestOIDCProvider(t, &issued)
+	oidcUpstream, oidcSeen := newTestUpstream(t, http.StatusOK)
+	keyUpstream, keySeen := newTestUpstream(t, http.StatusOK)
+	openUpstream, openSeen := newTestUpstream(t, http.StatusOK)
+	rejectingUpstream, rejectingSeen := newTestUpstream(t, http.StatusUnauthorized)
+
+	cfg := newTestConfig()
+	oidcProfile := config.UpstreamAuthConfig{
+		Type: "oidc",
+		OIDC: config.OIDCConfig{
+			TokenURL:     provider.URL + "/token",
+			ClientID:     "rdap-proxy",
+			ClientSecret: "s3cret",
+			Scopes:       []string{"openid", "rdap"},
+		},
+	}
+	cfg.Upstreams = map[string]config.UpstreamConfig{
+		hostOf(t, oidcUpstream.URL):      {Auth: oidcProfile},
+		hostOf(t, rejectingUpstream.URL): {Auth: oidcProfile},
+		hostOf(t, keyUpstream.URL): {Auth: config.UpstreamAuthConfig{
+			Type:    "header",
+			Headers: map[string]string{"X-Api-Key": "key-123"},
+		}},
+	}
+
+	pool, err := newTransportPool(cfg)
+	require.NoError(t, err)
+	client := &http.Client{Transport: pool}
+
+	get := func(rawURL string) {
+		resp, err := client.Get(rawURL)
+		require.NoError(t, err)
+		resp.Body.Close()
+	}
+
+	t.Run("OIDC token is acquired once and reused", func(t *testing.T) {
+		get(oidcUpstream.URL + "/domain/example.com")
+		assert.Equal(t, "Bearer token-1-openid rdap", oidcSeen.Load())
+		get(oidcUpstream.URL + "/domain/example.net")
+		assert.Equal(t, "Bearer token-1-openid rdap", oidcSeen.Load())
+		assert.Equal(t, int32(1), atomic.LoadInt32(&issued))
+	})
+
+	t.Run("Static headers go to their upstream only", func(t *testing.T) {
+		get(keyUpstream.URL + "/domain/example.com")
+		assert.Equal(t, "key-123", keySeen.Load())
+		get(openUpstream.URL + "/domain/example.com")
+		assert.Equal(t, "", openSeen.Load())
+	})
+
+	t.Run("Rejected token is renewed", func(t *testing.T) {
+		get(rejectingUpstream.URL + "/domain/example.com")
+		assert.Equal(t, "Bearer token-2-openid rdap", rejectingSeen.Load())
+		get(rejectingUpstream.URL + "/domain/example.com")
+		assert.Equal(t, "Bearer token-3-openid rdap", rejectingSeen.Load())
+		assert.Equal(t, int32(3), atomic.LoadInt32(&issued))
+	})
+
+	t.Run("Provider errors fail the request", func(t *testing.T) {
+		bad := config.UpstreamAuthConfig{Type: "oidc", OIDC: config.OIDCConfig{
+			TokenURL:     provider.URL + "/token",
+			ClientID:     "rdap-proxy",
+			ClientSecret: "wrong",
+		}}
+		auth, err := newUpstreamAuthenticator(bad, client)
+		require.NoError(t, err)
+
+		req := httptest.NewRequest(http.MethodGet, openUpstream.URL, nil)
+		err = auth.Authenticate(req)
+		assert.ErrorContains(t, err, "status 401")
+	})
+
+	t.Run("Invalid profiles are rejected", func(t *testing.T) {
+		_, err := newUpstreamAuthenticator(config.UpstreamAuthConfig{Type: "bearer"}, client)
+		assert.Error(t, err)
+		_, err = newUpstreamAuthenticator(config.UpstreamAuthConfig{Type: "oidc"}, client)
+		assert.Error(t, err)
+		_, err = newUpstreamAuthenticator(config.UpstreamAuthConfig{Type: "kerberos"}, client)
+		assert.Error(t, err)
+	})
+}
+
+func TestOIDCTokenOnUpstreamHost(t *testing.T) {
+	// The registry issues its own tokens, so the token endpoint shares the upstream's host
+	var issued int32
+	var tokenAuth, lookupAuth atomic.Value
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/token" {
+			tokenAuth.Store(r.Header.Get("Authorization"))
+			n := atomic.AddInt32(&issued, 1)
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"access_token": fmt.Sprintf("token-%d", n),
+				"token_type":   "Bearer",
+				"expires_in":   3600,
+			})
+			return
+		}
+		lookupAuth.Store(r.Header.Get("Authorization"))
+		w.Header().Set("Content-Type", "application/rdap+json")
+		w.Write([]byte(`{"objectClassName":"domain"}`))
+	}))
+	defer registry.Close()
+
+	cfg := newTestConfig()
+	cfg.RDAP.Timeout = 2 * time.Second
+	cfg.Upstreams = map[string]config.UpstreamConfig{
+		hostOf(t, registry.URL): {Auth: config.UpstreamAuthConfig{Type: "oidc", OIDC: config.OIDCConfig{
+			TokenURL:     registry.URL + "/token",
+			ClientID:     "rdap-proxy",
+			ClientSecret: "s3cret",
+		}}},
+	}
+	pool, err := newTransportPool(cfg)
+	require.NoError(t, err)
+	client := &http.Client{Transport: pool, Timeout: 2 * time.Second}
+
+	// Concurrent lookups share one token request
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(registry.URL + "/domain/example.com")
+			if assert.NoError(t, err) {
+				resp.Body.Close()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&issued))
+	assert.Equal(t, "Bearer token-1", lookupAuth.Load())
+	assert.Contains(t, tokenAuth.Load(), "Basic ", "the token request carries client credentials only")
+}
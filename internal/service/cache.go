@@ -0,0 +1,336 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ohelal/rdap/internal/cache"
+)
+
+// cacheStatusLocal is the fiber.Ctx local holding the cache status of a lookup
+const cacheStatusLocal = "rdap_cache_status"
+
+// Entry metadata keys describing where a cached answer came from
+const (
+	metaUpstream = "upstream"
+	metaAttempts = "attempts"
+	metaStatus   = "status"
+	metaSource   = "source"
+)
+
+// Sources of negative cache entries
+const (
+	negativeSourceUpstream  = "upstream"
+	negativeSourceBootstrap = "bootstrap"
+)
+
+// cachedHeaders are the upstream response headers kept with cached answers
+var cachedHeaders = []string{"Content-Type", "Cache-Control", "Expires", "Date", "ETag", "Last-Modified"}
+
+// CacheStatus returns the cache status recorded for a lookup: CacheStatusHit, CacheStatusMiss,
+// or an empty string when no cache was consulted
+func CacheStatus(c *fiber.Ctx) string {
+	status, _ := c.Locals(cacheStatusLocal).(string)
+	return status
+}
+
+// serveCached answers a lookup from the cache when a fresh entry exists, reporting whether it
+// did. An entry past its freshness lifetime that may still be served stale is returned instead.
+// Without a positive entry, a cached not-found or invalid answer is served.
+func (s *RDAPService) serveCached(c *fiber.Ctx, l lookup) (*cache.Entry, bool, error) {
+	if s.cache == nil {
+		return nil, false, nil
+	}
+
+	if s.ServiceConfig.Warmup.Enabled {
+		s.cache.RecordLookup(l.key)
+	}
+
+	c.Locals(cacheStatusLocal, CacheStatusMiss)
+	entry, err := s.cache.Get(c.Context(), l.key)
+	if err == nil {
+		if entry.Expired(time.Now()) {
+			if _, err = entry.Plain(); err == nil {
+				return entry, false, nil
+			}
+		} else {
+			var resp *upstreamResponse
+			if resp, err = s.cachedResponse(c, entry); err == nil {
+				c.Locals(cacheStatusLocal, CacheStatusHit)
+				return nil, true, s.writeResponse(c, resp, CacheStatusHit)
+			}
+		}
+		log.Printf("Dropping unreadable cache entry %s: %v", l.key, err)
+		s.cache.Delete(c.Context(), l.key)
+	}
+
+	if s.ServiceConfig.Cache.NegativeTTL > 0 {
+		entry, err = s.cache.Get(c.Context(), cache.NegativeKey(l.key))
+		if err == nil && !entry.Expired(time.Now()) {
+			if resp, err := s.cachedResponse(c, entry); err == nil {
+				c.Locals(cacheStatusLocal, CacheStatusNegative)
+				negativeCacheHits.WithLabelValues(l.objectType).Inc()
+				return nil, true, s.writeResponse(c, resp, CacheStatusNegative)
+			}
+		}
+	}
+
+	return nil, false, nil
+}
+
+// storeCached saves a successful upstream answer for a lookup under key in every cache tier,
+// fresh for the lifetime the upstream announced, clamped to the limits configured for the
+// object type. key differs from the lookup's own key when an IP answer is stored under its
+// network. It returns the stored entry, or nil when the answer was not cached.
+func (s *RDAPService) storeCached(ctx context.Context, l lookup, key string, resp *upstreamResponse) *cache.Entry {
+	if s.cache == nil || resp.StatusCode != http.StatusOK || !cache.Cacheable(resp.Header) {
+		return nil
+	}
+
+	lifetime, ok := cache.FreshnessLifetime(resp.Header, resp.FetchedAt)
+	limits := s.ServiceConfig.Cache.TTLFor(l.objectType)
+	ttl := cache.TTLBounds{
+		Min:     limits.MinTTL,
+		Max:     limits.MaxTTL,
+		Default: limits.DefaultTTL,
+	}.Clamp(lifetime, ok)
+
+	grace := s.ServiceConfig.Cache.StaleWhileRevalidate
+	if s.ServiceConfig.Cache.StaleIfError > grace {
+		grace = s.ServiceConfig.Cache.StaleIfError
+	}
+
+	entry := &cache.Entry{
+		ObjectType: l.objectType,
+		StoredAt:   resp.FetchedAt,
+		ExpiresAt:  resp.FetchedAt.Add(ttl),
+		StaleUntil: resp.FetchedAt.Add(ttl + grace),
+		Header:     make(http.Header),
+		Metadata: map[string]string{
+			metaUpstream: resp.URL,
+			metaAttempts: strconv.Itoa(resp.Attempts),
+			metaETag:     answerETag(resp.Body),
+		},
+		Kind:  cache.KindBytes,
+		Value: resp.Body,
+	}
+	for _, name := range cachedHeaders {
+		if values := resp.Header.Values(name); len(values) > 0 {
+			entry.Header[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+
+	if err := s.cache.Set(ctx, key, entry, 0); err != nil {
+		log.Printf("Failed to cache %s: %v", key, err)
+	}
+	// The opposite entry is only dropped here and from the shared tiers; other replicas look
+	// up the positive entry first and let their local negative copies expire. A not-found
+	// answer is stored under the lookup's key, even when this answer goes under its network.
+	if s.ServiceConfig.Cache.NegativeTTL > 0 {
+		negative := []string{cache.NegativeKey(l.key)}
+		if key != l.key {
+			negative = append(negative, cache.NegativeKey(key))
+		}
+		s.cache.Delete(ctx, negative...)
+	}
+	return entry
+}
+
+// storeNegative caches a not-found or invalid answer for the negative TTL under its own key,
+// dropping any positive entry for the lookup. source is "upstream" or "bootstrap". It returns
+// the stored entry, or nil when the answer was not cached.
+func (s *RDAPService) storeNegative(ctx context.Context, l lookup, resp *upstreamResponse, source string) *cache.Entry {
+	ttl := s.ServiceConfig.Cache.NegativeTTL
+	if s.cache == nil || ttl <= 0 {
+		return nil
+	}
+
+	entry := &cache.Entry{
+		ObjectType: l.objectType,
+		StoredAt:   resp.FetchedAt,
+		ExpiresAt:  resp.FetchedAt.Add(ttl),
+		Header:     http.Header{"Content-Type": {resp.Header.Get("Content-Type")}},
+		Metadata: map[string]string{
+			metaUpstream: resp.URL,
+			metaAttempts: strconv.Itoa(resp.Attempts),
+			metaStatus:   strconv.Itoa(resp.StatusCode),
+			metaSource:   source,
+		},
+		Kind:  cache.KindBytes,
+		Value: resp.Body,
+	}
+
+	if err := s.cache.Set(ctx, cache.NegativeKey(l.key), entry, 0); err != nil {
+		log.Printf("Failed to cache negative answer for %s: %v", l.key, err)
+		return nil
+	}
+	s.cache.Delete(ctx, l.key)
+	negativeCacheStores.WithLabelValues(l.objectType, source).Inc()
+	return entry
+}
+
+// notFound answers a lookup for which the bootstrap registry lists no RDAP server
+// and caches that answer as a negative entry
+func (s *RDAPService) notFound(c *fiber.Ctx, l lookup, title, description string) error {
+	body, err := json.Marshal(fiber.Map{
+		"errorCode":   404,
+		"title":       title,
+		"description": []string{description},
+	})
+	if err != nil {
+		return err
+	}
+
+	s.storeNegative(c.Context(), l, &upstreamResponse{
+		StatusCode: http.StatusNotFound,
+		Header:     http.Header{"Content-Type": {fiber.MIMEApplicationJSON}},
+		Body:       body,
+		FetchedAt:  time.Now(),
+	}, negativeSourceBootstrap)
+
+	c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+	return c.Status(http.StatusNotFound).Send(body)
+}
+
+// isNegative reports whether an upstream status is a not-found or invalid answer worth caching
+func isNegative(status int) bool {
+	return status == http.StatusNotFound || status == http.StatusBadRequest
+}
+
+// cachedResponse rebuilds the answer held by a cache entry. The value is sent still compressed
+// when the client accepts its encoding and the body is not rewritten on the way out.
+func (s *RDAPService) cachedResponse(c *fiber.Ctx, entry *cache.Entry) (*upstreamResponse, error) {
+	if encoding := entry.ContentEncoding(); encoding != "" && !s.ServiceConfig.Metadata.Notice &&
+		acceptsEncoding(c.Get(fiber.HeaderAcceptEncoding), encoding) {
+		resp := entryResponse(entry, entry.Value)
+		resp.ContentEncoding = encoding
+		if resp.ETag == "" {
+			plain, err := entry.Plain()
+			if err != nil {
+				return nil, err
+			}
+			resp.ETag = answerETag(plain)
+		}
+		return resp, nil
+	}
+
+	body, err := entry.Plain()
+	if err != nil {
+		return nil, err
+	}
+	return entryResponse(entry, body), nil
+}
+
+// acceptsEncoding reports whether an Accept-Encoding header allows a content coding
+func acceptsEncoding(header, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), encoding) {
+			continue
+		}
+		q, found := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !found {
+			return true
+		}
+		weight, err := strconv.ParseFloat(q, 64)
+		return err == nil && weight > 0
+	}
+	return false
+}
+
+// entryResponse rebuilds an upstream answer from a cache entry and its body
+func entryResponse(entry *cache.Entry, body []byte) *upstreamResponse {
+	attempts, _ := strconv.Atoi(entry.Metadata[metaAttempts])
+	status, err := strconv.Atoi(entry.Metadata[metaStatus])
+	if err != nil {
+		status = http.StatusOK
+	}
+	header := entry.Header
+	if header == nil {
+		header = make(http.Header)
+	}
+	return &upstreamResponse{
+		URL:        entry.Metadata[metaUpstream],
+		StatusCode: status,
+		Header:     header,
+		Body:       body,
+		Attempts:   attempts,
+		FetchedAt:  entry.StoredAt,
+		ETag:       entry.Metadata[metaETag],
+		ExpiresAt:  entry.ExpiresAt,
+	}
+}
+
+// conditionalHeader returns the If-None-Match and If-Modified-Since headers that revalidate a
+// cached answer with its upstream, or nil when there is no answer or it has no validators
+func conditionalHeader(entry *cache.Entry) http.Header {
+	if entry == nil || entry.Header == nil {
+		return nil
+	}
+	header := make(http.Header)
+	if etag := entry.Header.Get("ETag"); etag != "" {
+		header.Set("If-None-Match", etag)
+	}
+	if lastModified := entry.Header.Get("Last-Modified"); lastModified != "" {
+		header.Set("If-Modified-Since", lastModified)
+	}
+	if len(header) == 0 {
+		return nil
+	}
+	return header
+}
+
+// notModified rebuilds the cached answer that an upstream reported unchanged with a 304. The
+// cached headers are updated with those of the 304, which may announce a new lifetime.
+func notModified(cached *cache.Entry, resp *upstreamResponse) (*upstreamResponse, error) {
+	body, err := cached.Plain()
+	if err != nil {
+		return nil, err
+	}
+	header := cached.Header.Clone()
+	for _, name := range cachedHeaders {
+		if values := resp.Header.Values(name); len(values) > 0 {
+			header[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+	return &upstreamResponse{
+		URL:        resp.URL,
+		StatusCode: http.StatusOK,
+		Header:     header,
+		Body:       body,
+		Attempts:   resp.Attempts,
+		FetchedAt:  resp.FetchedAt,
+	}, nil
+}
+
+// HandleInvalidate drops the cache entries selected by the keys, prefix or pattern in the
+// request body on every replica
+func (s *RDAPService) HandleInvalidate(c *fiber.Ctx) error {
+	if s.cache == nil {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cache is disabled"})
+	}
+
+	var inv cache.Invalidation
+	if err := json.Unmarshal(c.Body(), &inv); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body: " + err.Error()})
+	}
+	if err := inv.Validate(); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	removed, err := s.cache.Invalidate(c.Context(), cache.Invalidation{
+		Keys:    inv.Keys,
+		Prefix:  inv.Prefix,
+		Pattern: inv.Pattern,
+	})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error(), "removed": removed})
+	}
+	return c.JSON(fiber.Map{"removed": removed})
+}
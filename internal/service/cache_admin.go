@@ -0,0 +1,133 @@
+package service
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"net/url"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ohelal/rdap/internal/cache"
+)
+
+// cacheDisabled answers admin cache requests when the server runs without a cache
+func cacheDisabled(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"error": "cache is disabled"})
+}
+
+// HandleCacheStats reports the number of entries in each cache tier by object type
+func (s *RDAPService) HandleCacheStats(c *fiber.Ctx) error {
+	if s.cache == nil {
+		return cacheDisabled(c)
+	}
+	stats, err := s.cache.Stats(c.Context())
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.JSON(stats)
+}
+
+// HandleCacheGet returns the entry stored under a key, with the tier it was found in
+func (s *RDAPService) HandleCacheGet(c *fiber.Ctx) error {
+	if s.cache == nil {
+		return cacheDisabled(c)
+	}
+	key, err := url.PathUnescape(c.Params("*"))
+	if err != nil || key == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid cache key"})
+	}
+
+	entry, tier, found := s.cache.GetEntry(c.Context(), key)
+	if !found {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "key not found", "key": key})
+	}
+	body, err := entry.Plain()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error(), "key": key})
+	}
+
+	now := time.Now()
+	resp := fiber.Map{
+		"key":         key,
+		"tier":        tier,
+		"entry":       entry,
+		"fresh":       !entry.Expired(now),
+		"ttlSeconds":  int64(entry.TTL(now).Seconds()),
+		"storedBytes": len(entry.Value),
+	}
+	if json.Valid(body) {
+		resp["value"] = json.RawMessage(body)
+	} else {
+		resp["value"] = string(body)
+	}
+	return c.JSON(resp)
+}
+
+// HandleCacheDelete removes a key from every tier on every replica
+func (s *RDAPService) HandleCacheDelete(c *fiber.Ctx) error {
+	if s.cache == nil {
+		return cacheDisabled(c)
+	}
+	key, err := url.PathUnescape(c.Params("*"))
+	if err != nil || key == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid cache key"})
+	}
+	removed, err := s.cache.Invalidate(c.Context(), cache.Invalidation{Keys: []string{key}})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error(), "removed": removed})
+	}
+	return c.JSON(fiber.Map{"removed": removed})
+}
+
+// HandleCachePurge removes every key starting with the prefix query parameter from every tier
+// on every replica
+func (s *RDAPService) HandleCachePurge(c *fiber.Ctx) error {
+	if s.cache == nil {
+		return cacheDisabled(c)
+	}
+	prefix := c.Query("prefix")
+	if prefix == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "prefix is required"})
+	}
+	removed, err := s.cache.Invalidate(c.Context(), cache.Invalidation{Prefix: prefix})
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error(), "removed": removed})
+	}
+	return c.JSON(fiber.Map{"removed": removed})
+}
+
+// HandleCacheExport streams the usable entries whose keys start with the optional prefix query
+// parameter as JSON lines
+func (s *RDAPService) HandleCacheExport(c *fiber.Ctx) error {
+	if s.cache == nil {
+		return cacheDisabled(c)
+	}
+	prefix := c.Query("prefix")
+	c.Set(fiber.HeaderContentType, "application/x-ndjson")
+	c.Set(fiber.HeaderContentDisposition, `attachment; filename="rdap-cache.jsonl"`)
+
+	// The request context is released when the handler returns, before the body is written
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		n, err := s.cache.Export(context.Background(), w, prefix)
+		if err != nil {
+			log.Printf("Cache export stopped after %d entries: %v", n, err)
+		}
+		w.Flush()
+	})
+	return nil
+}
+
+// HandleCacheImport stores the entries of an export in the request body, skipping expired ones
+func (s *RDAPService) HandleCacheImport(c *fiber.Ctx) error {
+	if s.cache == nil {
+		return cacheDisabled(c)
+	}
+	imported, skipped, err := s.cache.Import(c.Context(), bytes.NewReader(c.Body()))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error(), "imported": imported, "skipped": skipped})
+	}
+	return c.JSON(fiber.Map{"imported": imported, "skipped": skipped})
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleCacheExportImport(t *testing.T) {
+	src := newTestService(t, newTestConfig(), "http://rdap.example")
+	require.NoError(t, src.cache.SetValue("domain:example.com", `{"ldhName":"example.com"}`))
+	require.NoError(t, src.cache.SetValue("domain:example.net", `{"ldhName":"example.net"}`))
+	require.NoError(t, src.cache.SetValue("autnum:64496", `{"handle":"AS64496"}`))
+	dst := newTestService(t, newTestConfig(), "http://rdap.example")
+
+	send := func(s *RDAPService, enabled bool, method, target, token, body string) *http.Response {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := newTestAdminApp(s, enabled).Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	resp := send(src, true, http.MethodGet, "/admin/cache/export?prefix=domain:", testAdminToken, "")
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "application/x-ndjson", resp.Header.Get("Content-Type"))
+	export, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSuffix(string(export), "\n"), "\n")
+	require.Len(t, lines, 2, "only keys with the prefix are exported")
+
+	resp = send(dst, true, http.MethodPost, "/admin/cache/import", testAdminToken, string(export))
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	var result struct {
+		Imported int `json:"imported"`
+		Skipped  int `json:"skipped"`
+	}
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&result))
+	assert.Equal(t, 2, result.Imported)
+	assert.Equal(t, 0, result.Skipped)
+	val, found := dst.cache.GetValue("domain:example.net")
+	require.True(t, found)
+	assert.Equal(t, `{"ldhName":"example.net"}`, val)
+	_, found = dst.cache.GetValue("autnum:64496")
+	assert.False(t, found)
+
+	t.Run("Invalid import", func(t *testing.T) {
+		resp := send(dst, true, http.MethodPost, "/admin/cache/import", testAdminToken, "not json\n")
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+
+	t.Run("Wrong token", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, send(src, true, http.MethodGet, "/admin/cache/export", "wrong", "").StatusCode)
+		assert.Equal(t, http.StatusUnauthorized, send(dst, true, http.MethodPost, "/admin/cache/import", "", string(export)).StatusCode)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, send(src, false, http.MethodGet, "/admin/cache/export", testAdminToken, "").StatusCode)
+		assert.Equal(t, http.StatusNotFound, send(dst, false, http.MethodPost, "/admin/cache/import", testAdminToken, string(export)).StatusCode)
+	})
+}
@@ -0,0 +1,160 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ohelal/rdap/internal/cache"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRevalidation(t *testing.T) {
+	var conditional, full int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Cache-Control", "max-age=600")
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			atomic.AddInt32(&conditional, 1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		atomic.AddInt32(&full, 1)
+		w.Header().Set("Content-Type", "application/rdap+json")
+		w.Write([]byte(`{"objectClassName":"domain","ldhName":"changed.com"}`))
+	}))
+	defer upstream.Close()
+
+	// Stale answers are only served when the registry fails, so the lookup waits for the
+	// revalidation
+	cfg := newTestConfig()
+	cfg.Cache.StaleWhileRevalidate = 0
+	cfg.Cache.StaleIfError = time.Hour
+	s := newTestService(t, cfg, upstream.URL)
+	app := newTestApp(s)
+
+	l := newDomainLookup("example.com")
+	key := l.key
+	answer := newTestAnswer(upstream.URL, 20*time.Minute)
+	answer.Header.Set("ETag", `"v1"`)
+	require.NotNil(t, s.storeCached(context.Background(), l, key, answer))
+
+	notModified := testutil.ToFloat64(upstreamRevalidations.WithLabelValues("not_modified"))
+	resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/domain/example.com", nil))
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, CacheStatusMiss, resp.Header.Get(HeaderCache))
+	assert.JSONEq(t, string(answer.Body), string(body))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&conditional))
+	assert.Equal(t, int32(0), atomic.LoadInt32(&full))
+	assert.Equal(t, notModified+1, testutil.ToFloat64(upstreamRevalidations.WithLabelValues("not_modified")))
+
+	entry, _, found := s.cache.GetEntry(context.Background(), key)
+	require.True(t, found)
+	assert.False(t, entry.Expired(time.Now()))
+	assert.WithinDuration(t, time.Now().Add(10*time.Minute), entry.ExpiresAt, 5*time.Second)
+	plain, err := entry.Plain()
+	require.NoError(t, err)
+	assert.JSONEq(t, string(answer.Body), string(plain))
+}
+
+func TestLookupKeyNormalization(t *testing.T) {
+	var requests int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Type", "application/rdap+json")
+		w.Write([]byte(`{"objectClassName":"domain"}`))
+	}))
+	defer upstream.Close()
+
+	s := newTestService(t, newTestConfig(), upstream.URL)
+	app := newTestApp(s)
+
+	// Each lookup after the first is answered from the entry it stored
+	tests := []struct {
+		name  string
+		paths []string
+		key   string
+	}{
+		{"Trailing dot on a cold cache", []string{"/domain/example.com.", "/domain/example.com"}, "domain:example.com"},
+		{"Domain", []string{"/domain/Www.Example.COM", "/domain/www.example.com", "/domain/WWW.example.com."}, "domain:www.example.com"},
+		{"IDN", []string{"/domain/xn--bcher-kva.com", "/domain/B%C3%BCcher.com"}, "domain:xn--bcher-kva.com"},
+		{"IPv6", []string{"/ip/2001:DB8:0:0::1", "/ip/2001:db8::1", "/ip/2001:0db8::0001"}, "ip:2001:db8::1"},
+		{"Autnum", []string{"/autnum/15169", "/autnum/015169"}, "autnum:15169"},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			before := atomic.LoadInt32(&requests)
+			for i, path := range tc.paths {
+				resp, err := app.Test(httptest.NewRequest(http.MethodGet, path, nil))
+				require.NoError(t, err)
+				assert.Equal(t, http.StatusOK, resp.StatusCode, path)
+				want := CacheStatusHit
+				if i == 0 {
+					want = CacheStatusMiss
+				}
+				assert.Equal(t, want, resp.Header.Get(HeaderCache), path)
+			}
+			assert.Equal(t, before+1, atomic.LoadInt32(&requests))
+
+			_, err := s.cache.Get(context.Background(), tc.key)
+			assert.NoError(t, err)
+			_, err = s.cache.Get(context.Background(), cache.NegativeKey(tc.key))
+			assert.Error(t, err, "no negative entry")
+		})
+	}
+}
+
+func TestNegativeAnswers(t *testing.T) {
+	var status, requests int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Type", "application/rdap+json")
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+		w.Write([]byte(`{"objectClassName":"ip network","startAddress":"192.0.2.0","endAddress":"192.0.2.255"}`))
+	}))
+	defer upstream.Close()
+
+	cfg := newTestConfig()
+	cfg.Cache.NegativeTTL = time.Minute
+	cfg.Cache.IPRanges = true
+	s := newTestService(t, cfg, upstream.URL)
+	app := newTestApp(s)
+	get := func() *http.Response {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/ip/192.0.2.1", nil))
+		require.NoError(t, err)
+		return resp
+	}
+
+	// The first not-found answer is cached and served to the next lookup
+	atomic.StoreInt32(&status, http.StatusNotFound)
+	resp := get()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, CacheStatusMiss, resp.Header.Get(HeaderCache))
+	resp = get()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, CacheStatusNegative, resp.Header.Get(HeaderCache))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	// A later answer, stored under the network, drops the negative entry of the address
+	atomic.StoreInt32(&status, http.StatusOK)
+	l := lookup{objectType: cache.TypeIP, key: cache.Key(cache.TypeIP, "192.0.2.1"), path: "ip/192.0.2.1", servers: []string{upstream.URL + "/"}}
+	_, err := s.fetchAndStore(context.Background(), l, nil)
+	require.NoError(t, err)
+	_, err = s.cache.Get(context.Background(), cache.NegativeKey(l.key))
+	assert.Error(t, err, "negative entry dropped")
+
+	resp = get()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, CacheStatusHit, resp.Header.Get(HeaderCache))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&requests))
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/ohelal/rdap/internal/cache"
+	"github.com/ohelal/rdap/internal/coalescing"
+)
+
+// StartCoalescing shares upstream requests with the other replicas through Redis when
+// distributed coalescing is enabled, until ctx is done. It must be called before lookups are
+// served.
+func (s *RDAPService) StartCoalescing(ctx context.Context, client redis.UniversalClient) {
+	cfg := s.ServiceConfig.Coalescing
+	if !cfg.Distributed || s.cache == nil || client == nil {
+		return
+	}
+
+	s.leases = coalescing.NewLeases(client, coalescing.LeaseOptions{
+		Channel:      cfg.Channel,
+		TTL:          cfg.LeaseTTL,
+		PollInterval: cfg.PollInterval,
+		MaxWait:      cfg.MaxWait,
+	})
+	go func() {
+		if err := s.leases.Listen(ctx); err != nil {
+			log.Printf("Distributed coalescing falls back to polling: %v", err)
+		}
+	}()
+}
+
+// fetchLeased runs fetchAndStore for a lookup on only one replica at a time. The other
+// replicas answer from the cache entry it stores, or fetch themselves when none appears.
+func (s *RDAPService) fetchLeased(ctx context.Context, l lookup, cached *cache.Entry) (*upstreamResponse, error) {
+	var resp *upstreamResponse
+	err := s.leases.Do(ctx, l.key,
+		func(ctx context.Context) error {
+			var err error
+			resp, err = s.fetchAndStore(ctx, l, cached)
+			return err
+		},
+		func(ctx context.Context) bool {
+			resp = s.storedResponse(ctx, l)
+			return resp != nil
+		})
+	if err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// storedResponse returns the fresh answer to a lookup that another replica stored in the
+// cache, or nil when there is none
+func (s *RDAPService) storedResponse(ctx context.Context, l lookup) *upstreamResponse {
+	keys := []string{l.key}
+	if l.objectType == cache.TypeIP {
+		// The answer is stored under the network containing the address
+		if key, ok := s.cachedNetwork(ctx, strings.TrimPrefix(l.path, "ip/")); ok {
+			keys[0] = key
+		}
+	}
+	if s.ServiceConfig.Cache.NegativeTTL > 0 {
+		keys = append(keys, cache.NegativeKey(l.key))
+	}
+
+	for _, key := range keys {
+		entry, err := s.cache.Get(ctx, key)
+		if err != nil || entry.Expired(time.Now()) {
+			continue
+		}
+		body, err := entry.Plain()
+		if err != nil {
+			continue
+		}
+		return entryResponse(entry, body)
+	}
+	return nil
+}
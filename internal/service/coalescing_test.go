@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ohelal/rdap/internal/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newBlockingUpstream starts an RDAP server that holds every request until release is closed
+// or the request is cancelled, reporting each on started and cancelled
+func newBlockingUpstream(t *testing.T, release <-chan struct{}) (*httptest.Server, <-chan struct{}, <-chan struct{}) {
+	started := make(chan struct{}, 8)
+	cancelled := make(chan struct{}, 8)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		select {
+		case <-release:
+			w.Header().Set("Content-Type", "application/rdap+json")
+			w.Write([]byte(`{"objectClassName":"domain","ldhName":"example.com"}`))
+		case <-r.Context().Done():
+			cancelled <- struct{}{}
+		}
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream, started, cancelled
+}
+
+func TestLookupCoalescing(t *testing.T) {
+	l := lookup{objectType: cache.TypeDomain, key: cache.Key(cache.TypeDomain, "example.com"), path: "domain/example.com"}
+
+	t.Run("A follower giving up does not cancel the fetch", func(t *testing.T) {
+		release := make(chan struct{})
+		upstream, started, cancelled := newBlockingUpstream(t, release)
+		s := newTestService(t, newTestConfig(), upstream.URL)
+		l := l
+		l.servers = []string{upstream.URL + "/"}
+
+		leader := make(chan error, 1)
+		go func() {
+			resp, err := s.fetchShared(context.Background(), l, nil)
+			if err == nil {
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+			}
+			leader <- err
+		}()
+		<-started
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err := s.fetchShared(ctx, l, nil)
+		assert.ErrorIs(t, err, context.Canceled)
+
+		close(release)
+		require.NoError(t, <-leader)
+		assert.Empty(t, cancelled)
+	})
+
+	t.Run("A lookup abandoned by every client is cancelled", func(t *testing.T) {
+		release := make(chan struct{})
+		defer close(release)
+		upstream, _, cancelled := newBlockingUpstream(t, release)
+		cfg := newTestConfig()
+		cfg.Server.WriteTimeout = 100 * time.Millisecond
+		app := newTestApp(newTestService(t, cfg, upstream.URL))
+
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, "/domain/example.com", nil), 2000)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+		select {
+		case <-cancelled:
+		case <-time.After(time.Second):
+			t.Fatal("upstream request was not cancelled")
+		}
+	})
+}
@@ -0,0 +1,83 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// metaETag is the entry metadata holding the entity tag of a cached answer
+const metaETag = "etag"
+
+// answerETag returns the weak entity tag of an answer: a hash of its JSON with members sorted
+// and whitespace removed, so answers that differ only in formatting share a tag. It is weak
+// because the bytes sent also vary with notices and content coding.
+func answerETag(body []byte) string {
+	canonical := body
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	var data interface{}
+	if err := decoder.Decode(&data); err == nil {
+		if encoded, err := json.Marshal(data); err == nil {
+			canonical = encoded
+		}
+	}
+	sum := sha256.Sum256(canonical)
+	return `W/"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// lastModified returns when an answer last changed: the Last-Modified time announced by the
+// registry, or when the answer was fetched
+func lastModified(resp *upstreamResponse) time.Time {
+	if t, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil && !t.After(resp.FetchedAt) {
+		return t
+	}
+	return resp.FetchedAt
+}
+
+// cacheControl returns the Cache-Control header of an answer. Cached answers may be kept for
+// as long as their cached copy remains fresh, counted from age, the Age sent with them;
+// answers that were not cached carry the header of the registry.
+func cacheControl(resp *upstreamResponse, age int, now time.Time) string {
+	if resp.ExpiresAt.IsZero() {
+		return resp.Header.Get("Cache-Control")
+	}
+	remaining := int(resp.ExpiresAt.Sub(now) / time.Second)
+	if remaining < 0 {
+		remaining = 0
+	}
+	return "max-age=" + strconv.Itoa(remaining+age)
+}
+
+// requestNotModified reports whether the conditions of a GET or HEAD request show that the
+// client already holds an answer with the given validators. If-None-Match takes precedence
+// over If-Modified-Since, as RFC 9110 requires.
+func requestNotModified(c *fiber.Ctx, etag string, modified time.Time) bool {
+	if c.Method() != fiber.MethodGet && c.Method() != fiber.MethodHead {
+		return false
+	}
+	if match := c.Get(fiber.HeaderIfNoneMatch); match != "" {
+		return etagMatches(match, etag)
+	}
+	since, err := http.ParseTime(c.Get(fiber.HeaderIfModifiedSince))
+	return err == nil && !modified.Truncate(time.Second).After(since)
+}
+
+// etagMatches compares an If-None-Match header with an entity tag using the weak comparison
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConditionalLookups(t *testing.T) {
+	modified := time.Now().Add(-24 * time.Hour).Truncate(time.Second)
+	// newAnswer returns the registry answer, fetched age ago
+	newAnswer := func(baseURL string, age time.Duration) *upstreamResponse {
+		answer := newTestAnswer(baseURL, age)
+		answer.Header.Set("Last-Modified", modified.UTC().Format(http.TimeFormat))
+		return answer
+	}
+	etag := answerETag(newAnswer("", 0).Body)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		answer := newAnswer("", 0)
+		for name, values := range answer.Header {
+			w.Header()[name] = values
+		}
+		w.Write(answer.Body)
+	}))
+	defer upstream.Close()
+
+	cfg := newTestConfig()
+	cfg.Cache.StaleWhileRevalidate = time.Hour
+	s := newTestService(t, cfg, upstream.URL)
+	app := newTestApp(s)
+
+	seed := func(domain string, age time.Duration) {
+		l := newDomainLookup(domain)
+		require.NotNil(t, s.storeCached(context.Background(), l, l.key, newAnswer(upstream.URL, age)))
+	}
+	get := func(domain string, header map[string]string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, "/domain/"+domain, nil)
+		for name, value := range header {
+			req.Header.Set(name, value)
+		}
+		resp, err := app.Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	t.Run("Validators", func(t *testing.T) {
+		seed("example.com", time.Minute)
+		strong := strings.TrimPrefix(etag, "W/")
+
+		tests := []struct {
+			name   string
+			header map[string]string
+			status int
+		}{
+			{"No conditions", nil, http.StatusOK},
+			{"Weak If-None-Match", map[string]string{"If-None-Match": etag}, http.StatusNotModified},
+			{"Strong If-None-Match compares weakly", map[string]string{"If-None-Match": strong}, http.StatusNotModified},
+			{"If-None-Match list", map[string]string{"If-None-Match": `W/"other", ` + etag}, http.StatusNotModified},
+			{"If-None-Match wildcard", map[string]string{"If-None-Match": "*"}, http.StatusNotModified},
+			{"If-None-Match mismatch", map[string]string{"If-None-Match": `W/"other"`}, http.StatusOK},
+			{"If-Modified-Since unchanged", map[string]string{"If-Modified-Since": modified.UTC().Format(http.TimeFormat)}, http.StatusNotModified},
+			{"If-Modified-Since changed", map[string]string{"If-Modified-Since": modified.Add(-time.Hour).UTC().Format(http.TimeFormat)}, http.StatusOK},
+			{"If-Modified-Since unparsable", map[string]string{"If-Modified-Since": "yesterday"}, http.StatusOK},
+			{"If-None-Match takes precedence", map[string]string{
+				"If-None-Match":     `W/"other"`,
+				"If-Modified-Since": modified.UTC().Format(http.TimeFormat),
+			}, http.StatusOK},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				resp := get("example.com", tc.header)
+				assert.Equal(t, tc.status, resp.StatusCode)
+				assert.Equal(t, etag, resp.Header.Get("ETag"))
+				assert.Equal(t, modified.UTC().Format(http.TimeFormat), resp.Header.Get("Last-Modified"))
+			})
+		}
+	})
+
+	t.Run("Freshness", func(t *testing.T) {
+		seed("fresh.com", 100*time.Second)
+		seed("stale.com", 20*time.Minute)
+
+		tests := []struct {
+			name   string
+			domain string
+			cache  string
+			age    int
+		}{
+			{"Miss", "miss.com", CacheStatusMiss, 0},
+			{"Hit", "fresh.com", CacheStatusHit, 100},
+			{"Stale", "stale.com", CacheStatusStale, 1200},
+		}
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				resp := get(tc.domain, nil)
+				assert.Equal(t, http.StatusOK, resp.StatusCode)
+				assert.Equal(t, tc.cache, resp.Header.Get(HeaderCache))
+
+				age, err := strconv.Atoi(resp.Header.Get(HeaderAge))
+				require.NoError(t, err)
+				assert.InDelta(t, tc.age, age, 1)
+
+				// The answer stays fresh for its ten minutes counted from Age, which
+				// leaves nothing for a stale answer
+				maxAge, err := strconv.Atoi(strings.TrimPrefix(resp.Header.Get("Cache-Control"), "max-age="))
+				require.NoError(t, err)
+				assert.InDelta(t, max(600, tc.age), maxAge, 1)
+			})
+		}
+	})
+}
@@ -0,0 +1,195 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxHedgeTokens bounds the hedges that can be sent in a burst after a quiet period
+const maxHedgeTokens = 10
+
+// hedgeBudget limits hedged requests to a fraction of all lookups.
+// Every lookup deposits that fraction of a token and every hedge withdraws a whole one.
+type hedgeBudget struct {
+	mu     sync.Mutex
+	ratio  float64
+	tokens float64
+}
+
+func newHedgeBudget(ratio float64) *hedgeBudget {
+	return &hedgeBudget{ratio: ratio}
+}
+
+// Deposit credits the budget for one lookup
+func (b *hedgeBudget) Deposit() {
+	b.mu.Lock()
+	b.tokens += b.ratio
+	if b.tokens > maxHedgeTokens {
+		b.tokens = maxHedgeTokens
+	}
+	b.mu.Unlock()
+}
+
+// Withdraw reports whether a hedge may be sent, consuming budget if so
+func (b *hedgeBudget) Withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// upstreamURLs builds the query URLs for every bootstrap server and its configured mirrors,
+// in order of preference. Plain HTTP URLs are dropped when the same host is listed with HTTPS.
+func (s *RDAPService) upstreamURLs(servers []string, path string) []string {
+	secure := make(map[string]bool)
+	for _, server := range servers {
+		if u, err := url.Parse(server); err == nil && u.Scheme == "https" {
+			secure[u.Host] = true
+		}
+	}
+
+	seen := make(map[string]bool)
+	urls := make([]string, 0, len(servers))
+	add := func(base string) {
+		if !strings.HasSuffix(base, "/") {
+			base += "/"
+		}
+		if !seen[base] {
+			seen[base] = true
+			urls = append(urls, base+path)
+		}
+	}
+
+	for _, server := range servers {
+		u, err := url.Parse(server)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		if u.Scheme == "http" && secure[u.Host] {
+			continue
+		}
+		add(server)
+		upstream, _ := s.ServiceConfig.Upstream(u.Host)
+		for _, mirror := range upstream.Mirrors {
+			add(mirror)
+		}
+	}
+	return urls
+}
+
+// hedgeDelay returns how long to wait for a host before hedging,
+// based on the configured percentile of its recent latency
+func (s *RDAPService) hedgeDelay(host string) time.Duration {
+	cfg := s.ServiceConfig.Hedging
+	delay, ok := s.latency.Percentile(host, cfg.Percentile, cfg.MinSamples)
+	if !ok {
+		return cfg.MaxDelay
+	}
+	if delay < cfg.MinDelay {
+		return cfg.MinDelay
+	}
+	if delay > cfg.MaxDelay {
+		return cfg.MaxDelay
+	}
+	return delay
+}
+
+// hedgeResult is the outcome of one branch of a hedged lookup
+type hedgeResult struct {
+	resp  *upstreamResponse
+	err   error
+	hedge bool
+}
+
+// fetchHedged queries the first upstream URL. With hedging enabled, the same query is sent to
+// the next URL when the first has not answered in time or has failed; the first usable answer
+// wins and the other request is cancelled. A failover after the first URL has failed replaces
+// that request instead of adding one, so it does not draw on the hedge budget.
+//
+// header goes to every URL, so the validators of an answer cached from the primary are also
+// sent to its mirror or the next server. Mirrors serve the same registry data and a 304 from
+// one renews that answer; a server with other validators answers in full.
+func (s *RDAPService) fetchHedged(ctx context.Context, urls []string, header http.Header) (*upstreamResponse, error) {
+	if !s.ServiceConfig.Hedging.Enabled || len(urls) < 2 {
+		return s.fetch(ctx, urls[0], header)
+	}
+	s.hedges.Deposit()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgeResult, 2)
+	launch := func(url string, hedge bool) {
+		go func() {
+			resp, err := s.fetch(ctx, url, header)
+			results <- hedgeResult{resp: resp, err: err, hedge: hedge}
+		}()
+	}
+
+	primaryHost := hostFromURL(urls[0])
+	start := time.Now()
+	launch(urls[0], false)
+	pending := 1
+	hedged, primaryDone := false, false
+	hedge := func(result string) {
+		hedged = true
+		launch(urls[1], true)
+		pending++
+		upstreamHedges.WithLabelValues(primaryHost, result).Inc()
+	}
+
+	timer := time.NewTimer(s.hedgeDelay(primaryHost))
+	defer timer.Stop()
+
+	var last hedgeResult
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if hedged {
+				continue
+			}
+			if s.hedges.Withdraw() {
+				hedge("sent")
+			} else {
+				upstreamHedges.WithLabelValues(primaryHost, "budget_exhausted").Inc()
+			}
+		case r := <-results:
+			pending--
+			last = r
+			if !r.hedge {
+				primaryDone = true
+			}
+			if r.err == nil && r.resp.StatusCode < 500 {
+				if r.hedge && !primaryDone {
+					upstreamHedges.WithLabelValues(primaryHost, "won").Inc()
+					// The primary is cancelled without a response time. It has taken at
+					// least this long, and leaving it out would hide exactly the slow
+					// answers the hedge delay is derived from.
+					s.latency.Observe(primaryHost, time.Since(start))
+				}
+				return r.resp, nil
+			}
+			// A failed primary fails over at once rather than waiting for the hedge delay
+			if !hedged {
+				hedge("failover")
+			}
+		}
+	}
+	return last.resp, last.err
+}
+
+// hostFromURL returns the host of a URL, or an empty string when it cannot be parsed
+func hostFromURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
@@ -0,0 +1,183 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ohelal/rdap/internal/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLatencyPercentiles(t *testing.T) {
+	tracker := newLatencyTracker()
+	for i := 100; i >= 1; i-- {
+		tracker.Observe("rdap.example", time.Duration(i)*time.Millisecond)
+	}
+
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0, time.Millisecond},
+		{1, time.Millisecond},
+		{50, 50 * time.Millisecond},
+		{90, 90 * time.Millisecond},
+		{99.5, 100 * time.Millisecond},
+		{100, 100 * time.Millisecond},
+	}
+	for _, tc := range tests {
+		got, ok := tracker.Percentile("rdap.example", tc.p, 100)
+		assert.True(t, ok)
+		assert.Equal(t, tc.want, got, "p%v", tc.p)
+	}
+
+	_, ok := tracker.Percentile("rdap.example", 50, 101)
+	assert.False(t, ok, "too few samples")
+	_, ok = tracker.Percentile("unknown.example", 50, 0)
+	assert.False(t, ok, "unknown host")
+
+	// Only the most recent samples are kept
+	for i := 0; i < latencyWindowSize; i++ {
+		tracker.Observe("rdap.example", time.Second)
+	}
+	got, _ := tracker.Percentile("rdap.example", 1, latencyWindowSize)
+	assert.Equal(t, time.Second, got)
+}
+
+func TestHedgeDelay(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.Hedging = newTestHedging()
+	s := newTestService(t, cfg, "http://rdap.example")
+
+	assert.Equal(t, cfg.Hedging.MaxDelay, s.hedgeDelay("rdap.example"), "no samples yet")
+
+	observe := func(host string, d time.Duration) {
+		for i := 0; i < cfg.Hedging.MinSamples; i++ {
+			s.latency.Observe(host, d)
+		}
+	}
+	observe("fast.example", time.Millisecond)
+	observe("usual.example", 80*time.Millisecond)
+	observe("slow.example", time.Minute)
+	assert.Equal(t, cfg.Hedging.MinDelay, s.hedgeDelay("fast.example"))
+	assert.Equal(t, 80*time.Millisecond, s.hedgeDelay("usual.example"))
+	assert.Equal(t, cfg.Hedging.MaxDelay, s.hedgeDelay("slow.example"))
+}
+
+// newTestHedging enables hedging after 200ms without latency samples, with a budget of one
+// hedge per lookup
+func newTestHedging() config.HedgingConfig {
+	return config.HedgingConfig{
+		Enabled:    true,
+		Percentile: 95,
+		MinDelay:   10 * time.Millisecond,
+		MaxDelay:   200 * time.Millisecond,
+		MinSamples: 10,
+		Budget:     1,
+	}
+}
+
+// newTimedUpstream starts an RDAP server answering with status after delay, or when the
+// request is cancelled, counting the requests it receives
+func newTimedUpstream(t *testing.T, status int, delay time.Duration) (*httptest.Server, *int32) {
+	var requests int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		select {
+		case <-time.After(delay):
+		case <-r.Context().Done():
+			return
+		}
+		w.Header().Set("Content-Type", "application/rdap+json")
+		w.WriteHeader(status)
+		w.Write([]byte(`{"objectClassName":"domain"}`))
+	}))
+	t.Cleanup(upstream.Close)
+	return upstream, &requests
+}
+
+func TestHedgedRequests(t *testing.T) {
+	fetch := func(t *testing.T, budget float64, primary, mirror *httptest.Server) (*RDAPService, *upstreamResponse, time.Duration) {
+		cfg := newTestConfig()
+		cfg.Hedging = newTestHedging()
+		cfg.Hedging.Budget = budget
+		cfg.RDAP.MaxRetries = 1
+		cfg.Error.RetryableCodes = []int{http.StatusBadGateway, http.StatusServiceUnavailable}
+		s := newTestService(t, cfg, primary.URL)
+
+		start := time.Now()
+		resp, err := s.fetchHedged(context.Background(), []string{primary.URL + "/domain/example.com", mirror.URL + "/domain/example.com"}, nil)
+		require.NoError(t, err)
+		return s, resp, time.Since(start)
+	}
+	hedges := func(primary *httptest.Server, result string) float64 {
+		return testutil.ToFloat64(upstreamHedges.WithLabelValues(hostFromURL(primary.URL), result))
+	}
+
+	t.Run("A slow primary is hedged after the delay", func(t *testing.T) {
+		primary, _ := newTimedUpstream(t, http.StatusOK, 5*time.Second)
+		mirror, _ := newTimedUpstream(t, http.StatusOK, 0)
+
+		s, resp, elapsed := fetch(t, 1, primary, mirror)
+		assert.Equal(t, mirror.URL+"/domain/example.com", resp.URL)
+		assert.GreaterOrEqual(t, elapsed, 200*time.Millisecond)
+		assert.Less(t, elapsed, time.Second)
+		assert.Equal(t, 1.0, hedges(primary, "sent"))
+		assert.Equal(t, 1.0, hedges(primary, "won"))
+
+		// The cancelled primary still counts as at least as slow as the hedge delay
+		latency, ok := s.latency.Percentile(hostFromURL(primary.URL), 100, 1)
+		require.True(t, ok)
+		assert.GreaterOrEqual(t, latency, 200*time.Millisecond)
+	})
+
+	t.Run("A fast primary is not hedged", func(t *testing.T) {
+		primary, _ := newTimedUpstream(t, http.StatusOK, 0)
+		mirror, mirrorRequests := newTimedUpstream(t, http.StatusOK, 0)
+
+		_, resp, _ := fetch(t, 1, primary, mirror)
+		assert.Equal(t, primary.URL+"/domain/example.com", resp.URL)
+		assert.Equal(t, int32(0), atomic.LoadInt32(mirrorRequests))
+		assert.Equal(t, 0.0, hedges(primary, "sent"))
+	})
+
+	t.Run("No hedge is sent without budget", func(t *testing.T) {
+		primary, _ := newTimedUpstream(t, http.StatusOK, 400*time.Millisecond)
+		mirror, mirrorRequests := newTimedUpstream(t, http.StatusOK, 0)
+
+		_, resp, elapsed := fetch(t, 0.5, primary, mirror)
+		assert.Equal(t, primary.URL+"/domain/example.com", resp.URL)
+		assert.GreaterOrEqual(t, elapsed, 400*time.Millisecond)
+		assert.Equal(t, int32(0), atomic.LoadInt32(mirrorRequests))
+		assert.Equal(t, 1.0, hedges(primary, "budget_exhausted"))
+		assert.Equal(t, 0.0, hedges(primary, "sent"))
+	})
+
+	t.Run("A failing primary fails over at once, without budget", func(t *testing.T) {
+		primary, primaryRequests := newTimedUpstream(t, http.StatusServiceUnavailable, 0)
+		mirror, _ := newTimedUpstream(t, http.StatusOK, 0)
+
+		_, resp, elapsed := fetch(t, 0.5, primary, mirror)
+		assert.Equal(t, mirror.URL+"/domain/example.com", resp.URL)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Less(t, elapsed, 200*time.Millisecond)
+		assert.Equal(t, int32(2), atomic.LoadInt32(primaryRequests), "retried before failing over")
+		assert.Equal(t, 1.0, hedges(primary, "failover"))
+		assert.Equal(t, 0.0, hedges(primary, "sent"))
+		assert.Equal(t, 0.0, hedges(primary, "won"))
+	})
+
+	t.Run("Both failing returns the last answer", func(t *testing.T) {
+		primary, _ := newTimedUpstream(t, http.StatusServiceUnavailable, 0)
+		mirror, _ := newTimedUpstream(t, http.StatusBadGateway, 0)
+
+		_, resp, _ := fetch(t, 1, primary, mirror)
+		assert.Equal(t, http.StatusBadGateway, resp.StatusCode)
+	})
+}
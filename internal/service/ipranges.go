@@ -0,0 +1,122 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ohelal/rdap/internal/cache"
+)
+
+// ipNetwork holds the members of an RDAP ip network object that describe its address range
+type ipNetwork struct {
+	StartAddress string `json:"startAddress"`
+	EndAddress   string `json:"endAddress"`
+	CIDRs        []struct {
+		V4Prefix string `json:"v4prefix"`
+		V6Prefix string `json:"v6prefix"`
+		Length   int    `json:"length"`
+	} `json:"cidr0_cidrs"`
+}
+
+// ranges returns the address ranges of the network: the start and end address when present,
+// otherwise one range per cidr0 prefix
+func (n ipNetwork) ranges() []cache.IPRange {
+	start, err1 := netip.ParseAddr(n.StartAddress)
+	end, err2 := netip.ParseAddr(n.EndAddress)
+	if err1 == nil && err2 == nil {
+		if r, err := cache.NewIPRange(start, end); err == nil {
+			return []cache.IPRange{r}
+		}
+	}
+
+	var ranges []cache.IPRange
+	for _, cidr := range n.CIDRs {
+		prefix := cidr.V4Prefix
+		if prefix == "" {
+			prefix = cidr.V6Prefix
+		}
+		p, err := netip.ParsePrefix(prefix + "/" + strconv.Itoa(cidr.Length))
+		if err != nil {
+			continue
+		}
+		if r, err := cache.PrefixRange(p); err == nil {
+			ranges = append(ranges, r)
+		}
+	}
+	return ranges
+}
+
+// cachedNetwork returns the cache key of a cached network answer containing an IP address
+func (s *RDAPService) cachedNetwork(ctx context.Context, query string) (string, bool) {
+	if s.cache == nil || !s.ServiceConfig.Cache.IPRanges {
+		return "", false
+	}
+	ip, err := netip.ParseAddr(query)
+	if err != nil {
+		return "", false
+	}
+	key, ok := s.cache.Ranges().Lookup(ctx, ip)
+	if ok {
+		ipRangeHits.Inc()
+	}
+	return key, ok
+}
+
+// networkRanges returns the address ranges described by a successful IP lookup answer, or nil
+// when the answer lacks them or they do not cover the queried address
+func (s *RDAPService) networkRanges(l lookup, resp *upstreamResponse) []cache.IPRange {
+	if s.cache == nil || !s.ServiceConfig.Cache.IPRanges ||
+		l.objectType != cache.TypeIP || resp.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	var network ipNetwork
+	if err := json.Unmarshal(resp.Body, &network); err != nil {
+		return nil
+	}
+	ranges := network.ranges()
+
+	first, last, ok := queriedAddresses(strings.TrimPrefix(l.path, "ip/"))
+	if !ok {
+		return nil
+	}
+	for _, r := range ranges {
+		if r.Contains(first) && r.Contains(last) {
+			return ranges
+		}
+	}
+	return nil
+}
+
+// indexNetwork records the ranges of a cached network answer so later lookups of any address
+// inside them are answered from the cache
+func (s *RDAPService) indexNetwork(ctx context.Context, ranges []cache.IPRange, key string, until time.Time) {
+	for _, r := range ranges {
+		if err := s.cache.Ranges().Add(ctx, r, key, until); err != nil {
+			log.Printf("Failed to index IP range %s-%s: %v", r.Start, r.End, err)
+		}
+	}
+}
+
+// queriedAddresses returns the first and last address of an IP lookup, which is either a
+// single address or a CIDR prefix
+func queriedAddresses(query string) (netip.Addr, netip.Addr, bool) {
+	if ip, err := netip.ParseAddr(query); err == nil {
+		return ip, ip, true
+	}
+	p, err := netip.ParsePrefix(query)
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, false
+	}
+	r, err := cache.PrefixRange(p)
+	if err != nil {
+		return netip.Addr{}, netip.Addr{}, false
+	}
+	return r.Start, r.End, true
+}
@@ -0,0 +1,97 @@
+package service
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyWindowSize is the number of recent samples kept per upstream host
+const latencyWindowSize = 256
+
+// latencyTracker records recent upstream response times per host
+type latencyTracker struct {
+	mu    sync.Mutex
+	hosts map[string]*latencyWindow
+}
+
+// latencyWindow is a ring buffer of the most recent response times for one host
+type latencyWindow struct {
+	samples []time.Duration
+	next    int
+	total   uint64
+}
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{hosts: make(map[string]*latencyWindow)}
+}
+
+// Observe records a response time for a host
+func (t *latencyTracker) Observe(host string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	w, ok := t.hosts[host]
+	if !ok {
+		w = &latencyWindow{samples: make([]time.Duration, 0, latencyWindowSize)}
+		t.hosts[host] = w
+	}
+	if len(w.samples) < latencyWindowSize {
+		w.samples = append(w.samples, d)
+	} else {
+		w.samples[w.next] = d
+	}
+	w.next = (w.next + 1) % latencyWindowSize
+	w.total++
+}
+
+// Percentile returns the p-th percentile (0-100) of a host's recent response times.
+// It reports false while fewer than minSamples observations are available.
+func (t *latencyTracker) Percentile(host string, p float64, minSamples int) (time.Duration, bool) {
+	samples, _ := t.sorted(host)
+	if len(samples) == 0 || len(samples) < minSamples {
+		return 0, false
+	}
+	return percentileOf(samples, p), true
+}
+
+// Hosts returns the hosts that have recorded response times, sorted by name
+func (t *latencyTracker) Hosts() []string {
+	t.mu.Lock()
+	hosts := make([]string, 0, len(t.hosts))
+	for host := range t.hosts {
+		hosts = append(hosts, host)
+	}
+	t.mu.Unlock()
+	sort.Strings(hosts)
+	return hosts
+}
+
+// sorted returns a sorted copy of a host's recent response times and its total observation count
+func (t *latencyTracker) sorted(host string) ([]time.Duration, uint64) {
+	t.mu.Lock()
+	w, ok := t.hosts[host]
+	if !ok {
+		t.mu.Unlock()
+		return nil, 0
+	}
+	samples := append([]time.Duration(nil), w.samples...)
+	total := w.total
+	t.mu.Unlock()
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+	return samples, total
+}
+
+// percentileOf returns the p-th percentile of sorted, non-empty samples using the nearest-rank method
+func percentileOf(samples []time.Duration, p float64) time.Duration {
+	idx := int(math.Ceil(p/100*float64(len(samples)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(samples) {
+		idx = len(samples) - 1
+	}
+	return samples[idx]
+}
@@ -0,0 +1,74 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ohelal/rdap/internal/models"
+)
+
+// Response headers describing how a lookup was answered
+const (
+	HeaderUpstream = "X-RDAP-Upstream"
+	HeaderCache    = "X-Cache"
+	HeaderAttempts = "X-RDAP-Attempts"
+	HeaderAge      = "Age"
+	HeaderWarning  = "Warning"
+	HeaderStale    = "X-RDAP-Stale"
+)
+
+// Cache status values reported in the X-Cache header and the proxy notice
+const (
+	CacheStatusHit      = "HIT"
+	CacheStatusMiss     = "MISS"
+	CacheStatusStale    = "STALE"
+	CacheStatusNegative = "NEGATIVE"
+)
+
+// proxyNoticeTitle identifies the notice added to describe the proxy path
+const proxyNoticeTitle = "RDAP Proxy"
+
+// setMetadataHeaders adds the upstream URL, cache status, age and attempt count to the response
+func setMetadataHeaders(c *fiber.Ctx, resp *upstreamResponse, cacheStatus string) {
+	if resp.URL != "" {
+		c.Set(HeaderUpstream, resp.URL)
+	}
+	c.Set(HeaderCache, cacheStatus)
+	c.Set(HeaderAge, strconv.Itoa(responseAge(resp)))
+	c.Set(HeaderAttempts, strconv.Itoa(resp.Attempts))
+}
+
+// responseAge returns the number of whole seconds since the upstream answer was fetched
+func responseAge(resp *upstreamResponse) int {
+	age := int(time.Since(resp.FetchedAt) / time.Second)
+	if age < 0 {
+		return 0
+	}
+	return age
+}
+
+// proxyNotice builds an RDAP notice describing which registry produced an answer and how it was served
+func proxyNotice(resp *upstreamResponse, cacheStatus string) *models.Notice {
+	return &models.Notice{
+		Title: proxyNoticeTitle,
+		Description: []string{
+			fmt.Sprintf("This response was retrieved from %s by an RDAP proxy.", resp.URL),
+			fmt.Sprintf("Retrieved at %s after %d attempt(s); cache status %s.",
+				resp.FetchedAt.UTC().Format(time.RFC3339), resp.Attempts, cacheStatus),
+		},
+		Links: []*models.Link{{
+			Value: resp.URL,
+			Rel:   "related",
+			Href:  resp.URL,
+			Type:  "application/rdap+json",
+		}},
+	}
+}
+
+// addNotice appends a notice to the notices array of a decoded RDAP response
+func addNotice(data map[string]interface{}, notice *models.Notice) {
+	notices, _ := data["notices"].([]interface{})
+	data["notices"] = append(notices, notice)
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/ohelal/rdap/internal/config"
+	"github.com/ohelal/rdap/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetadata(t *testing.T) {
+	// The first request of every lookup fails, so each answer takes two attempts
+	var requests int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1)%2 == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/rdap+json")
+		w.Write([]byte(`{"objectClassName":"domain","ldhName":"example.com"}`))
+	}))
+	defer upstream.Close()
+
+	lookup := func(t *testing.T, metadata config.MetadataConfig) (*http.Response, models.RDAPResponse) {
+		cfg := newTestConfig()
+		cfg.RDAP.MaxRetries = 1
+		cfg.Error.RetryableCodes = []int{http.StatusServiceUnavailable}
+		cfg.Metadata = metadata
+
+		resp, err := newTestApp(newTestService(t, cfg, upstream.URL)).Test(httptest.NewRequest(http.MethodGet, "/domain/example.com", nil))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+		var body models.RDAPResponse
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return resp, body
+	}
+
+	t.Run("Headers", func(t *testing.T) {
+		resp, body := lookup(t, config.MetadataConfig{ResponseHeaders: true})
+		assert.Equal(t, upstream.URL+"/domain/example.com", resp.Header.Get(HeaderUpstream))
+		assert.Equal(t, CacheStatusMiss, resp.Header.Get(HeaderCache))
+		assert.Equal(t, "0", resp.Header.Get(HeaderAge))
+		assert.Equal(t, "2", resp.Header.Get(HeaderAttempts))
+		assert.Empty(t, body.Notices)
+	})
+
+	t.Run("Notice", func(t *testing.T) {
+		resp, body := lookup(t, config.MetadataConfig{Notice: true})
+		assert.Empty(t, resp.Header.Get(HeaderUpstream))
+		assert.Empty(t, resp.Header.Get(HeaderAttempts))
+		require.Len(t, body.Notices, 1)
+		assert.Equal(t, proxyNoticeTitle, body.Notices[0].Title)
+		assert.Contains(t, body.Notices[0].Description[0], upstream.URL+"/domain/example.com")
+		assert.Contains(t, body.Notices[0].Description[1], "after 2 attempt(s); cache status MISS")
+	})
+}
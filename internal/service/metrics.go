@@ -0,0 +1,143 @@
+package service
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	upstreamDialDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "rdap_upstream_dial_duration_seconds",
+			Help:    "Time spent establishing TCP connections to upstream RDAP servers",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 12),
+		},
+		[]string{"host"},
+	)
+
+	upstreamTLSDuration = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "rdap_upstream_tls_handshake_duration_seconds",
+			Help:    "Time spent in TLS handshakes with upstream RDAP servers",
+			Buckets: prometheus.ExponentialBuckets(0.001, 2, 12),
+		},
+		[]string{"host"},
+	)
+
+	upstreamTTFB = promauto.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Name:    "rdap_upstream_ttfb_seconds",
+			Help:    "Time from sending a request to receiving the first response byte from upstream RDAP servers",
+			Buckets: prometheus.ExponentialBuckets(0.005, 2, 12),
+		},
+		[]string{"host"},
+	)
+
+	upstreamConnections = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rdap_upstream_connections_total",
+			Help: "Connections used for upstream requests by whether they were reused",
+		},
+		[]string{"host", "reused"},
+	)
+
+	upstreamHedges = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rdap_upstream_hedges_total",
+			Help: "Hedged upstream requests by primary host and result (sent, won, budget_exhausted, failover)",
+		},
+		[]string{"host", "result"},
+	)
+
+	upstreamTimeoutSeconds = promauto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "rdap_upstream_timeout_seconds",
+			Help: "Current request timeout for each upstream host, learned from observed latency when adaptive timeouts are enabled",
+		},
+		[]string{"host"},
+	)
+
+	staleResponses = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rdap_cache_stale_responses_total",
+			Help: "Stale cached answers served, by reason (revalidating, upstream-error)",
+		},
+		[]string{"reason"},
+	)
+
+	cacheRefreshes = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rdap_cache_background_refreshes_total",
+			Help: "Background refreshes of stale cache entries by result",
+		},
+		[]string{"result"},
+	)
+
+	negativeCacheHits = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rdap_negative_cache_hits_total",
+			Help: "Lookups answered from cached not-found or invalid answers, by object type",
+		},
+		[]string{"type"},
+	)
+
+	negativeCacheStores = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rdap_negative_cache_stores_total",
+			Help: "Not-found or invalid answers stored in the negative cache, by object type and source (upstream, bootstrap)",
+		},
+		[]string{"type", "source"},
+	)
+
+	ipRangeHits = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "rdap_ip_range_hits_total",
+			Help: "IP lookups resolved to a cached network containing the address",
+		},
+	)
+
+	warmupKeys = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rdap_cache_warmup_keys_total",
+			Help: "Keys processed by cache warm-up, by result (cached, refreshed, failed, skipped)",
+		},
+		[]string{"result"},
+	)
+
+	warmupProgress = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "rdap_cache_warmup_progress_ratio",
+			Help: "Fraction of the keys of the current or last cache warm-up that have been processed",
+		},
+	)
+
+	warmupDuration = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "rdap_cache_warmup_duration_seconds",
+			Help: "Duration of the last completed cache warm-up",
+		},
+	)
+
+	upstreamRevalidations = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rdap_upstream_revalidations_total",
+			Help: "Conditional refreshes of cached answers by result: not_modified when the upstream answered 304 and the body was not downloaded, modified otherwise",
+		},
+		[]string{"result"},
+	)
+
+	revalidationSavedBytes = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "rdap_upstream_revalidation_saved_bytes_total",
+			Help: "Bytes of cached answers confirmed unchanged by upstream servers instead of being downloaded again",
+		},
+	)
+
+	notModifiedResponses = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "rdap_not_modified_responses_total",
+			Help: "Conditional lookups answered with 304 Not Modified, by cache status",
+		},
+		[]string{"cache"},
+	)
+)
@@ -1,15 +1,23 @@
 package service
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
 	"fmt"
 	"github.com/gofiber/fiber/v2"
+	"github.com/ohelal/rdap/internal/cache"
+	"github.com/ohelal/rdap/internal/coalescing"
 	"github.com/ohelal/rdap/internal/config"
-	"io/ioutil"
+	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // RDAPService represents the main service structure
@@ -19,11 +27,21 @@ type RDAPService struct {
 	ASNConfig     *RDAPBootstrapConfig
 	ServiceConfig *config.Config
 	client        *http.Client
+	transports    *transportPool
+	redaction     *redactionPolicy
+	latency       *latencyTracker
+	hedges        *hedgeBudget
+	cache         *cache.CacheManager
+	fetches       *coalescing.Group[string, *upstreamResponse]
+	leases        *coalescing.Leases
+	refreshing    sync.Map
+	warming       atomic.Bool
 	mu            sync.Mutex
 }
 
-// NewRDAPService creates a new RDAP service instance
-func NewRDAPService(dnsConfig, ipConfig, asnConfig *RDAPBootstrapConfig, serviceConfig *config.Config) (*RDAPService, error) {
+// NewRDAPService creates a new RDAP service instance. Lookups are answered from cacheManager
+// when it is non-nil.
+func NewRDAPService(dnsConfig, ipConfig, asnConfig *RDAPBootstrapConfig, serviceConfig *config.Config, cacheManager *cache.CacheManager) (*RDAPService, error) {
 	if dnsConfig == nil || ipConfig == nil || asnConfig == nil {
 		return nil, fmt.Errorf("all bootstrap configs must be non-nil")
 	}
@@ -31,19 +49,29 @@ func NewRDAPService(dnsConfig, ipConfig, asnConfig *RDAPBootstrapConfig, service
 		return nil, fmt.Errorf("service config must be non-nil")
 	}
 
+	transports, err := newTransportPool(serviceConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to configure upstream transports: %v", err)
+	}
+
 	return &RDAPService{
 		DNSConfig:     dnsConfig,
 		IPConfig:      ipConfig,
 		ASNConfig:     asnConfig,
 		ServiceConfig: serviceConfig,
-		client: &http.Client{
-			Timeout: serviceConfig.RDAP.Timeout,
-		},
+		// Each request carries its own deadline from upstreamTimeout
+		client:     &http.Client{Transport: transports},
+		transports: transports,
+		redaction:  newRedactionPolicy(serviceConfig.Redaction),
+		latency:    newLatencyTracker(),
+		hedges:     newHedgeBudget(serviceConfig.Hedging.Budget),
+		cache:      cacheManager,
+		fetches:    coalescing.NewGroup[string, *upstreamResponse]("upstream"),
 	}, nil
 }
 
-// findRDAPServerForASN finds the correct RDAP server for an ASN range
-func (s *RDAPService) findRDAPServerForASN(asn int64) string {
+// findRDAPServersForASN finds the RDAP servers for an ASN range
+func (s *RDAPService) findRDAPServersForASN(asn int64) []string {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -68,25 +96,23 @@ func (s *RDAPService) findRDAPServerForASN(asn int64) string {
 					start, err1 := strconv.ParseInt(parts[0], 10, 64)
 					end, err2 := strconv.ParseInt(parts[1], 10, 64)
 					if err1 == nil && err2 == nil && asn >= start && asn <= end {
-						if server, ok := servers[0].(string); ok {
-							return server
-						}
+						return stringValues(servers)
 					}
 				}
 			}
 		}
 	}
-	return ""
+	return nil
 }
 
-// findRDAPServerForIP finds the correct RDAP server for an IP range
-func (s *RDAPService) findRDAPServerForIP(ipStr string) string {
+// findRDAPServersForIP finds the RDAP servers for an IP range
+func (s *RDAPService) findRDAPServersForIP(ipStr string) []string {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	ip := net.ParseIP(ipStr)
 	if ip == nil {
-		return ""
+		return nil
 	}
 
 	for _, service := range s.IPConfig.Services {
@@ -107,18 +133,16 @@ func (s *RDAPService) findRDAPServerForIP(ipStr string) string {
 				}
 				_, ipnet, err := net.ParseCIDR(cidr)
 				if err == nil && ipnet.Contains(ip) {
-					if server, ok := servers[0].(string); ok {
-						return server
-					}
+					return stringValues(servers)
 				}
 			}
 		}
 	}
-	return ""
+	return nil
 }
 
-// findRDAPServerForTLD finds the correct RDAP server for a TLD
-func (s *RDAPService) findRDAPServerForTLD(tld string) string {
+// findRDAPServersForTLD finds the RDAP servers for a TLD
+func (s *RDAPService) findRDAPServersForTLD(tld string) []string {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -140,61 +164,308 @@ func (s *RDAPService) findRDAPServerForTLD(tld string) string {
 					continue
 				}
 				if strings.ToLower(domain) == tld {
-					if server, ok := servers[0].(string); ok {
-						return server
-					}
+					return stringValues(servers)
 				}
 			}
 		}
 	}
-	return ""
+	return nil
+}
+
+// upstreamResponse holds an answer received from an upstream RDAP server
+type upstreamResponse struct {
+	URL        string
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Attempts   int
+	FetchedAt  time.Time
+	// ContentEncoding is set when Body is sent to the client compressed as it is
+	ContentEncoding string
+	// ETag is the entity tag of the answer, computed from Body when empty
+	ETag string
+	// ExpiresAt is when the cached copy of the answer stops being fresh, or zero when the
+	// answer was not cached
+	ExpiresAt time.Time
 }
 
-// forwardRequest handles the common logic for forwarding requests to RDAP servers
-func (s *RDAPService) forwardRequest(c *fiber.Ctx, url string) error {
-	resp, err := s.client.Get(url)
+// fetch queries an upstream RDAP server, retrying transport errors and retryable status codes.
+// header holds additional request headers, such as the validators of a cached answer.
+func (s *RDAPService) fetch(ctx context.Context, url string, header http.Header) (*upstreamResponse, error) {
+	maxAttempts := s.ServiceConfig.RDAP.MaxRetries + 1
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	var lastErr error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(s.ServiceConfig.RDAP.RetryDelay):
+			}
+		}
+
+		resp, err := s.fetchOnce(ctx, url, header)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp.Attempts = attempt
+		if attempt < maxAttempts && s.ServiceConfig.Error.IsRetryableCode(resp.StatusCode) {
+			lastErr = fmt.Errorf("upstream returned status %d", resp.StatusCode)
+			continue
+		}
+		return resp, nil
+	}
+
+	return nil, fmt.Errorf("after %d attempts: %w", maxAttempts, lastErr)
+}
+
+func (s *RDAPService) fetchOnce(ctx context.Context, url string, header http.Header) (*upstreamResponse, error) {
+	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
-		return c.Status(500).JSON(fiber.Map{
-			"errorCode":   500,
-			"title":       "RDAP Server Error",
-			"description": []string{err.Error()},
-		})
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Accept", "application/rdap+json")
+
+	host := req.URL.Host
+	timeout := s.upstreamTimeout(host)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	req = req.WithContext(ctx)
+
+	start := time.Now()
+	resp, err := s.client.Do(req)
+	if err != nil {
+		s.observeTimeout(ctx, host, timeout, err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		s.observeTimeout(ctx, host, timeout, err)
+		return nil, err
+	}
+	s.latency.Observe(host, time.Since(start))
+
+	return &upstreamResponse{
+		URL:        url,
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       body,
+		FetchedAt:  time.Now(),
+	}, nil
+}
+
+// lookup describes an RDAP query, the cache key it is stored under and the servers that answer it
+type lookup struct {
+	objectType string
+	key        string
+	path       string
+	servers    []string
+}
+
+// forwardRequest handles the common logic for forwarding requests to RDAP servers.
+// A stale cache entry is served at once while it is refreshed in the background, or
+// instead of an upstream failure, for as long as the configured grace periods allow.
+func (s *RDAPService) forwardRequest(c *fiber.Ctx, l lookup, stale *cache.Entry) error {
+	now := time.Now()
+	if stale != nil && stale.Staleness(now) <= s.ServiceConfig.Cache.StaleWhileRevalidate {
+		s.refreshInBackground(l, stale)
+		return s.writeStale(c, stale, staleRevalidating)
+	}
+
+	// The fetch may outlive this request when it is shared, so it must not hold on to the
+	// fasthttp context, which is reused once the handler returns. The lookup stops waiting
+	// once the server could no longer write its answer, and the shared fetch is abandoned
+	// when no other lookup is waiting for it.
+	ctx, cancel := s.lookupContext(c)
+	defer cancel()
+	resp, err := s.fetchShared(ctx, l, stale)
+	if err != nil || resp.StatusCode >= 500 {
+		if stale != nil && stale.Staleness(now) <= s.ServiceConfig.Cache.StaleIfError {
+			return s.writeStale(c, stale, staleUpstreamError)
+		}
+	}
 	if err != nil {
 		return c.Status(500).JSON(fiber.Map{
 			"errorCode":   500,
-			"title":       "Response Error",
+			"title":       "RDAP Server Error",
 			"description": []string{err.Error()},
 		})
 	}
 
+	return s.writeResponse(c, resp, CacheStatusMiss)
+}
+
+// lookupContext returns the context bounding a client lookup, which ends with the server's
+// write timeout
+func (s *RDAPService) lookupContext(c *fiber.Ctx) (context.Context, context.CancelFunc) {
+	if timeout := s.ServiceConfig.Server.WriteTimeout; timeout > 0 {
+		return context.WithTimeout(c.UserContext(), timeout)
+	}
+	return context.WithCancel(c.UserContext())
+}
+
+// fetchShared runs fetchAndStore for a lookup, sharing a single upstream request between all
+// concurrent lookups of the same key, and between replicas when distributed coalescing is
+// enabled. The response returned must not be modified.
+func (s *RDAPService) fetchShared(ctx context.Context, l lookup, cached *cache.Entry) (*upstreamResponse, error) {
+	resp, _, err := s.fetches.Do(ctx, l.key, func(ctx context.Context) (*upstreamResponse, error) {
+		if s.leases != nil {
+			return s.fetchLeased(ctx, l, cached)
+		}
+		return s.fetchAndStore(ctx, l, cached)
+	})
+	return resp, err
+}
+
+// fetchAndStore queries the upstream servers for a lookup, applies the redaction policy
+// and stores successful answers in the cache. When a cached answer is given, the request is
+// conditional on its validators, and an answer the upstream reports unchanged is stored again
+// for a new lifetime without downloading it.
+func (s *RDAPService) fetchAndStore(ctx context.Context, l lookup, cached *cache.Entry) (*upstreamResponse, error) {
+	urls := s.upstreamURLs(l.servers, l.path)
+	if len(urls) == 0 {
+		return nil, fmt.Errorf("no usable RDAP server URL in bootstrap data")
+	}
+
+	validators := conditionalHeader(cached)
+	resp, err := s.fetchHedged(ctx, urls, validators)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusNotModified && validators != nil {
+		if resp, err = notModified(cached, resp); err != nil {
+			return nil, err
+		}
+		upstreamRevalidations.WithLabelValues("not_modified").Inc()
+		revalidationSavedBytes.Add(float64(len(resp.Body)))
+	} else {
+		if validators != nil {
+			upstreamRevalidations.WithLabelValues("modified").Inc()
+		}
+		if resp.StatusCode == http.StatusOK && s.redaction != nil {
+			resp.Body = rewriteJSON(resp.Body, s.redaction.Apply)
+		}
+	}
+	if isNegative(resp.StatusCode) {
+		if entry := s.storeNegative(ctx, l, resp, negativeSourceUpstream); entry != nil {
+			resp.ExpiresAt = entry.ExpiresAt
+		}
+	} else {
+		key, ranges := l.key, s.networkRanges(l, resp)
+		if len(ranges) > 0 {
+			key = cache.NetworkKey(ranges[0])
+		}
+		if entry := s.storeCached(ctx, l, key, resp); entry != nil {
+			resp.ETag, resp.ExpiresAt = entry.Metadata[metaETag], entry.ExpiresAt
+			s.indexNetwork(ctx, ranges, key, entry.StaleUntil)
+		}
+	}
+	return resp, nil
+}
+
+// writeResponse sends an upstream answer to the client along with the configured proxy metadata,
+// its validators and a Cache-Control header reflecting how long it remains fresh. A client
+// that already holds a successful answer is sent 304 Not Modified instead.
+func (s *RDAPService) writeResponse(c *fiber.Ctx, resp *upstreamResponse, cacheStatus string) error {
+	age := 0
+	if s.ServiceConfig.Metadata.ResponseHeaders {
+		setMetadataHeaders(c, resp, cacheStatus)
+		age = responseAge(resp)
+	}
+	if cc := cacheControl(resp, age, time.Now()); cc != "" {
+		c.Set(fiber.HeaderCacheControl, cc)
+	}
+	if resp.StatusCode == http.StatusOK {
+		etag := resp.ETag
+		if etag == "" {
+			etag = answerETag(resp.Body)
+		}
+		modified := lastModified(resp)
+		c.Set(fiber.HeaderETag, etag)
+		c.Set(fiber.HeaderLastModified, modified.UTC().Format(http.TimeFormat))
+		if requestNotModified(c, etag, modified) {
+			notModifiedResponses.WithLabelValues(cacheStatus).Inc()
+			return c.SendStatus(http.StatusNotModified)
+		}
+	}
+
+	body := resp.Body
+	if resp.StatusCode == http.StatusOK && s.ServiceConfig.Metadata.Notice {
+		body = rewriteJSON(body, func(data map[string]interface{}) bool {
+			addNotice(data, proxyNotice(resp, cacheStatus))
+			return true
+		})
+	}
 	c.Set("Content-Type", resp.Header.Get("Content-Type"))
+	if resp.ContentEncoding != "" {
+		c.Set(fiber.HeaderContentEncoding, resp.ContentEncoding)
+		c.Vary(fiber.HeaderAcceptEncoding)
+	}
 	return c.Status(resp.StatusCode).Send(body)
 }
 
+// rewriteJSON decodes an RDAP response, lets fn modify it and re-encodes it.
+// The original body is returned when it is not a JSON object or fn changed nothing.
+func rewriteJSON(body []byte, fn func(map[string]interface{}) bool) []byte {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var data map[string]interface{}
+	if err := decoder.Decode(&data); err != nil || data == nil {
+		return body
+	}
+	if !fn(data) {
+		return body
+	}
+
+	rewritten, err := json.Marshal(data)
+	if err != nil {
+		return body
+	}
+	return rewritten
+}
+
 // HandleIPLookup handles IP lookup requests
 func (s *RDAPService) HandleIPLookup(c *fiber.Ctx) error {
 	ip := c.Params("ip")
-	rdapServer := s.findRDAPServerForIP(ip)
-	if rdapServer == "" {
-		return c.Status(404).JSON(fiber.Map{
-			"errorCode":   404,
-			"title":       "IP Not Found",
-			"description": []string{"No RDAP server found for IP: " + ip},
-		})
+	l := lookup{objectType: cache.TypeIP, key: cache.Key(cache.TypeIP, ip), path: "ip/" + ip}
+	if key, ok := s.cachedNetwork(c.Context(), ip); ok {
+		l.key = key
+	}
+	stale, served, err := s.serveCached(c, l)
+	if served {
+		return err
 	}
 
-	return s.forwardRequest(c, rdapServer+"ip/"+ip)
+	l.servers = s.findRDAPServersForIP(ip)
+	if len(l.servers) == 0 {
+		return s.notFound(c, l, "IP Not Found", "No RDAP server found for IP: "+ip)
+	}
+
+	return s.forwardRequest(c, l, stale)
 }
 
 // HandleDomainLookup handles domain lookup requests
 func (s *RDAPService) HandleDomainLookup(c *fiber.Ctx) error {
-	domain := strings.ToLower(c.Params("domain"))
+	// Internationalized names arrive percent-encoded. The cache key, the TLD and the upstream
+	// path all use the canonical name, so "Example.COM." is looked up as "example.com".
+	domain, err := url.PathUnescape(c.Params("domain"))
+	domain = cache.Canonical(cache.TypeDomain, domain)
 	parts := strings.Split(domain, ".")
-	if len(parts) < 2 {
+	if err != nil || len(parts) < 2 {
 		return c.Status(400).JSON(fiber.Map{
 			"errorCode":   400,
 			"title":       "Invalid Domain",
@@ -202,21 +473,19 @@ func (s *RDAPService) HandleDomainLookup(c *fiber.Ctx) error {
 		})
 	}
 
-	tld := parts[len(parts)-1]
-	rdapServer := s.findRDAPServerForTLD(tld)
-	if rdapServer == "" {
-		return c.Status(404).JSON(fiber.Map{
-			"errorCode":   404,
-			"title":       "TLD Not Found",
-			"description": []string{"No RDAP server found for TLD: " + tld},
-		})
+	l := lookup{objectType: cache.TypeDomain, key: cache.Key(cache.TypeDomain, domain), path: "domain/" + domain}
+	stale, served, err := s.serveCached(c, l)
+	if served {
+		return err
 	}
 
-	if !strings.HasSuffix(rdapServer, "/") {
-		rdapServer += "/"
+	tld := parts[len(parts)-1]
+	l.servers = s.findRDAPServersForTLD(tld)
+	if len(l.servers) == 0 {
+		return s.notFound(c, l, "TLD Not Found", "No RDAP server found for TLD: "+tld)
 	}
 
-	return s.forwardRequest(c, rdapServer+"domain/"+domain)
+	return s.forwardRequest(c, l, stale)
 }
 
 // HandleASNLookup handles ASN lookup requests
@@ -231,20 +500,30 @@ func (s *RDAPService) HandleASNLookup(c *fiber.Ctx) error {
 		})
 	}
 
-	rdapServer := s.findRDAPServerForASN(asn)
-	if rdapServer == "" {
-		return c.Status(404).JSON(fiber.Map{
-			"errorCode":   404,
-			"title":       "ASN Not Found",
-			"description": []string{"No RDAP server found for ASN: " + asnStr},
-		})
+	l := lookup{objectType: cache.TypeAutnum, key: cache.Key(cache.TypeAutnum, asnStr), path: "autnum/" + asnStr}
+	stale, served, err := s.serveCached(c, l)
+	if served {
+		return err
 	}
 
-	return s.forwardRequest(c, rdapServer+"autnum/"+asnStr)
+	l.servers = s.findRDAPServersForASN(asn)
+	if len(l.servers) == 0 {
+		return s.notFound(c, l, "ASN Not Found", "No RDAP server found for ASN: "+asnStr)
+	}
+
+	return s.forwardRequest(c, l, stale)
 }
 
-// ReloadConfigs reloads all configurations
-func (s *RDAPService) ReloadConfigs() error {
-	// TODO: Implement config reloading logic
-	return nil
+// ReloadTransports re-reads the configuration file and rebuilds the upstream transports,
+// picking up changed CA bundles, client certificates, per-upstream TLS settings, credentials
+// and proxies. Every other setting keeps the value it had at startup.
+func (s *RDAPService) ReloadTransports() error {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return err
+	}
+	if err := cfg.Validate(); err != nil {
+		return fmt.Errorf("invalid configuration: %v", err)
+	}
+	return s.transports.Reload(cfg)
 }
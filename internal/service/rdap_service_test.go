@@ -0,0 +1,82 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ohelal/rdap/internal/cache"
+	"github.com/ohelal/rdap/internal/config"
+	"github.com/ohelal/rdap/internal/middleware"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestConfig returns a configuration for tests that does not depend on config files or
+// the environment; tests enable the features they exercise
+func newTestConfig() *config.Config {
+	return &config.Config{
+		RDAP:     config.RDAPConfig{Timeout: 5 * time.Second},
+		Metadata: config.MetadataConfig{ResponseHeaders: true},
+		Cache: config.CacheConfig{
+			Domain: config.CacheTTLConfig{MinTTL: time.Minute, MaxTTL: time.Hour, DefaultTTL: time.Hour},
+			IP:     config.CacheTTLConfig{MinTTL: time.Minute, MaxTTL: time.Hour, DefaultTTL: time.Hour},
+			Autnum: config.CacheTTLConfig{MinTTL: time.Minute, MaxTTL: time.Hour, DefaultTTL: time.Hour},
+		},
+	}
+}
+
+// newTestService creates a service with a local cache that sends lookups of .com domains, IP
+// addresses and AS numbers to the registry at baseURL
+func newTestService(t *testing.T, cfg *config.Config, baseURL string) *RDAPService {
+	return newTestServiceWithCache(t, cfg, baseURL, &cache.CacheConfig{})
+}
+
+// newTestServiceWithCache creates a service like newTestService with the cache tiers of
+// cacheCfg, whose local tier is sized and limited as in newTestService
+func newTestServiceWithCache(t *testing.T, cfg *config.Config, baseURL string, cacheCfg *cache.CacheConfig) *RDAPService {
+	cacheCfg.MaxLocalSize, cacheCfg.LocalTTL = cache.MinLocalSize, time.Hour
+	cm, err := cache.NewCacheManager(cacheCfg)
+	require.NoError(t, err)
+	t.Cleanup(func() { cm.Close() })
+
+	dns := &RDAPBootstrapConfig{Services: [][]interface{}{
+		{[]interface{}{"com"}, []interface{}{baseURL + "/"}},
+	}}
+	ip := &RDAPBootstrapConfig{Services: [][]interface{}{
+		{[]interface{}{"0.0.0.0/0", "::/0"}, []interface{}{baseURL + "/"}},
+	}}
+	asn := &RDAPBootstrapConfig{Services: [][]interface{}{
+		{[]interface{}{"1-4294967295"}, []interface{}{baseURL + "/"}},
+	}}
+	s, err := NewRDAPService(dns, ip, asn, cfg, cm)
+	require.NoError(t, err)
+	return s
+}
+
+// newDomainLookup returns the lookup of a domain name as the lookup handler builds it
+func newDomainLookup(domain string) lookup {
+	return lookup{objectType: cache.TypeDomain, key: cache.Key(cache.TypeDomain, domain), path: "domain/" + domain}
+}
+
+// newTestApp serves the lookup handlers of a service
+func newTestApp(s *RDAPService) *fiber.App {
+	app := fiber.New()
+	app.Get("/domain/:domain", s.HandleDomainLookup)
+	app.Get("/autnum/:asn", s.HandleASNLookup)
+	app.Get("/ip/:ip", s.HandleIPLookup)
+	return app
+}
+
+// testAdminToken is the bearer token of the admin API served by newTestAdminApp
+const testAdminToken = "admin-token"
+
+// newTestAdminApp serves the admin API of a service behind the admin token middleware, as the
+// server does
+func newTestAdminApp(s *RDAPService, enabled bool) *fiber.App {
+	app := fiber.New()
+	admin := app.Group("/admin", middleware.AdminAuth(config.AdminConfig{Enabled: enabled, Token: testAdminToken}))
+	admin.Get("/upstreams", s.HandleUpstreamStats)
+	admin.Get("/cache/export", s.HandleCacheExport)
+	admin.Post("/cache/import", s.HandleCacheImport)
+	return app
+}
@@ -0,0 +1,217 @@
+package service
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/ohelal/rdap/internal/config"
+	"github.com/ohelal/rdap/internal/models"
+)
+
+// vcardFieldLabels maps vCard property names to the labels used in redaction names
+var vcardFieldLabels = map[string]string{
+	"fn":          "Name",
+	"org":         "Organization",
+	"adr":         "Address",
+	"tel":         "Phone",
+	"email":       "Email",
+	"contact-uri": "Contact URI",
+}
+
+// redactionPolicy strips configured vCard properties from entities and
+// describes every stripped field in an RFC 9537 redacted array
+type redactionPolicy struct {
+	roles       map[string]bool
+	fields      map[string]bool
+	method      string
+	reason      string
+	replacement string
+}
+
+// newRedactionPolicy builds a redaction policy, returning nil when redaction is disabled
+func newRedactionPolicy(cfg config.RedactionConfig) *redactionPolicy {
+	if !cfg.Enabled || len(cfg.Fields) == 0 {
+		return nil
+	}
+
+	p := &redactionPolicy{
+		roles:       make(map[string]bool, len(cfg.Roles)),
+		fields:      make(map[string]bool, len(cfg.Fields)),
+		method:      cfg.Method,
+		reason:      cfg.Reason,
+		replacement: cfg.Replacement,
+	}
+	if p.method == "" {
+		p.method = models.RedactionMethodRemoval
+	}
+	for _, role := range cfg.Roles {
+		p.roles[strings.ToLower(role)] = true
+	}
+	for _, field := range cfg.Fields {
+		p.fields[strings.ToLower(field)] = true
+	}
+	return p
+}
+
+// Apply redacts a decoded RDAP response in place and reports whether anything was redacted
+func (p *redactionPolicy) Apply(data map[string]interface{}) bool {
+	seen := make(map[string]bool)
+	var added []*models.Redacted
+	p.redactEntities(data, "$", seen, &added)
+	if len(added) == 0 {
+		return false
+	}
+
+	redacted, _ := data["redacted"].([]interface{})
+	for _, r := range added {
+		redacted = append(redacted, r)
+	}
+	data["redacted"] = redacted
+	addConformance(data, models.RedactedConformance)
+	return true
+}
+
+func (p *redactionPolicy) redactEntities(obj map[string]interface{}, path string, seen map[string]bool, added *[]*models.Redacted) {
+	entities, ok := obj["entities"].([]interface{})
+	if !ok {
+		return
+	}
+
+	for _, e := range entities {
+		entity, ok := e.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		roles := stringValues(entity["roles"])
+		if len(roles) == 0 {
+			continue
+		}
+
+		// RFC 9537 identifies entities by their first role
+		entityPath := fmt.Sprintf("%s.entities[?(@.roles[0]=='%s')]", path, roles[0])
+		if p.matchesRole(roles) {
+			p.redactVCard(entity, entityPath, roles[0], seen, added)
+		}
+		p.redactEntities(entity, entityPath, seen, added)
+	}
+}
+
+func (p *redactionPolicy) matchesRole(roles []string) bool {
+	if len(p.roles) == 0 {
+		return true
+	}
+	for _, role := range roles {
+		if p.roles[strings.ToLower(role)] {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *redactionPolicy) redactVCard(entity map[string]interface{}, entityPath, role string, seen map[string]bool, added *[]*models.Redacted) {
+	vcardArray, ok := entity["vcardArray"].([]interface{})
+	if !ok || len(vcardArray) < 2 {
+		return
+	}
+	properties, ok := vcardArray[1].([]interface{})
+	if !ok {
+		return
+	}
+
+	kept := properties[:0]
+	for _, prop := range properties {
+		fields, ok := prop.([]interface{})
+		if !ok || len(fields) < 4 {
+			kept = append(kept, prop)
+			continue
+		}
+		name, _ := fields[0].(string)
+		name = strings.ToLower(name)
+		if !p.fields[name] {
+			kept = append(kept, prop)
+			continue
+		}
+
+		propPath := fmt.Sprintf("%s.vcardArray[1][?(@[0]=='%s')]", entityPath, name)
+		r := &models.Redacted{
+			Name:     &models.RedactedLabel{Description: redactionName(role, name)},
+			PathLang: "jsonpath",
+			Method:   p.method,
+		}
+		if p.reason != "" {
+			r.Reason = &models.RedactedLabel{Description: p.reason}
+		}
+
+		switch p.method {
+		case models.RedactionMethodEmptyValue:
+			fields[3] = replaceValue(fields[3], "")
+			kept = append(kept, fields)
+			r.PostPath = propPath + "[3]"
+		case models.RedactionMethodReplacementValue:
+			fields[3] = replaceValue(fields[3], p.replacement)
+			kept = append(kept, fields)
+			r.PrePath = propPath + "[3]"
+			r.PostPath = propPath + "[3]"
+		default:
+			r.PrePath = propPath
+		}
+
+		if path := r.Path(); !seen[path] {
+			seen[path] = true
+			*added = append(*added, r)
+		}
+	}
+	vcardArray[1] = kept
+}
+
+// redactionName builds a redaction name such as "Registrant Email"
+func redactionName(role, field string) string {
+	label, ok := vcardFieldLabels[field]
+	if !ok {
+		label = field
+	}
+	if role == "" {
+		return label
+	}
+	return strings.ToUpper(role[:1]) + role[1:] + " " + label
+}
+
+// replaceValue replaces every string in a vCard value with text, keeping its shape
+func replaceValue(v interface{}, text string) interface{} {
+	switch val := v.(type) {
+	case []interface{}:
+		out := make([]interface{}, len(val))
+		for i, item := range val {
+			out[i] = replaceValue(item, text)
+		}
+		return out
+	default:
+		return text
+	}
+}
+
+// addConformance appends a value to rdapConformance unless it is already present
+func addConformance(data map[string]interface{}, value string) {
+	conformance, _ := data["rdapConformance"].([]interface{})
+	for _, c := range conformance {
+		if c == value {
+			return
+		}
+	}
+	data["rdapConformance"] = append(conformance, value)
+}
+
+// stringValues returns the string elements of a decoded JSON array
+func stringValues(v interface{}) []string {
+	items, ok := v.([]interface{})
+	if !ok {
+		return nil
+	}
+	out := make([]string, 0, len(items))
+	for _, item := range items {
+		if s, ok := item.(string); ok {
+			out = append(out, s)
+		}
+	}
+	return out
+}
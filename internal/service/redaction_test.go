@@ -0,0 +1,135 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/ohelal/rdap/internal/config"
+	"github.com/ohelal/rdap/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// redactionAnswer has a registrant and a technical contact, and a redaction of its own
+const redactionAnswer = `{
+	"objectClassName": "domain",
+	"ldhName": "example.com",
+	"rdapConformance": ["rdap_level_0"],
+	"entities": [
+		{
+			"objectClassName": "entity",
+			"roles": ["registrant"],
+			"vcardArray": ["vcard", [
+				["version", {}, "text", "4.0"],
+				["fn", {}, "text", "Jane Doe"],
+				["email", {}, "text", "jane@example.com"],
+				["adr", {}, "text", ["", "", "1 Main St", "Springfield", "", "12345", "US"]]
+			]]
+		},
+		{
+			"objectClassName": "entity",
+			"roles": ["technical"],
+			"vcardArray": ["vcard", [
+				["fn", {}, "text", "Tech Support"],
+				["email", {}, "text", "tech@example.com"]
+			]]
+		}
+	],
+	"redacted": [
+		{"name": {"type": "Registry Domain ID"}, "prePath": "$.handle", "method": "removal"}
+	]
+}`
+
+func TestRedaction(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rdap+json")
+		w.Write([]byte(redactionAnswer))
+	}))
+	defer upstream.Close()
+
+	// lookup returns the registrant's vCard properties by name, the technical contact's email
+	// and the parsed redactions
+	lookup := func(t *testing.T, method string) (map[string][]interface{}, interface{}, []*models.Redacted) {
+		cfg := newTestConfig()
+		cfg.Redaction = config.RedactionConfig{
+			Enabled:     true,
+			Roles:       []string{"Registrant"},
+			Fields:      []string{"email", "adr"},
+			Method:      method,
+			Reason:      "Server policy",
+			Replacement: "https://example.com/contact",
+		}
+		resp, err := newTestApp(newTestService(t, cfg, upstream.URL)).Test(httptest.NewRequest(http.MethodGet, "/domain/example.com", nil))
+		require.NoError(t, err)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var data map[string]interface{}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&data))
+		assert.Contains(t, data["rdapConformance"], models.RedactedConformance)
+		redacted, err := models.ParseRedacted(data)
+		require.NoError(t, err)
+
+		vcard := func(i int) map[string][]interface{} {
+			entity := data["entities"].([]interface{})[i].(map[string]interface{})
+			props := make(map[string][]interface{})
+			for _, prop := range entity["vcardArray"].([]interface{})[1].([]interface{}) {
+				fields := prop.([]interface{})
+				props[fields[0].(string)] = fields
+			}
+			return props
+		}
+		return vcard(0), vcard(1)["email"][3], redacted
+	}
+
+	// assertRedacted checks the upstream redaction is kept and one is added per redacted field
+	assertRedacted := func(t *testing.T, redacted []*models.Redacted, method string) {
+		require.Len(t, redacted, 3)
+		assert.Equal(t, "$.handle", redacted[0].Path())
+		for i, name := range []string{"email", "adr"} {
+			r := redacted[i+1]
+			assert.Equal(t, method, r.RedactionMethod())
+			assert.Equal(t, "jsonpath", r.PathLang)
+			assert.Equal(t, "Server policy", r.Reason.String())
+			assert.Contains(t, r.Path(), "$.entities[?(@.roles[0]=='registrant')].vcardArray[1][?(@[0]=='"+name+"')]")
+		}
+		assert.Equal(t, "Registrant Email", redacted[1].Name.String())
+		assert.Equal(t, "Registrant Address", redacted[2].Name.String())
+	}
+
+	t.Run("Removal", func(t *testing.T) {
+		registrant, technical, redacted := lookup(t, models.RedactionMethodRemoval)
+		assertRedacted(t, redacted, models.RedactionMethodRemoval)
+		assert.Equal(t, "$.entities[?(@.roles[0]=='registrant')].vcardArray[1][?(@[0]=='email')]", redacted[1].PrePath)
+		assert.Empty(t, redacted[1].PostPath)
+
+		assert.NotContains(t, registrant, "email")
+		assert.NotContains(t, registrant, "adr")
+		assert.Equal(t, "Jane Doe", registrant["fn"][3])
+		assert.Equal(t, "tech@example.com", technical, "other roles are left alone")
+	})
+
+	t.Run("Empty value", func(t *testing.T) {
+		registrant, technical, redacted := lookup(t, models.RedactionMethodEmptyValue)
+		assertRedacted(t, redacted, models.RedactionMethodEmptyValue)
+		assert.Equal(t, "$.entities[?(@.roles[0]=='registrant')].vcardArray[1][?(@[0]=='email')][3]", redacted[1].PostPath)
+		assert.Empty(t, redacted[1].PrePath)
+
+		assert.Equal(t, "", registrant["email"][3])
+		assert.Equal(t, []interface{}{"", "", "", "", "", "", ""}, registrant["adr"][3], "structured values keep their shape")
+		assert.Equal(t, "Jane Doe", registrant["fn"][3])
+		assert.Equal(t, "tech@example.com", technical)
+	})
+
+	t.Run("Replacement value", func(t *testing.T) {
+		registrant, technical, redacted := lookup(t, models.RedactionMethodReplacementValue)
+		assertRedacted(t, redacted, models.RedactionMethodReplacementValue)
+		assert.Equal(t, "$.entities[?(@.roles[0]=='registrant')].vcardArray[1][?(@[0]=='email')][3]", redacted[1].PostPath)
+
+		contact := "https://example.com/contact"
+		assert.Equal(t, contact, registrant["email"][3])
+		assert.Equal(t, []interface{}{contact, contact, contact, contact, contact, contact, contact}, registrant["adr"][3])
+		assert.Equal(t, "tech@example.com", technical)
+	})
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ohelal/rdap/internal/cache"
+	"github.com/ohelal/rdap/internal/models"
+)
+
+// Reasons for serving a stale answer, reported in the X-RDAP-Stale header
+const (
+	staleRevalidating  = "revalidating"
+	staleUpstreamError = "upstream-error"
+)
+
+// staleNoticeTitle identifies the notice added to stale answers
+const staleNoticeTitle = "Stale Response"
+
+// staleWarnings maps stale reasons to RFC 7234 Warning header values
+var staleWarnings = map[string]string{
+	staleRevalidating:  `110 - "Response is Stale"`,
+	staleUpstreamError: `111 - "Revalidation Failed"`,
+}
+
+// writeStale sends a cached answer past its freshness lifetime, marked with a Warning header,
+// an X-RDAP-Stale header and a notice explaining why it was served
+func (s *RDAPService) writeStale(c *fiber.Ctx, entry *cache.Entry, reason string) error {
+	body, err := entry.Plain()
+	if err != nil {
+		return err
+	}
+	resp := entryResponse(entry, body)
+	if resp.ETag == "" {
+		resp.ETag = answerETag(body)
+	}
+	resp.Body = rewriteJSON(resp.Body, func(data map[string]interface{}) bool {
+		addNotice(data, staleNotice(entry, reason))
+		return true
+	})
+
+	c.Locals(cacheStatusLocal, CacheStatusStale)
+	c.Set(HeaderWarning, staleWarnings[reason])
+	c.Set(HeaderStale, reason)
+	staleResponses.WithLabelValues(reason).Inc()
+	return s.writeResponse(c, resp, CacheStatusStale)
+}
+
+// staleNotice builds an RDAP notice describing why an out-of-date answer was served
+func staleNotice(entry *cache.Entry, reason string) *models.Notice {
+	why := "a fresh copy is being retrieved"
+	if reason == staleUpstreamError {
+		why = "the registry could not be reached"
+	}
+	return &models.Notice{
+		Title: staleNoticeTitle,
+		Description: []string{
+			fmt.Sprintf("This response was retrieved at %s and stopped being fresh at %s.",
+				entry.StoredAt.UTC().Format(time.RFC3339), entry.ExpiresAt.UTC().Format(time.RFC3339)),
+			fmt.Sprintf("It is served from cache because %s.", why),
+		},
+	}
+}
+
+// refreshInBackground fetches a fresh copy of a lookup into the cache, revalidating the stale
+// entry, running at most one refresh per key at a time. Lookups of the key that miss the
+// cache meanwhile share the refresh.
+func (s *RDAPService) refreshInBackground(l lookup, stale *cache.Entry) {
+	if _, running := s.refreshing.LoadOrStore(l.key, struct{}{}); running {
+		return
+	}
+
+	go func() {
+		defer s.refreshing.Delete(l.key)
+
+		resp, err := s.fetchShared(context.Background(), l, stale)
+		switch {
+		case err != nil:
+			cacheRefreshes.WithLabelValues("error").Inc()
+			log.Printf("Background refresh of %s failed: %v", l.key, err)
+		case resp.StatusCode != http.StatusOK:
+			cacheRefreshes.WithLabelValues("error").Inc()
+		default:
+			cacheRefreshes.WithLabelValues("success").Inc()
+		}
+	}()
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ohelal/rdap/internal/cache"
+	"github.com/ohelal/rdap/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestAnswer returns an upstream answer for example.com fetched age ago, fresh for ten minutes
+func newTestAnswer(baseURL string, age time.Duration) *upstreamResponse {
+	fetched := time.Now().Add(-age)
+	header := make(http.Header)
+	header.Set("Content-Type", "application/rdap+json")
+	header.Set("Cache-Control", "max-age=600")
+	header.Set("Date", fetched.UTC().Format(http.TimeFormat))
+	return &upstreamResponse{
+		URL:        baseURL + "/domain/example.com",
+		StatusCode: http.StatusOK,
+		Header:     header,
+		Body:       []byte(`{"objectClassName":"domain","ldhName":"example.com"}`),
+		Attempts:   1,
+		FetchedAt:  fetched,
+	}
+}
+
+func TestStaleAnswers(t *testing.T) {
+	var status, requests int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.Header().Set("Content-Type", "application/rdap+json")
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+		w.Write([]byte(`{"objectClassName":"domain","ldhName":"example.com","port43":"whois.example"}`))
+	}))
+	defer upstream.Close()
+
+	// newService caches an answer that stopped being fresh ten minutes ago
+	newService := func(t *testing.T, staleWhileRevalidate, staleIfError time.Duration) *RDAPService {
+		cfg := newTestConfig()
+		cfg.Cache.StaleWhileRevalidate = staleWhileRevalidate
+		cfg.Cache.StaleIfError = staleIfError
+		s := newTestService(t, cfg, upstream.URL)
+		l := newDomainLookup("example.com")
+		s.storeCached(context.Background(), l, l.key, newTestAnswer(upstream.URL, 20*time.Minute))
+		return s
+	}
+	get := func(t *testing.T, s *RDAPService) (*http.Response, models.RDAPResponse) {
+		resp, err := newTestApp(s).Test(httptest.NewRequest(http.MethodGet, "/domain/example.com", nil))
+		require.NoError(t, err)
+		var body models.RDAPResponse
+		json.NewDecoder(resp.Body).Decode(&body)
+		return resp, body
+	}
+	assertStale := func(t *testing.T, resp *http.Response, body models.RDAPResponse, reason string) {
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, CacheStatusStale, resp.Header.Get(HeaderCache))
+		assert.Equal(t, reason, resp.Header.Get(HeaderStale))
+		assert.Equal(t, staleWarnings[reason], resp.Header.Get(HeaderWarning))
+		assert.Empty(t, body.Port43, "the cached answer is served")
+		require.Len(t, body.Notices, 1)
+		assert.Equal(t, staleNoticeTitle, body.Notices[0].Title)
+	}
+
+	t.Run("Stale while revalidate", func(t *testing.T) {
+		atomic.StoreInt32(&status, http.StatusOK)
+		s := newService(t, time.Hour, time.Hour)
+		before := atomic.LoadInt32(&requests)
+
+		resp, body := get(t, s)
+		assertStale(t, resp, body, staleRevalidating)
+
+		// The refresh runs in the background and replaces the entry
+		require.Eventually(t, func() bool {
+			entry, _, found := s.cache.GetEntry(context.Background(), cache.Key(cache.TypeDomain, "example.com"))
+			return found && !entry.Expired(time.Now())
+		}, time.Second, 10*time.Millisecond)
+		resp, body = get(t, s)
+		assert.Equal(t, CacheStatusHit, resp.Header.Get(HeaderCache))
+		assert.Equal(t, "whois.example", body.Port43)
+		assert.Equal(t, before+1, atomic.LoadInt32(&requests))
+	})
+
+	t.Run("Stale if error", func(t *testing.T) {
+		atomic.StoreInt32(&status, http.StatusServiceUnavailable)
+		s := newService(t, 0, time.Hour)
+
+		resp, body := get(t, s)
+		assertStale(t, resp, body, staleUpstreamError)
+	})
+
+	t.Run("Too stale to serve on error", func(t *testing.T) {
+		atomic.StoreInt32(&status, http.StatusServiceUnavailable)
+		s := newService(t, 0, 5*time.Minute)
+
+		resp, _ := get(t, s)
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+		assert.Empty(t, resp.Header.Get(HeaderStale))
+	})
+}
@@ -0,0 +1,85 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// upstreamTimeout returns the timeout for a request to a host. With adaptive timeouts enabled
+// it is the configured percentile of the host's recent latency times a safety factor, kept
+// within the configured bounds; otherwise, and until enough samples exist, RDAP.Timeout applies.
+func (s *RDAPService) upstreamTimeout(host string) time.Duration {
+	cfg := s.ServiceConfig.Timeouts
+	if !cfg.Enabled {
+		return s.ServiceConfig.RDAP.Timeout
+	}
+
+	timeout := s.ServiceConfig.RDAP.Timeout
+	if observed, ok := s.latency.Percentile(host, cfg.Percentile, cfg.MinSamples); ok {
+		timeout = time.Duration(float64(observed) * cfg.Factor)
+	}
+	if timeout < cfg.MinTimeout {
+		timeout = cfg.MinTimeout
+	}
+	if timeout > cfg.MaxTimeout {
+		timeout = cfg.MaxTimeout
+	}
+
+	upstreamTimeoutSeconds.WithLabelValues(host).Set(timeout.Seconds())
+	return timeout
+}
+
+// observeTimeout records a request cut off by its own deadline as a sample of the full timeout,
+// so a registry that slows down raises its learned timeout instead of failing forever
+func (s *RDAPService) observeTimeout(ctx context.Context, host string, timeout time.Duration, err error) {
+	if errors.Is(err, context.DeadlineExceeded) && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		s.latency.Observe(host, timeout)
+	}
+}
+
+// UpstreamStats describes the latency observed for an upstream host and the timeout derived from it
+type UpstreamStats struct {
+	Host         string  `json:"host"`
+	Samples      int     `json:"samples"`
+	Observations uint64  `json:"observations"`
+	P50          float64 `json:"p50Seconds"`
+	P90          float64 `json:"p90Seconds"`
+	P99          float64 `json:"p99Seconds"`
+	Timeout      float64 `json:"timeoutSeconds"`
+	Adaptive     bool    `json:"adaptive"`
+}
+
+// UpstreamStats returns latency statistics and learned timeouts for every upstream host seen so far
+func (s *RDAPService) UpstreamStats() []UpstreamStats {
+	cfg := s.ServiceConfig.Timeouts
+	hosts := s.latency.Hosts()
+	stats := make([]UpstreamStats, 0, len(hosts))
+	for _, host := range hosts {
+		samples, total := s.latency.sorted(host)
+		if len(samples) == 0 {
+			continue
+		}
+		stats = append(stats, UpstreamStats{
+			Host:         host,
+			Samples:      len(samples),
+			Observations: total,
+			P50:          percentileOf(samples, 50).Seconds(),
+			P90:          percentileOf(samples, 90).Seconds(),
+			P99:          percentileOf(samples, 99).Seconds(),
+			Timeout:      s.upstreamTimeout(host).Seconds(),
+			Adaptive:     cfg.Enabled && len(samples) >= cfg.MinSamples,
+		})
+	}
+	return stats
+}
+
+// HandleUpstreamStats serves the per-upstream latency statistics on the admin API
+func (s *RDAPService) HandleUpstreamStats(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{
+		"adaptiveTimeouts": s.ServiceConfig.Timeouts.Enabled,
+		"upstreams":        s.UpstreamStats(),
+	})
+}
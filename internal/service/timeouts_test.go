@@ -0,0 +1,111 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ohelal/rdap/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestTimeouts enables adaptive timeouts of twice the p90 latency, between 100ms and 2s,
+// after ten samples
+func newTestTimeouts() config.AdaptiveTimeoutConfig {
+	return config.AdaptiveTimeoutConfig{
+		Enabled:    true,
+		Percentile: 90,
+		Factor:     2,
+		MinTimeout: 100 * time.Millisecond,
+		MaxTimeout: 2 * time.Second,
+		MinSamples: 10,
+	}
+}
+
+func TestUpstreamTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		enabled  bool
+		samples  []time.Duration
+		expected time.Duration
+	}{
+		{"Disabled", false, repeat(10, 300*time.Millisecond), time.Second},
+		{"Too few samples", true, repeat(9, 300*time.Millisecond), time.Second},
+		{"Percentile times factor", true, repeat(10, 300*time.Millisecond), 600 * time.Millisecond},
+		{"Percentile ignores the slowest tenth", true, append(repeat(9, 300*time.Millisecond), time.Minute), 600 * time.Millisecond},
+		{"Percentile follows the tail", true, append(repeat(8, 100*time.Millisecond), 400*time.Millisecond, 400*time.Millisecond), 800 * time.Millisecond},
+		{"Floor", true, repeat(10, 10*time.Millisecond), 100 * time.Millisecond},
+		{"Ceiling", true, repeat(10, 5*time.Second), 2 * time.Second},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			cfg := newTestConfig()
+			cfg.RDAP.Timeout = time.Second
+			cfg.Timeouts = newTestTimeouts()
+			cfg.Timeouts.Enabled = tc.enabled
+			s := newTestService(t, cfg, "http://rdap.example")
+			for _, d := range tc.samples {
+				s.latency.Observe("rdap.example", d)
+			}
+			assert.Equal(t, tc.expected, s.upstreamTimeout("rdap.example"))
+		})
+	}
+}
+
+// repeat returns n copies of a duration
+func repeat(n int, d time.Duration) []time.Duration {
+	samples := make([]time.Duration, n)
+	for i := range samples {
+		samples[i] = d
+	}
+	return samples
+}
+
+func TestHandleUpstreamStats(t *testing.T) {
+	cfg := newTestConfig()
+	cfg.RDAP.Timeout = time.Second
+	cfg.Timeouts = newTestTimeouts()
+	s := newTestService(t, cfg, "http://rdap.example")
+	for _, d := range repeat(10, 300*time.Millisecond) {
+		s.latency.Observe("rdap.example", d)
+	}
+	s.latency.Observe("new.example", time.Second)
+
+	get := func(enabled bool, token string) *http.Response {
+		req := httptest.NewRequest(http.MethodGet, "/admin/upstreams", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := newTestAdminApp(s, enabled).Test(req)
+		require.NoError(t, err)
+		return resp
+	}
+
+	t.Run("Stats", func(t *testing.T) {
+		resp := get(true, testAdminToken)
+		require.Equal(t, http.StatusOK, resp.StatusCode)
+
+		var body struct {
+			AdaptiveTimeouts bool            `json:"adaptiveTimeouts"`
+			Upstreams        []UpstreamStats `json:"upstreams"`
+		}
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		assert.True(t, body.AdaptiveTimeouts)
+		assert.Equal(t, []UpstreamStats{
+			{Host: "new.example", Samples: 1, Observations: 1, P50: 1, P90: 1, P99: 1, Timeout: 1},
+			{Host: "rdap.example", Samples: 10, Observations: 10, P50: 0.3, P90: 0.3, P99: 0.3, Timeout: 0.6, Adaptive: true},
+		}, body.Upstreams)
+	})
+
+	t.Run("Wrong token", func(t *testing.T) {
+		assert.Equal(t, http.StatusUnauthorized, get(true, "wrong").StatusCode)
+		assert.Equal(t, http.StatusUnauthorized, get(true, "").StatusCode)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, get(false, testAdminToken).StatusCode)
+	})
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/ohelal/rdap/internal/config"
+)
+
+// upstreamTLSConfig builds the TLS client configuration for an upstream host from the
+// transport-wide defaults and the host's own settings. Certificate and CA files are read
+// on every call so rebuilt transports pick up rotated files.
+func upstreamTLSConfig(defaults config.TransportConfig, upstream config.UpstreamTLSConfig) (*tls.Config, error) {
+	minVersion, err := parseTLSVersion(firstNonEmpty(upstream.MinVersion, defaults.MinTLSVersion))
+	if err != nil {
+		return nil, err
+	}
+
+	tlsConfig := &tls.Config{
+		MinVersion:         minVersion,
+		ServerName:         upstream.ServerName,
+		ClientSessionCache: tls.NewLRUClientSessionCache(defaults.TLSSessionCacheSize),
+	}
+
+	caFiles := append(append([]string{}, defaults.CAFiles...), upstream.CAFiles...)
+	if len(caFiles) > 0 {
+		pool, err := x509.SystemCertPool()
+		if err != nil || pool == nil {
+			pool = x509.NewCertPool()
+		}
+		for _, file := range caFiles {
+			pem, err := os.ReadFile(file)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read CA bundle %s: %w", file, err)
+			}
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in CA bundle %s", file)
+			}
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if upstream.CertFile != "" || upstream.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(upstream.CertFile, upstream.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate %s: %w", upstream.CertFile, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// parseTLSVersion converts a version such as "1.2" to its crypto/tls constant
+func parseTLSVersion(version string) (uint16, error) {
+	switch version {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	case "1.1":
+		return tls.VersionTLS11, nil
+	case "1.0":
+		return tls.VersionTLS10, nil
+	default:
+		return 0, fmt.Errorf("unsupported TLS version: %s", version)
+	}
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,175 @@
+package service
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ohelal/rdap/internal/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writePEM writes a PEM block to a file in the test's temporary directory
+func writePEM(t *testing.T, name, blockType string, der []byte) string {
+	path := filepath.Join(t.TempDir(), name)
+	require.NoError(t, os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600))
+	return path
+}
+
+// newClientCert creates a self-signed client certificate, returning it with its certificate
+// and key files
+func newClientCert(t *testing.T) (*x509.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "rdap-proxy"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return cert, writePEM(t, "client.pem", "CERTIFICATE", der), writePEM(t, "client-key.pem", "EC PRIVATE KEY", keyDER)
+}
+
+// newTLSRegistry starts an RDAP server over TLS configured by configure, reporting the TLS
+// state of each request on states. It returns the server with its host and CA bundle.
+func newTLSRegistry(t *testing.T, configure func(*tls.Config)) (*httptest.Server, string, string, <-chan *tls.ConnectionState) {
+	states := make(chan *tls.ConnectionState, 8)
+	registry := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		states <- r.TLS
+		w.Header().Set("Content-Type", "application/rdap+json")
+		w.Write([]byte(`{"objectClassName":"domain"}`))
+	}))
+	// Some tests fail the handshake on purpose
+	registry.Config.ErrorLog = log.New(io.Discard, "", 0)
+	registry.TLS = &tls.Config{}
+	if configure != nil {
+		configure(registry.TLS)
+	}
+	registry.StartTLS()
+	t.Cleanup(registry.Close)
+
+	u, err := url.Parse(registry.URL)
+	require.NoError(t, err)
+	return registry, u.Host, writePEM(t, "ca.pem", "CERTIFICATE", registry.Certificate().Raw), states
+}
+
+func TestUpstreamTLS(t *testing.T) {
+	get := func(s *RDAPService, registry *httptest.Server) error {
+		resp, err := s.client.Get(registry.URL + "/domain/example.com")
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		return nil
+	}
+
+	t.Run("CA bundles", func(t *testing.T) {
+		registry, host, bundle, _ := newTLSRegistry(t, nil)
+
+		s := newTestService(t, newTestConfig(), registry.URL)
+		assert.ErrorContains(t, get(s, registry), "certificate signed by unknown authority")
+
+		cfg := newTestConfig()
+		cfg.Transport.CAFiles = []string{bundle}
+		assert.NoError(t, get(newTestService(t, cfg, registry.URL), registry), "global bundle")
+
+		cfg = newTestConfig()
+		cfg.Upstreams = map[string]config.UpstreamConfig{host: {TLS: config.UpstreamTLSConfig{CAFiles: []string{bundle}}}}
+		assert.NoError(t, get(newTestService(t, cfg, registry.URL), registry), "upstream bundle")
+
+		cfg = newTestConfig()
+		cfg.Transport.CAFiles = []string{filepath.Join(t.TempDir(), "missing.pem")}
+		_, err := NewRDAPService(&RDAPBootstrapConfig{}, &RDAPBootstrapConfig{}, &RDAPBootstrapConfig{}, cfg, nil)
+		assert.ErrorContains(t, err, "failed to read CA bundle")
+	})
+
+	t.Run("Client certificate", func(t *testing.T) {
+		clientCert, certFile, keyFile := newClientCert(t)
+		registry, host, bundle, states := newTLSRegistry(t, func(c *tls.Config) {
+			c.ClientAuth = tls.RequireAndVerifyClientCert
+			c.ClientCAs = x509.NewCertPool()
+			c.ClientCAs.AddCert(clientCert)
+		})
+
+		cfg := newTestConfig()
+		cfg.Transport.CAFiles = []string{bundle}
+		assert.Error(t, get(newTestService(t, cfg, registry.URL), registry), "no client certificate")
+
+		cfg.Upstreams = map[string]config.UpstreamConfig{host: {TLS: config.UpstreamTLSConfig{CertFile: certFile, KeyFile: keyFile}}}
+		require.NoError(t, get(newTestService(t, cfg, registry.URL), registry))
+		state := <-states
+		require.Len(t, state.PeerCertificates, 1)
+		assert.Equal(t, "rdap-proxy", state.PeerCertificates[0].Subject.CommonName)
+	})
+
+	t.Run("Minimum TLS version", func(t *testing.T) {
+		registry, host, bundle, states := newTLSRegistry(t, func(c *tls.Config) {
+			c.MaxVersion = tls.VersionTLS12
+		})
+
+		cfg := newTestConfig()
+		cfg.Transport.CAFiles = []string{bundle}
+		cfg.Transport.MinTLSVersion = "1.2"
+		require.NoError(t, get(newTestService(t, cfg, registry.URL), registry))
+		assert.Equal(t, uint16(tls.VersionTLS12), (<-states).Version)
+
+		cfg.Upstreams = map[string]config.UpstreamConfig{host: {TLS: config.UpstreamTLSConfig{MinVersion: "1.3"}}}
+		assert.ErrorContains(t, get(newTestService(t, cfg, registry.URL), registry), "protocol version")
+	})
+
+	t.Run("Server name override", func(t *testing.T) {
+		registry, host, bundle, states := newTLSRegistry(t, nil)
+		withServerName := func(name string) *RDAPService {
+			cfg := newTestConfig()
+			cfg.Transport.CAFiles = []string{bundle}
+			cfg.Upstreams = map[string]config.UpstreamConfig{host: {TLS: config.UpstreamTLSConfig{ServerName: name}}}
+			return newTestService(t, cfg, registry.URL)
+		}
+
+		// The test certificate is issued for example.com
+		require.NoError(t, get(withServerName("example.com"), registry))
+		assert.Equal(t, "example.com", (<-states).ServerName)
+		assert.ErrorContains(t, get(withServerName("rdap.example.net"), registry), "certificate is valid for")
+	})
+
+	t.Run("Reload", func(t *testing.T) {
+		registry, _, bundle, _ := newTLSRegistry(t, nil)
+		s := newTestService(t, newTestConfig(), registry.URL)
+		assert.Error(t, get(s, registry))
+
+		file := filepath.Join(t.TempDir(), "config.yaml")
+		require.NoError(t, os.WriteFile(file, []byte("transport:\n  ca_files: ["+bundle+"]\n"), 0o600))
+		t.Setenv("RDAP_CONFIG", file)
+		require.NoError(t, s.ReloadTransports())
+		assert.NoError(t, get(s, registry))
+
+		// An invalid file keeps the transports in place
+		require.NoError(t, os.WriteFile(file, []byte("transport:\n  min_tls_version: \"2.0\"\n"), 0o600))
+		assert.Error(t, s.ReloadTransports())
+		assert.NoError(t, get(s, registry))
+	})
+}
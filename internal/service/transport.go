@@ -0,0 +1,354 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/ohelal/rdap/internal/config"
+	"github.com/ohelal/rdap/internal/outbound"
+)
+
+// transportPool dispatches upstream requests to a tuned http.Transport per upstream host,
+// so connection limits, idle pools, TLS settings and session caches are not shared between
+// registries. It also attaches each upstream's credentials to the requests sent to it.
+type transportPool struct {
+	mu             sync.RWMutex
+	cfg            *config.Config
+	transports     map[string]*http.Transport
+	authenticators map[string]upstreamAuthenticator
+}
+
+// newTransportPool creates a transport pool, checking the TLS and auth settings of every configured upstream
+func newTransportPool(cfg *config.Config) (*transportPool, error) {
+	p := &transportPool{transports: make(map[string]*http.Transport)}
+	if err := p.Reload(cfg); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// RoundTrip implements http.RoundTripper
+func (p *transportPool) RoundTrip(req *http.Request) (*http.Response, error) {
+	t, err := p.transportFor(req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	// Credentials are added per hop, so redirects to other hosts never receive them
+	auth := p.authenticatorFor(req.URL.Host)
+	if auth != nil {
+		req = req.Clone(req.Context())
+		if err := auth.Authenticate(req); err != nil {
+			return nil, fmt.Errorf("upstream %s: %w", req.URL.Host, err)
+		}
+	}
+
+	resp, err := t.RoundTrip(traceRequest(req))
+	if err == nil && auth != nil && resp.StatusCode == http.StatusUnauthorized {
+		auth.Invalidate()
+	}
+	return resp, err
+}
+
+// tokenTransport sends requests through the transports of a pool without upstream credentials
+type tokenTransport struct {
+	pool *transportPool
+}
+
+// RoundTrip implements http.RoundTripper
+func (t tokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport, err := t.pool.transportFor(req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+	return transport.RoundTrip(traceRequest(req))
+}
+
+// CloseIdleConnections closes idle connections of every upstream transport
+func (p *transportPool) CloseIdleConnections() {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, t := range p.transports {
+		t.CloseIdleConnections()
+	}
+}
+
+// Reload replaces the transport settings and credentials. Transports are rebuilt on their
+// next use, so new CA bundles and client certificates apply without a restart; requests
+// already in flight finish on the old connections.
+func (p *transportPool) Reload(cfg *config.Config) error {
+	if err := validateUpstreamTLS(cfg); err != nil {
+		return err
+	}
+	if err := validateProxies(cfg); err != nil {
+		return err
+	}
+
+	// Token requests use the upstream transports, so they share TLS and egress settings, but
+	// never carry upstream credentials: a token endpoint on an authenticated upstream's host
+	// would otherwise need a token to get one
+	tokenClient := &http.Client{Timeout: cfg.RDAP.Timeout, Transport: tokenTransport{p}}
+	authenticators := make(map[string]upstreamAuthenticator)
+	for host, upstream := range cfg.Upstreams {
+		auth, err := newUpstreamAuthenticator(upstream.Auth, tokenClient)
+		if err != nil {
+			return fmt.Errorf("upstream %s: %w", host, err)
+		}
+		if auth != nil {
+			authenticators[host] = auth
+		}
+	}
+
+	p.mu.Lock()
+	old := p.transports
+	p.cfg = cfg
+	p.transports = make(map[string]*http.Transport)
+	p.authenticators = authenticators
+	p.mu.Unlock()
+
+	for _, t := range old {
+		t.CloseIdleConnections()
+	}
+	return nil
+}
+
+// authenticatorFor returns the credentials configured for a host, matching "host:port" before the bare host name
+func (p *transportPool) authenticatorFor(host string) upstreamAuthenticator {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if auth, ok := p.authenticators[host]; ok {
+		return auth
+	}
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		return p.authenticators[name]
+	}
+	return nil
+}
+
+func (p *transportPool) transportFor(host string) (*http.Transport, error) {
+	p.mu.RLock()
+	t, ok := p.transports[host]
+	p.mu.RUnlock()
+	if ok {
+		return t, nil
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if t, ok := p.transports[host]; ok {
+		return t, nil
+	}
+	t, err := p.newTransport(host)
+	if err != nil {
+		return nil, err
+	}
+	p.transports[host] = t
+	return t, nil
+}
+
+func (p *transportPool) newTransport(host string) (*http.Transport, error) {
+	defaults := p.cfg.Transport
+	upstream, _ := p.cfg.Upstream(host)
+
+	tlsConfig, err := upstreamTLSConfig(defaults, upstream.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("upstream %s: %w", host, err)
+	}
+
+	dialer := &net.Dialer{
+		Timeout:   defaults.DialTimeout,
+		KeepAlive: defaults.KeepAlive,
+	}
+
+	t := &http.Transport{
+		MaxIdleConns:          defaults.MaxIdleConns,
+		MaxIdleConnsPerHost:   firstPositive(upstream.MaxIdleConnsPerHost, defaults.MaxIdleConnsPerHost),
+		MaxConnsPerHost:       firstPositive(upstream.MaxConnsPerHost, defaults.MaxConnsPerHost),
+		IdleConnTimeout:       defaults.IdleConnTimeout,
+		TLSHandshakeTimeout:   defaults.TLSHandshakeTimeout,
+		ExpectContinueTimeout: time.Second,
+		TLSClientConfig:       tlsConfig,
+	}
+	if err := outbound.Configure(t, dialer, proxySettings(p.cfg, upstream)); err != nil {
+		return nil, fmt.Errorf("upstream %s: %w", host, err)
+	}
+
+	// HTTP/2 is negotiated through ALPN, so hosts that only speak HTTP/1.1 keep working
+	if defaults.EnableHTTP2 && !upstream.DisableHTTP2 {
+		t.ForceAttemptHTTP2 = true
+	} else {
+		t.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+	}
+
+	return t, nil
+}
+
+// validateUpstreamTLS builds the TLS configuration of every configured upstream to surface
+// unreadable certificates or bundles before they are used
+func validateUpstreamTLS(cfg *config.Config) error {
+	if _, err := upstreamTLSConfig(cfg.Transport, config.UpstreamTLSConfig{}); err != nil {
+		return fmt.Errorf("transport: %w", err)
+	}
+	for host, upstream := range cfg.Upstreams {
+		if _, err := upstreamTLSConfig(cfg.Transport, upstream.TLS); err != nil {
+			return fmt.Errorf("upstream %s: %w", host, err)
+		}
+	}
+	return nil
+}
+
+// traceRequest attaches an httptrace.ClientTrace recording dial, TLS and TTFB timings
+func traceRequest(req *http.Request) *http.Request {
+	host := req.URL.Host
+	start := time.Now()
+	var dialStart, tlsStart time.Time
+
+	trace := &httptrace.ClientTrace{
+		ConnectStart: func(_, _ string) {
+			dialStart = time.Now()
+		},
+		ConnectDone: func(_, _ string, err error) {
+			if err == nil && !dialStart.IsZero() {
+				upstreamDialDuration.WithLabelValues(host).Observe(time.Since(dialStart).Seconds())
+			}
+		},
+		TLSHandshakeStart: func() {
+			tlsStart = time.Now()
+		},
+		TLSHandshakeDone: func(_ tls.ConnectionState, err error) {
+			if err == nil && !tlsStart.IsZero() {
+				upstreamTLSDuration.WithLabelValues(host).Observe(time.Since(tlsStart).Seconds())
+			}
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			upstreamConnections.WithLabelValues(host, strconv.FormatBool(info.Reused)).Inc()
+		},
+		GotFirstResponseByte: func() {
+			upstreamTTFB.WithLabelValues(host).Observe(time.Since(start).Seconds())
+		},
+	}
+
+	return req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+}
+
+// topRegistries returns the base URLs of the n registries serving the most bootstrap entries
+func (s *RDAPService) topRegistries(n int) []string {
+	s.mu.Lock()
+	counts := make(map[string]int)
+	for _, cfg := range []*RDAPBootstrapConfig{s.DNSConfig, s.IPConfig, s.ASNConfig} {
+		for _, service := range cfg.Services {
+			if len(service) < 2 {
+				continue
+			}
+			entries, _ := service[0].([]interface{})
+			for _, server := range stringValues(service[1]) {
+				counts[server] += len(entries)
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	servers := make([]string, 0, len(counts))
+	for server := range counts {
+		servers = append(servers, server)
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		if counts[servers[i]] != counts[servers[j]] {
+			return counts[servers[i]] > counts[servers[j]]
+		}
+		return servers[i] < servers[j]
+	})
+
+	// Several bootstrap URLs may share one host; only the first per host is kept
+	hosts := make(map[string]bool)
+	top := make([]string, 0, n)
+	for _, server := range servers {
+		u, err := url.Parse(server)
+		if err != nil || u.Host == "" || hosts[u.Host] {
+			continue
+		}
+		hosts[u.Host] = true
+		top = append(top, server)
+		if len(top) == n {
+			break
+		}
+	}
+	return top
+}
+
+// Prewarm opens connections to the busiest registries so the first lookups
+// do not pay for TCP and TLS setup
+func (s *RDAPService) Prewarm(ctx context.Context) {
+	cfg := s.ServiceConfig.Transport
+	if cfg.PrewarmTopRegistries <= 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.PrewarmTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for _, server := range s.topRegistries(cfg.PrewarmTopRegistries) {
+		wg.Add(1)
+		go func(server string) {
+			defer wg.Done()
+			req, err := http.NewRequestWithContext(ctx, http.MethodHead, server, nil)
+			if err != nil {
+				return
+			}
+			resp, err := s.client.Do(req)
+			if err != nil {
+				log.Printf("Failed to pre-warm connection to %s: %v", server, err)
+				return
+			}
+			resp.Body.Close()
+		}(server)
+	}
+	wg.Wait()
+}
+
+func firstPositive(values ...int) int {
+	for _, v := range values {
+		if v > 0 {
+			return v
+		}
+	}
+	return 0
+}
+
+// proxySettings returns the egress proxy for an upstream, which overrides the global proxy when set
+func proxySettings(cfg *config.Config, upstream config.UpstreamConfig) outbound.ProxyConfig {
+	p := cfg.Proxy
+	if upstream.Proxy.URL != "" {
+		p = upstream.Proxy
+	}
+	return outbound.ProxyConfig{
+		URL:      p.URL,
+		Username: p.Username,
+		Password: p.Password,
+		NoProxy:  p.NoProxy,
+	}
+}
+
+// validateProxies checks every proxy setting so a bad reload is rejected before it takes effect
+func validateProxies(cfg *config.Config) error {
+	if err := outbound.Configure(&http.Transport{}, &net.Dialer{}, proxySettings(cfg, config.UpstreamConfig{})); err != nil {
+		return fmt.Errorf("proxy: %w", err)
+	}
+	for host, upstream := range cfg.Upstreams {
+		if err := outbound.Configure(&http.Transport{}, &net.Dialer{}, proxySettings(cfg, upstream)); err != nil {
+			return fmt.Errorf("upstream %s proxy: %w", host, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ohelal/rdap/internal/config"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newRegistry starts an RDAP server counting the HEAD requests used to pre-warm connections
+func newRegistry(t *testing.T) (*httptest.Server, string, *int32) {
+	var heads int32
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			atomic.AddInt32(&heads, 1)
+			return
+		}
+		w.Header().Set("Content-Type", "application/rdap+json")
+		w.Write([]byte(`{"objectClassName":"domain"}`))
+	}))
+	t.Cleanup(registry.Close)
+	u, err := url.Parse(registry.URL)
+	require.NoError(t, err)
+	return registry, u.Host, &heads
+}
+
+func TestUpstreamTransports(t *testing.T) {
+	busy, busyHost, busyHeads := newRegistry(t)
+	quiet, quietHost, quietHeads := newRegistry(t)
+
+	cfg := newTestConfig()
+	cfg.Transport = config.TransportConfig{
+		MaxConnsPerHost:      8,
+		EnableHTTP2:          true,
+		PrewarmTopRegistries: 1,
+		PrewarmTimeout:       time.Second,
+	}
+	cfg.Upstreams = map[string]config.UpstreamConfig{
+		busyHost: {MaxConnsPerHost: 2, DisableHTTP2: true},
+	}
+	s := newTestService(t, cfg, busy.URL)
+	s.DNSConfig.Services = [][]interface{}{
+		{[]interface{}{"com", "org"}, []interface{}{busy.URL + "/"}},
+		{[]interface{}{"net"}, []interface{}{quiet.URL + "/"}},
+	}
+	app := newTestApp(s)
+
+	connections := func(host, reused string) float64 {
+		return testutil.ToFloat64(upstreamConnections.WithLabelValues(host, reused))
+	}
+
+	// Only the registry serving most bootstrap entries is warmed
+	assert.Equal(t, []string{busy.URL + "/"}, s.topRegistries(1))
+	s.Prewarm(context.Background())
+	assert.Equal(t, int32(1), atomic.LoadInt32(busyHeads))
+	assert.Equal(t, int32(0), atomic.LoadInt32(quietHeads))
+
+	for _, path := range []string{"/domain/example.com", "/domain/example.org", "/domain/example.net"} {
+		resp, err := app.Test(httptest.NewRequest(http.MethodGet, path, nil))
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode, path)
+	}
+
+	// Lookups of the warmed registry reuse its connection
+	assert.Equal(t, 1.0, connections(busyHost, "false"))
+	assert.Equal(t, 2.0, connections(busyHost, "true"))
+	assert.Equal(t, 1.0, connections(quietHost, "false"))
+
+	// Each host has its own transport, with the settings of its upstream
+	pool := s.client.Transport.(*transportPool)
+	pool.mu.RLock()
+	defer pool.mu.RUnlock()
+	require.Len(t, pool.transports, 2)
+	tuned, plain := pool.transports[busyHost], pool.transports[quietHost]
+	assert.Equal(t, 2, tuned.MaxConnsPerHost)
+	assert.False(t, tuned.ForceAttemptHTTP2)
+	assert.Equal(t, 8, plain.MaxConnsPerHost)
+	assert.True(t, plain.ForceAttemptHTTP2)
+}
@@ -0,0 +1,327 @@
+package service
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/ohelal/rdap/internal/cache"
+)
+
+// Outcomes of warming one key
+const (
+	warmupCached    = "cached"
+	warmupRefreshed = "refreshed"
+	warmupFailed    = "failed"
+	warmupSkipped   = "skipped"
+)
+
+// warmupRefreshAhead is how long before it expires a cached answer is refreshed by a warm-up
+// run, so it does not expire just after the run
+const warmupRefreshAhead = 5 * time.Minute
+
+// WarmupSource returns up to n cache keys to warm, most important first
+type WarmupSource func(ctx context.Context, n int) ([]string, error)
+
+// FileWarmupSource reads keys to warm from a file with one entry per line, either a cache key
+// such as "domain:example.com" or a query type and query such as "asn 15169". Blank lines and
+// lines starting with # are ignored.
+func FileWarmupSource(path string) WarmupSource {
+	return func(_ context.Context, n int) ([]string, error) {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		var keys []string
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() && len(keys) < n {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			queryType, query, found := strings.Cut(line, " ")
+			if !found {
+				queryType, query, found = strings.Cut(line, ":")
+			}
+			if !found {
+				continue
+			}
+			if key, ok := QueryKey(queryType, strings.TrimSpace(query)); ok {
+				keys = append(keys, key)
+			}
+		}
+		return keys, scanner.Err()
+	}
+}
+
+// QueryKey returns the cache key for a query of type "ip", "domain", "autnum" or "asn"
+func QueryKey(queryType, query string) (string, bool) {
+	switch queryType {
+	case cache.TypeDomain, cache.TypeIP, cache.TypeAutnum:
+		return cache.Key(queryType, query), query != ""
+	case "asn":
+		return cache.Key(cache.TypeAutnum, query), query != ""
+	}
+	return "", false
+}
+
+// Ready reports whether the service may receive traffic, which is once the startup warm-up
+// has completed enough of its keys or timed out
+func (s *RDAPService) Ready() bool {
+	return !s.warming.Load()
+}
+
+// HandleReady answers readiness probes
+func (s *RDAPService) HandleReady(c *fiber.Ctx) error {
+	if !s.Ready() {
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{"status": "warming"})
+	}
+	return c.JSON(fiber.Map{"status": "ready"})
+}
+
+// StartWarmup refreshes the most requested keys from sources now and every Interval until ctx
+// is done. Readiness is held from this call until ReadyFraction of the first run has completed
+// or ReadyTimeout has passed.
+func (s *RDAPService) StartWarmup(ctx context.Context, sources []WarmupSource) {
+	cfg := s.ServiceConfig.Warmup
+	if !cfg.Enabled || s.cache == nil || len(sources) == 0 {
+		return
+	}
+
+	s.warming.Store(true)
+	if cfg.ReadyTimeout > 0 {
+		timer := time.AfterFunc(cfg.ReadyTimeout, func() {
+			if s.warming.Swap(false) {
+				log.Printf("Cache warm-up did not finish within %s; marking ready", cfg.ReadyTimeout)
+			}
+		})
+		go func() {
+			<-ctx.Done()
+			timer.Stop()
+		}()
+	}
+
+	go func() {
+		for first := true; ; first = false {
+			s.runWarmup(ctx, sources, first)
+			if cfg.Interval <= 0 {
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(cfg.Interval):
+			}
+		}
+	}()
+}
+
+// runWarmup warms the top keys of all sources once, releasing readiness when gate is set
+func (s *RDAPService) runWarmup(ctx context.Context, sources []WarmupSource, gate bool) {
+	cfg := s.ServiceConfig.Warmup
+	start := time.Now()
+	keys := collectWarmupKeys(ctx, sources, cfg.TopN)
+
+	required := int64(math.Ceil(cfg.ReadyFraction * float64(len(keys))))
+	if gate && required == 0 {
+		s.warming.Store(false)
+	}
+
+	var done int64
+	results := make(map[string]int)
+	var resultsMu sync.Mutex
+	polite := newPoliteness(cfg.PerUpstream, cfg.UpstreamDelay)
+	sem := make(chan struct{}, cfg.Concurrency)
+	var wg sync.WaitGroup
+
+	for _, key := range keys {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		}
+		wg.Add(1)
+		go func(key string) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			result := s.warmKey(ctx, key, polite)
+			warmupKeys.WithLabelValues(result).Inc()
+
+			resultsMu.Lock()
+			results[result]++
+			resultsMu.Unlock()
+
+			n := atomic.AddInt64(&done, 1)
+			warmupProgress.Set(float64(n) / float64(len(keys)))
+			if gate && n == required {
+				s.warming.Store(false)
+			}
+		}(key)
+	}
+	wg.Wait()
+
+	warmupDuration.Set(time.Since(start).Seconds())
+	log.Printf("Cache warm-up of %d keys finished in %s: %d cached, %d refreshed, %d failed, %d skipped",
+		len(keys), time.Since(start).Round(time.Millisecond),
+		results[warmupCached], results[warmupRefreshed], results[warmupFailed], results[warmupSkipped])
+}
+
+// collectWarmupKeys merges the keys of all sources, dropping duplicates, up to n keys
+func collectWarmupKeys(ctx context.Context, sources []WarmupSource, n int) []string {
+	seen := make(map[string]bool)
+	var keys []string
+	for _, source := range sources {
+		sourceKeys, err := source(ctx, n)
+		if err != nil {
+			log.Printf("Failed to read cache warm-up keys: %v", err)
+			continue
+		}
+		for _, key := range sourceKeys {
+			if len(keys) == n {
+				return keys
+			}
+			if !seen[key] {
+				seen[key] = true
+				keys = append(keys, key)
+			}
+		}
+	}
+	return keys
+}
+
+// warmKey makes sure a fresh answer for key is in the local cache, copying it from Redis or
+// fetching it from its registry when it expires within warmupRefreshAhead
+func (s *RDAPService) warmKey(ctx context.Context, key string, polite *politeness) string {
+	entry, err := s.cache.Get(ctx, key)
+	if err == nil && entry.TTL(time.Now()) > warmupRefreshAhead {
+		return warmupCached
+	}
+
+	l, ok := s.warmupLookup(key)
+	if !ok {
+		return warmupSkipped
+	}
+	urls := s.upstreamURLs(l.servers, l.path)
+	if len(urls) == 0 {
+		return warmupSkipped
+	}
+
+	release, err := polite.acquire(ctx, hostFromURL(urls[0]))
+	if err != nil {
+		return warmupFailed
+	}
+	defer release()
+
+	resp, err := s.fetchShared(ctx, l, entry)
+	if err != nil || resp.StatusCode >= 500 {
+		return warmupFailed
+	}
+	return warmupRefreshed
+}
+
+// warmupLookup builds the lookup that refreshes a cache key
+func (s *RDAPService) warmupLookup(key string) (lookup, bool) {
+	objectType, query, ok := strings.Cut(key, ":")
+	if !ok || query == "" {
+		return lookup{}, false
+	}
+	l := lookup{objectType: objectType, key: key}
+
+	switch objectType {
+	case cache.TypeDomain:
+		labels := strings.Split(query, ".")
+		if len(labels) < 2 {
+			return lookup{}, false
+		}
+		l.path = "domain/" + query
+		l.servers = s.findRDAPServersForTLD(labels[len(labels)-1])
+	case cache.TypeIP:
+		// Network keys are refreshed through the first address of the network
+		if network, ok := strings.CutPrefix(query, "net:"); ok {
+			query, _, _ = strings.Cut(network, "-")
+		}
+		l.path = "ip/" + query
+		l.servers = s.findRDAPServersForIP(query)
+	case cache.TypeAutnum:
+		asn, err := strconv.ParseInt(query, 10, 64)
+		if err != nil {
+			return lookup{}, false
+		}
+		l.path = "autnum/" + query
+		l.servers = s.findRDAPServersForASN(asn)
+	default:
+		return lookup{}, false
+	}
+	return l, len(l.servers) > 0
+}
+
+// politeness limits warm-up requests to each upstream host to perHost at a time, started at
+// least delay apart
+type politeness struct {
+	mu      sync.Mutex
+	hosts   map[string]*hostSlot
+	perHost int
+	delay   time.Duration
+}
+
+type hostSlot struct {
+	sem  chan struct{}
+	mu   sync.Mutex
+	next time.Time
+}
+
+func newPoliteness(perHost int, delay time.Duration) *politeness {
+	return &politeness{hosts: make(map[string]*hostSlot), perHost: perHost, delay: delay}
+}
+
+// acquire waits for a slot on host and returns the function that releases it
+func (p *politeness) acquire(ctx context.Context, host string) (func(), error) {
+	p.mu.Lock()
+	slot, ok := p.hosts[host]
+	if !ok {
+		slot = &hostSlot{sem: make(chan struct{}, p.perHost)}
+		p.hosts[host] = slot
+	}
+	p.mu.Unlock()
+
+	select {
+	case slot.sem <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	release := func() { <-slot.sem }
+
+	slot.mu.Lock()
+	now := time.Now()
+	start := slot.next
+	if start.Before(now) {
+		start = now
+	}
+	slot.next = start.Add(p.delay)
+	slot.mu.Unlock()
+
+	if wait := time.Until(start); wait > 0 {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			release()
+			return nil, ctx.Err()
+		}
+	}
+	return release, nil
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/ohelal/rdap/internal/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWarmup(t *testing.T) {
+	var mu sync.Mutex
+	var fetched []string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		fetched = append(fetched, r.URL.Path)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/rdap+json")
+		w.Header().Set("Cache-Control", "max-age=3600")
+		w.Write([]byte(`{"objectClassName":"domain"}`))
+	}))
+	defer upstream.Close()
+
+	// warmup creates a service whose cache has a Redis tier on m, with warm-up enabled
+	warmup := func(t *testing.T, m *miniredis.Miniredis) *RDAPService {
+		mu.Lock()
+		fetched = nil
+		mu.Unlock()
+		client := redis.NewClient(&redis.Options{Addr: m.Addr()})
+		t.Cleanup(func() { client.Close() })
+
+		cfg := newTestConfig()
+		cfg.Warmup.Enabled = true
+		cfg.Warmup.TopN = 2
+		cfg.Warmup.Concurrency = 2
+		cfg.Warmup.PerUpstream = 2
+		cfg.Warmup.ReadyFraction = 1
+		return newTestServiceWithCache(t, cfg, upstream.URL, &cache.CacheConfig{
+			Tiers: []string{cache.TierLocal, cache.TierRedis},
+			Redis: client,
+		})
+	}
+	store := func(t *testing.T, s *RDAPService, key string, ttl time.Duration) {
+		entry, err := cache.NewEntry([]byte(`{"objectClassName":"domain"}`), ttl)
+		require.NoError(t, err)
+		entry.ObjectType = cache.TypeDomain
+		require.NoError(t, s.cache.SetEntry(context.Background(), key, entry))
+	}
+	fetchedPaths := func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), fetched...)
+	}
+
+	t.Run("Hot keys", func(t *testing.T) {
+		m := miniredis.RunT(t)
+		m.ZAdd("rdap:hotkeys", 5, "domain:a.com")
+		m.ZAdd("rdap:hotkeys", 3, "domain:b.com")
+		m.ZAdd("rdap:hotkeys", 1, "domain:c.com")
+		// Another replica already has a fresh answer for the hottest key
+		store(t, warmup(t, m), "domain:a.com", 2*time.Hour)
+
+		s := warmup(t, m)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		s.StartWarmup(ctx, []WarmupSource{s.cache.HotKeys})
+		assert.False(t, s.Ready(), "readiness is held until the keys are warmed")
+		require.Eventually(t, s.Ready, 5*time.Second, 10*time.Millisecond)
+
+		assert.Equal(t, []string{"/domain/b.com"}, fetchedPaths(), "only the top keys missing from Redis are fetched")
+		for _, key := range []string{"domain:a.com", "domain:b.com"} {
+			_, tier, found := s.cache.GetEntry(ctx, key)
+			require.True(t, found, key)
+			assert.Equal(t, cache.TierLocal, tier, key)
+		}
+		_, err := s.cache.Get(ctx, "domain:c.com")
+		assert.ErrorIs(t, err, cache.ErrCacheMiss)
+	})
+
+	t.Run("Entries near expiry", func(t *testing.T) {
+		s := warmup(t, miniredis.RunT(t))
+		store(t, s, "domain:fresh.com", 30*time.Minute)
+		store(t, s, "domain:soon.com", 2*time.Minute)
+
+		s.runWarmup(context.Background(), []WarmupSource{func(context.Context, int) ([]string, error) {
+			return []string{"domain:fresh.com", "domain:soon.com"}, nil
+		}}, false)
+		assert.Equal(t, []string{"/domain/soon.com"}, fetchedPaths(), "entries about to expire are refreshed")
+
+		entry, err := s.cache.Get(context.Background(), "domain:soon.com")
+		require.NoError(t, err)
+		assert.Greater(t, entry.TTL(time.Now()), 50*time.Minute)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		m := miniredis.RunT(t)
+		m.ZAdd("rdap:hotkeys", 1, "domain:a.com")
+		s := warmup(t, m)
+		s.ServiceConfig.Warmup.Enabled = false
+
+		called := false
+		s.StartWarmup(context.Background(), []WarmupSource{func(ctx context.Context, n int) ([]string, error) {
+			called = true
+			return s.cache.HotKeys(ctx, n)
+		}})
+		assert.True(t, s.Ready())
+		time.Sleep(50 * time.Millisecond)
+		assert.False(t, called)
+		assert.Empty(t, fetchedPaths())
+	})
+}